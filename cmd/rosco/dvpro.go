@@ -0,0 +1,465 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/mkv"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
+)
+
+// dvproOptions are the options for the "export dvpro" command.
+type dvproOptions struct {
+	OutputDirectory string // If empty, the output files go next to the input files.
+	Format          string // Either "avi" or "mkv".
+	MetadataTrack   bool   // Include the per-frame metadata as a text track (mkv only).
+	Start           string // If set, only export from this point on (see "parseTimeRange").
+	End             string // If set, only export up to this point (see "parseTimeRange").
+	Subtitles       string // If set, also write subtitles in this format ("srt" or "vtt").
+}
+
+// dvproInputFiles expands the list of files and/or directories into a list of files.
+//
+// Directories are searched (non-recursively) for ".nvr" and ".asd" files.
+func dvproInputFiles(args []string) ([]string, error) {
+	return findInputFiles(args, false)
+}
+
+// findInputFiles expands the list of files and/or directories into a list of files.
+//
+// Directories are searched for ".nvr" and ".asd" files, in any case (FAT cards often have ".NVR").
+// If `recursive` is true, then the subdirectories are searched too.
+func findInputFiles(args []string, recursive bool) ([]string, error) {
+	inputFiles := []string{}
+	for _, arg := range args {
+		fileInfo, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if fileInfo.IsDir() {
+			files, err := findInputFilesInDirectory(arg, recursive)
+			if err != nil {
+				return nil, err
+			}
+			inputFiles = append(inputFiles, files...)
+		} else {
+			inputFiles = append(inputFiles, arg)
+		}
+	}
+	return inputFiles, nil
+}
+
+// findInputFilesInDirectory returns the ".nvr" and ".asd" files in a directory.
+func findInputFilesInDirectory(directory string, recursive bool) ([]string, error) {
+	fileInfos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	inputFiles := []string{}
+	for _, fileInfo := range fileInfos {
+		filename := strings.TrimSuffix(directory, "/") + "/" + fileInfo.Name()
+		if fileInfo.IsDir() {
+			if recursive {
+				files, err := findInputFilesInDirectory(filename, recursive)
+				if err != nil {
+					return nil, err
+				}
+				inputFiles = append(inputFiles, files...)
+			}
+			continue
+		}
+		switch strings.ToLower(path.Ext(fileInfo.Name())) {
+		case ".nvr", ".asd":
+			inputFiles = append(inputFiles, filename)
+		}
+	}
+	return inputFiles, nil
+}
+
+// dvproFilter picks which of the input files to export.
+type dvproFilter struct {
+	Include []string  // If set, only the files whose names match one of these globs.
+	Exclude []string  // The files whose names match any of these globs are left out.
+	From    time.Time // If set, only the files that were recording at or after this time.
+	To      time.Time // If set, only the files that were recording before this time.
+}
+
+// Validate checks the globs.
+func (f dvproFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchesGlob returns true if the name of the file matches any of the globs.
+//
+// Like the file extensions, the globs are matched without regard to case.
+func matchesGlob(filename string, patterns []string) bool {
+	name := strings.ToLower(path.Base(filename))
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the input files that pass the filter, in the same order.
+//
+// The date range is checked against the time that each file covers, according to its header and
+// filename, or the file itself if those don't say when it ended (see "findRecordings"); files whose
+// time can't be determined are left out.
+func (f dvproFilter) Apply(inputFiles []string) []string {
+	files := []string{}
+	for _, inputFile := range inputFiles {
+		if len(f.Include) > 0 && !matchesGlob(inputFile, f.Include) {
+			continue
+		}
+		if matchesGlob(inputFile, f.Exclude) {
+			continue
+		}
+		files = append(files, inputFile)
+	}
+
+	if f.From.IsZero() && f.To.IsZero() {
+		return files
+	}
+	to := f.To
+	if to.IsZero() {
+		to = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	}
+	inRange := map[string]bool{}
+	for _, filename := range findRecordings(files, f.From, to) {
+		inRange[filename] = true
+	}
+	filteredFiles := []string{}
+	for _, inputFile := range files {
+		if inRange[inputFile] {
+			filteredFiles = append(filteredFiles, inputFile)
+		}
+	}
+	return filteredFiles
+}
+
+// dvproOutput is a file that will be created when exporting.
+type dvproOutput struct {
+	Path          string
+	StreamIDs     []string // The logical streams in the file.
+	Subtitles     bool     // True if this is a subtitle file rather than a video.
+	EstimatedSize int64    // In bytes; this is -1 if there is no good estimate (subtitles).
+}
+
+// dvproPlan is what will be done to export a single file.
+//
+// A plan starts out with only what the file's header says (where the output files go); `Load` reads
+// the rest of the file to work out the outputs.
+type dvproPlan struct {
+	InputFile string
+	Options   dvproOptions
+	BasePath  string          // The path of the output files, without the stream number or the extension.
+	Info      *rosco.FileInfo // Trimmed to the time range; this is nil until the plan is loaded.
+	Outputs   []dvproOutput   // Empty if there is nothing to export; this is nil until the plan is loaded.
+}
+
+// Exists returns true if the output files from an earlier export already exist.
+//
+// This only needs the header: the output files are renamed into place together once they have all been
+// written (see "writeDvproPlan"), so the first one is only there if they all are.
+func (p *dvproPlan) Exists() bool {
+	filename := p.BasePath + ".mkv"
+	if p.Options.Format == "avi" {
+		filename = p.BasePath + "_1.avi"
+	}
+	filenames := []string{filename}
+	if p.Options.Subtitles != "" {
+		filenames = append(filenames, subtitlesFilename(filename, p.Options.Subtitles))
+	}
+	for _, filename := range filenames {
+		if _, err := os.Stat(filename); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// EstimatedSize returns the total estimated size of the output files, in bytes.
+func (p *dvproPlan) EstimatedSize() int64 {
+	var size int64
+	for _, output := range p.Outputs {
+		if output.EstimatedSize > 0 {
+			size += output.EstimatedSize
+		}
+	}
+	return size
+}
+
+// The rough overhead of the containers, for estimating the size of the output files.
+const (
+	aviHeaderSize    = 5 * 1024 // The headers, with their padding.
+	aviChunkOverhead = 24       // The chunk header and the index entry.
+	mkvHeaderSize    = 1024     // The headers and the cues.
+	mkvFrameOverhead = 16       // The cluster and block headers.
+)
+
+// estimateStreamSize estimates the number of bytes that a logical stream's video and audio will take up.
+//
+// The video is copied as-is.  In an AVI file, the audio is decoded into PCM (and the gaps are filled with
+// silence), so its size comes from its duration instead.  In an MKV file, Opus audio is copied as-is.
+func estimateStreamSize(info *rosco.FileInfo, streamID string, format string) int64 {
+	chunkOverhead := int64(aviChunkOverhead)
+	if format == "mkv" {
+		chunkOverhead = mkvFrameOverhead
+	}
+
+	var size int64
+	for _, chunk := range info.Chunks {
+		if chunk.Video != nil && strings.HasPrefix(chunk.ID, streamID) {
+			size += int64(len(chunk.Video.Media)) + chunkOverhead
+		}
+	}
+
+	audioTrack, err := roscoconv.MakeAudioTrack(info, streamID)
+	if err != nil {
+		return size // No audio.
+	}
+	if format == "avi" {
+		first := audioTrack.Frames[0].Timestamp
+		last := audioTrack.Frames[len(audioTrack.Frames)-1].Timestamp
+		bytesPerSecond := int64(audioTrack.SampleRate * audioTrack.ChannelCount * audioTrack.BitDepth / 8)
+		size += int64(last-first)*bytesPerSecond/1000000 + int64(len(audioTrack.Frames))*chunkOverhead
+	} else {
+		// In an MKV file, PCM audio is expanded to 16 bits.
+		scale := int64(1)
+		if audioTrack.Codec == roscoconv.AudioCodecPCM && audioTrack.BitDepth == 8 {
+			scale = 2
+		}
+		for _, frame := range audioTrack.Frames {
+			size += int64(len(frame.Media))*scale + chunkOverhead
+		}
+	}
+	return size
+}
+
+// planDvproFile starts the plan for exporting a single file; this only reads the file's header.
+func planDvproFile(inputFile string, options dvproOptions) (*dvproPlan, error) {
+	header, err := parseFilename(inputFile, true)
+	if err != nil {
+		return nil, err
+	}
+	switch options.Format {
+	case "avi", "mkv":
+	default:
+		return nil, fmt.Errorf("invalid video format: %s", options.Format)
+	}
+
+	destinationFolder := options.OutputDirectory
+	if len(options.OutputDirectory) == 0 {
+		destinationFolder = path.Dir(inputFile)
+	}
+	destinationBaseName := path.Base(inputFile)
+	switch strings.ToLower(path.Ext(destinationBaseName)) {
+	case ".nvr":
+		destinationBaseName = strings.TrimSuffix(destinationBaseName, path.Ext(destinationBaseName))
+	case ".asd":
+		destinationBaseName = path.Base(header.Filename)
+		destinationBaseName = strings.TrimSuffix(destinationBaseName, path.Ext(destinationBaseName))
+	}
+	basePath := destinationBaseName
+	if len(destinationFolder) > 0 {
+		basePath = strings.TrimSuffix(destinationFolder, "/") + "/" + destinationBaseName
+	}
+
+	return &dvproPlan{
+		InputFile: inputFile,
+		Options:   options,
+		BasePath:  basePath,
+	}, nil
+}
+
+// Load reads the whole file, trims it to the time range, and works out which files exporting it will create.
+func (p *dvproPlan) Load() error {
+	info, err := parseFilename(p.InputFile, false)
+	if err != nil {
+		return err
+	}
+	p.Info = info
+	p.Outputs = []dvproOutput{}
+
+	// Only export the part of the file in the time range; a file that is entirely outside of it is skipped.
+	options := p.Options
+	if options.Start != "" || options.End != "" {
+		start, end, err := parseTimeRange(info, options.Start, options.End)
+		if err != nil {
+			return err
+		}
+		if !hasChunksInRange(info, start, end) {
+			fmt.Printf("Skipping %s: it has nothing in the time range.\n", p.InputFile)
+			return nil
+		}
+		p.Info, err = roscoconv.Trim(info, start, end)
+		if err != nil {
+			return err
+		}
+		info = p.Info
+	}
+
+	logicalStreamIDs := roscoconv.LogicalStreamIDs(info)
+
+	switch options.Format {
+	case "avi":
+		for streamIndex, streamID := range logicalStreamIDs {
+			destinationFullPath := fmt.Sprintf("%s_%d.avi", p.BasePath, streamIndex+1)
+			p.Outputs = append(p.Outputs, dvproOutput{
+				Path:          destinationFullPath,
+				StreamIDs:     []string{streamID},
+				EstimatedSize: aviHeaderSize + estimateStreamSize(info, streamID, options.Format),
+			})
+			if options.Subtitles != "" {
+				p.Outputs = append(p.Outputs, dvproOutput{
+					Path:          subtitlesFilename(destinationFullPath, options.Subtitles),
+					StreamIDs:     []string{streamID},
+					Subtitles:     true,
+					EstimatedSize: -1,
+				})
+			}
+		}
+	case "mkv":
+		destinationFullPath := p.BasePath + ".mkv"
+		size := int64(mkvHeaderSize)
+		for _, streamID := range logicalStreamIDs {
+			size += estimateStreamSize(info, streamID, options.Format)
+		}
+		p.Outputs = append(p.Outputs, dvproOutput{
+			Path:          destinationFullPath,
+			StreamIDs:     logicalStreamIDs,
+			EstimatedSize: size,
+		})
+		if options.Subtitles != "" {
+			p.Outputs = append(p.Outputs, dvproOutput{
+				Path:          subtitlesFilename(destinationFullPath, options.Subtitles),
+				StreamIDs:     logicalStreamIDs,
+				Subtitles:     true,
+				EstimatedSize: -1,
+			})
+		}
+	}
+	return nil
+}
+
+// dvproTemporarySuffix is added to the names of the output files while they are being written.
+const dvproTemporarySuffix = ".partial"
+
+// writeDvproFile creates an output file and writes it with the given function.
+func writeDvproFile(filename string, write func(out *os.File) error) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("couldn't create output file: %v", err)
+	}
+	defer out.Close()
+	return write(out)
+}
+
+// formatSize formats a number of bytes for people to read.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size) / unit
+	for _, suffix := range []string{"KiB", "MiB", "GiB"} {
+		if value < unit {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return fmt.Sprintf("%.1f TiB", value)
+}
+
+// exportDvproFile exports all of the video streams from a single file.
+//
+// This returns the list of files that were created.
+func exportDvproFile(inputFile string, options dvproOptions) ([]string, error) {
+	plan, err := planDvproFile(inputFile, options)
+	if err != nil {
+		return nil, err
+	}
+	err = plan.Load()
+	if err != nil {
+		return nil, err
+	}
+	return writeDvproPlan(plan)
+}
+
+// writeDvproPlan creates the output files from a loaded plan.
+//
+// The files are written under temporary names and renamed once they have all been written (the first
+// one last), so that an export that was interrupted doesn't look finished (see "Exists").
+//
+// This returns the list of files that were created.
+func writeDvproPlan(plan *dvproPlan) ([]string, error) {
+	info := plan.Info
+	options := plan.Options
+	temporaryFiles := []string{}
+	removeTemporaryFiles := func() {
+		for _, filename := range temporaryFiles {
+			os.Remove(filename)
+		}
+	}
+	for _, output := range plan.Outputs {
+		temporaryFile := output.Path + dvproTemporarySuffix
+		temporaryFiles = append(temporaryFiles, temporaryFile)
+
+		if output.Subtitles {
+			err := writeSubtitles(info, output.StreamIDs, options.Format == "avi", options.Subtitles, temporaryFile)
+			if err != nil {
+				removeTemporaryFiles()
+				return nil, err
+			}
+			continue
+		}
+
+		var err error
+		switch options.Format {
+		case "avi":
+			fmt.Printf("Exporting video data from stream %s...\n", output.StreamIDs[0])
+			err = writeDvproFile(temporaryFile, func(out *os.File) error {
+				return roscoconv.WriteAVI(out, info, output.StreamIDs)
+			})
+		case "mkv":
+			fmt.Printf("Exporting video data from streams %s...\n", strings.Join(output.StreamIDs, ", "))
+			var file *mkv.File
+			file, err = roscoconv.MakeMKV(info, output.StreamIDs, options.MetadataTrack)
+			if err == nil {
+				err = writeDvproFile(temporaryFile, func(out *os.File) error {
+					return mkv.Write(out, file)
+				})
+			}
+		default:
+			err = fmt.Errorf("invalid video format: %s", options.Format)
+		}
+		if err != nil {
+			removeTemporaryFiles()
+			return nil, err
+		}
+	}
+
+	outputFiles := make([]string, len(plan.Outputs))
+	for i := len(plan.Outputs) - 1; i >= 0; i-- {
+		fmt.Printf("-> %s\n", plan.Outputs[i].Path)
+		err := os.Rename(temporaryFiles[i], plan.Outputs[i].Path)
+		if err != nil {
+			removeTemporaryFiles()
+			return nil, fmt.Errorf("could not rename the output file: %v", err)
+		}
+		outputFiles[i] = plan.Outputs[i].Path
+	}
+	return outputFiles, nil
+}
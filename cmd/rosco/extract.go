@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mp4"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
+)
+
+// recordingSpan is the time that a recording covers, as far as we can tell from its header and filename.
+type recordingSpan struct {
+	Filename string
+	Start    time.Time
+	End      time.Time // This is zero if the end time is unknown.
+}
+
+// recordingSpanForFile figures out the time that a file covers, using only its header and filename.
+//
+// DVXC (".asd") files have the start and end times in their header.  DVXC4 (".nvr") files only
+// have the start time, and that is only in the filename.
+func recordingSpanForFile(filename string) (*recordingSpan, error) {
+	info, err := parseFilename(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
+	span := &recordingSpan{
+		Filename: filename,
+	}
+	start, haveStart := info.StartTime()
+	if haveStart {
+		span.Start = start
+		if entry := info.Metadata.Entry("_duration"); entry != nil {
+			if duration, okay := rosco.MetadataInt64(entry.Value); okay {
+				span.End = start.Add(time.Duration(duration) * time.Second)
+			}
+		}
+		return span, nil
+	}
+
+	for _, name := range []string{info.Filename, path.Base(filename)} {
+		start, end, okay := rosco.FilenameTime(name)
+		if okay {
+			span.Start = start
+			span.End = end
+			return span, nil
+		}
+	}
+	return nil, fmt.Errorf("could not determine the recording time of %s", filename)
+}
+
+// recordingEnd works out when a recording ended, for a file whose header and filename don't say.
+//
+// This reads the whole file; the end is the start of the recording plus the span of its timestamps.
+func recordingEnd(span *recordingSpan) (time.Time, error) {
+	info, err := parseFilename(span.Filename, false)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var first, last uint64
+	found := false
+	for _, chunk := range info.Chunks {
+		var timestamp uint64
+		switch {
+		case chunk.Video != nil:
+			timestamp = chunk.Video.Timestamp
+		case chunk.Audio != nil:
+			timestamp = chunk.Audio.Timestamp
+		default:
+			continue
+		}
+		if !found || timestamp < first {
+			first = timestamp
+		}
+		if !found || timestamp > last {
+			last = timestamp
+		}
+		found = true
+	}
+	if !found {
+		return span.Start, nil
+	}
+	return span.Start.Add(time.Duration(last-first) * time.Microsecond), nil
+}
+
+// findRecordings returns the files that cover any part of the time range, in order.
+//
+// If a file's end time is unknown (as with DVXC4 files), then it is only worked out (by reading the
+// whole file) when it matters: when the file starts before the range, and the next file doesn't.
+// Since a camera only records one file at a time, a file that is followed by one that starts before
+// the range must have ended before the range, too.
+func findRecordings(inputFiles []string, from time.Time, to time.Time) []string {
+	spans := []*recordingSpan{}
+	for _, inputFile := range inputFiles {
+		span, err := recordingSpanForFile(inputFile)
+		if err != nil {
+			logrus.Warnf("Skipping %s: %v", inputFile, err)
+			continue
+		}
+		logrus.Debugf("%s: %v to %v", inputFile, span.Start, span.End)
+		spans = append(spans, span)
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].Start.Before(spans[j].Start)
+	})
+
+	filenames := []string{}
+	for i, span := range spans {
+		if !span.Start.Before(to) {
+			continue
+		}
+		if span.Start.Before(from) {
+			end := span.End
+			if end.IsZero() {
+				var next *recordingSpan
+				for _, other := range spans[i+1:] {
+					if other.Start.After(span.Start) {
+						next = other
+						break
+					}
+				}
+				if next != nil && !next.Start.After(from) {
+					continue
+				}
+				var err error
+				end, err = recordingEnd(span)
+				if err != nil {
+					logrus.Warnf("Skipping %s: %v", span.Filename, err)
+					continue
+				}
+				logrus.Debugf("%s: ends at %v", span.Filename, end)
+			}
+			if !end.After(from) {
+				continue
+			}
+		}
+		filenames = append(filenames, span.Filename)
+	}
+	return filenames
+}
+
+// writeJoinedVideo writes one camera's video (with audio) from a joined recording to a file.
+//
+// The format can be either "avi" or "mp4".
+func writeJoinedVideo(info *rosco.FileInfo, streamID string, format string, filename string) error {
+	if filename == "-" {
+		return fmt.Errorf("the video cannot be written to stdout")
+	}
+	switch format {
+	case "avi":
+		fmt.Printf("Exporting video data from stream %s...\n", streamID)
+		out, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("couldn't create output file: %v", err)
+		}
+		defer out.Close()
+		return roscoconv.WriteAVI(out, info, []string{streamID})
+	case "mp4":
+		fmt.Printf("Exporting video data from stream %s...\n", streamID)
+		file, err := roscoconv.MakeMP4(info, streamID)
+		if err != nil {
+			return err
+		}
+
+		out, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("couldn't create output file: %v", err)
+		}
+		defer out.Close()
+		return mp4.Write(out, file)
+	default:
+		return fmt.Errorf("invalid video format: %s", format)
+	}
+}
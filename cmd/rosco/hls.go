@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/mp4"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mpegts"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
+)
+
+// These are the kinds of segments that an HLS export can have.
+const (
+	hlsSegmentFormatFMP4 = "fmp4"
+	hlsSegmentFormatTS   = "ts"
+)
+
+// exportHLS exports every camera in a file as HLS: segments and a media playlist for each camera,
+// and a master playlist for each camera ("camera0.m3u8", and so on) that refers to its media playlist.
+//
+// The cameras are not renditions of the same video, so they can't be variants in a single master playlist;
+// a player would switch between them as the bandwidth changes.
+//
+// The segment format is one of the "hlsSegmentFormat" constants.
+// If `noAudio` is true, then the audio is left out.
+func exportHLS(info *rosco.FileInfo, outputDirectory string, segmentDuration time.Duration, segmentFormat string, noAudio bool) error {
+	if segmentFormat != hlsSegmentFormatFMP4 && segmentFormat != hlsSegmentFormatTS {
+		return fmt.Errorf("unsupported segment format: %s", segmentFormat)
+	}
+
+	err := os.MkdirAll(outputDirectory, 0755)
+	if err != nil {
+		return fmt.Errorf("could not create the output directory: %v", err)
+	}
+
+	cameraCount := 0
+	for _, streamID := range roscoconv.LogicalStreamIDs(info) {
+		videoTrack, err := roscoconv.MakeVideoTrack(info, streamID)
+		if err != nil {
+			continue // There's no video in this stream.
+		}
+		cameraCount++
+
+		fmt.Printf("Exporting stream %s...\n", streamID)
+		var segments []*roscoconv.HLSSegment
+		var uris []string
+		var mapURI string
+		var audioCodec string
+		if segmentFormat == hlsSegmentFormatTS {
+			segments, uris, audioCodec, err = writeHLSTSSegments(info, outputDirectory, streamID, segmentDuration, noAudio)
+		} else {
+			segments, uris, mapURI, audioCodec, err = writeHLSFMP4Segments(info, outputDirectory, streamID, segmentDuration, noAudio)
+		}
+		if err != nil {
+			return err
+		}
+
+		mediaPlaylist := fmt.Sprintf("camera%s_media.m3u8", streamID)
+		fmt.Printf("Writing %s (%d segments)...\n", mediaPlaylist, len(segments))
+		out, err := os.Create(path.Join(outputDirectory, mediaPlaylist))
+		if err != nil {
+			return fmt.Errorf("couldn't create playlist file: %v", err)
+		}
+		err = roscoconv.WriteHLSMediaPlaylist(out, segments, uris, mapURI)
+		out.Close()
+		if err != nil {
+			return err
+		}
+
+		masterPlaylist := fmt.Sprintf("camera%s.m3u8", streamID)
+		fmt.Printf("Writing %s...\n", masterPlaylist)
+		out, err = os.Create(path.Join(outputDirectory, masterPlaylist))
+		if err != nil {
+			return fmt.Errorf("couldn't create playlist file: %v", err)
+		}
+		variant := roscoconv.NewHLSVariant(mediaPlaylist, fmt.Sprintf("Camera %s", streamID), videoTrack, audioCodec, segments)
+		err = roscoconv.WriteHLSMasterPlaylist(out, []roscoconv.HLSVariant{variant})
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if cameraCount == 0 {
+		return fmt.Errorf("there is no video to export")
+	}
+	return nil
+}
+
+// writeHLSTSSegments writes the transport stream segments for a camera.
+//
+// This returns the segments, their URIs, and the HLS name of the audio codec (or an empty string if there is no audio).
+func writeHLSTSSegments(info *rosco.FileInfo, outputDirectory string, streamID string, segmentDuration time.Duration, noAudio bool) ([]*roscoconv.HLSSegment, []string, string, error) {
+	file, err := roscoconv.MakeTS(info, streamID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if noAudio {
+		packets := []mpegts.Packet{}
+		for _, packet := range file.Packets {
+			if packet.StreamIndex == 0 {
+				packets = append(packets, packet)
+			}
+		}
+		file.Streams = file.Streams[0:1]
+		file.Packets = packets
+	}
+	audioCodec := ""
+	if len(file.Streams) > 1 {
+		audioCodec = roscoconv.HLSAudioCodecTS
+	}
+
+	segments := roscoconv.SegmentTS(file, segmentDuration)
+	uris := []string{}
+	tsWriter := mpegts.NewWriter(nil, file.Streams)
+	for i, segment := range segments {
+		uri := fmt.Sprintf("camera%s_%05d.ts", streamID, i)
+		out, err := os.Create(path.Join(outputDirectory, uri))
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("couldn't create segment file: %v", err)
+		}
+		err = roscoconv.WriteHLSSegment(out, tsWriter, file, segment)
+		out.Close()
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("could not write segment %d: %v", i, err)
+		}
+		uris = append(uris, uri)
+	}
+	return segments, uris, audioCodec, nil
+}
+
+// writeHLSFMP4Segments writes the initialization segment and the fragmented MP4 segments for a camera.
+//
+// This returns the segments, their URIs, the URI of the initialization segment, and the HLS name of the
+// audio codec (or an empty string if there is no audio).
+func writeHLSFMP4Segments(info *rosco.FileInfo, outputDirectory string, streamID string, segmentDuration time.Duration, noAudio bool) ([]*roscoconv.HLSSegment, []string, string, string, error) {
+	file, err := roscoconv.MakeMP4(info, streamID)
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+	audioCodec := ""
+	tracks := []*mp4.Track{}
+	for _, track := range file.Tracks {
+		if track.Handler == mp4.HandlerAudio {
+			if noAudio {
+				continue
+			}
+			audioCodec = roscoconv.HLSAudioCodecFMP4
+		}
+		tracks = append(tracks, track)
+	}
+	file.Tracks = tracks
+
+	mapURI := fmt.Sprintf("camera%s_init.mp4", streamID)
+	out, err := os.Create(path.Join(outputDirectory, mapURI))
+	if err != nil {
+		return nil, nil, "", "", fmt.Errorf("couldn't create initialization segment file: %v", err)
+	}
+	err = mp4.WriteInitialization(out, file)
+	out.Close()
+	if err != nil {
+		return nil, nil, "", "", fmt.Errorf("could not write the initialization segment: %v", err)
+	}
+
+	segments, err := roscoconv.SegmentMP4(file, segmentDuration)
+	if err != nil {
+		return nil, nil, "", "", err
+	}
+	uris := []string{}
+	for i, segment := range segments {
+		uri := fmt.Sprintf("camera%s_%05d.m4s", streamID, i)
+		out, err := os.Create(path.Join(outputDirectory, uri))
+		if err != nil {
+			return nil, nil, "", "", fmt.Errorf("couldn't create segment file: %v", err)
+		}
+		err = roscoconv.WriteHLSFragment(out, segment)
+		out.Close()
+		if err != nil {
+			return nil, nil, "", "", fmt.Errorf("could not write segment %d: %v", i, err)
+		}
+		uris = append(uris, uri)
+	}
+	return segments, uris, mapURI, audioCodec, nil
+}
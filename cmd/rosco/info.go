@@ -0,0 +1,238 @@
+package main
+
+import (
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/h264info"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
+)
+
+// infoJSONSchemaVersion is the version of the "info --json" output.
+//
+// This must be incremented whenever a field is removed or its meaning changes; new fields may be
+// added without changing it.
+const infoJSONSchemaVersion = 1
+
+// infoJSON is the output of "info --json".
+type infoJSON struct {
+	SchemaVersion int            `json:"schemaVersion"`
+	Files         []infoFileJSON `json:"files"`
+}
+
+// infoFileJSON is the information about a single file.
+type infoFileJSON struct {
+	Path            string                 `json:"path"`            // The path that was given on the command line.
+	Error           string                 `json:"error,omitempty"` // If this is set, then the file could not be read, and nothing else is set.
+	Size            int64                  `json:"size"`            // In bytes.
+	Format          string                 `json:"format,omitempty"`
+	Filename        string                 `json:"filename,omitempty"` // The filename from the file itself.
+	HeaderOnly      bool                   `json:"headerOnly"`         // If true, then only the header was read, so there are no streams.
+	Metadata        map[string]interface{} `json:"metadata"`           // The header metadata, with dotted names for sub-metadata.
+	StartTime       *time.Time             `json:"startTime,omitempty"`
+	DurationSeconds *float64               `json:"durationSeconds,omitempty"`
+	Streams         []infoStreamJSON       `json:"streams"`
+	Videos          []infoVideoJSON        `json:"videos"`
+	Audio           []infoAudioJSON        `json:"audio"`
+	VideoGaps       []infoVideoGapJSON     `json:"videoGaps"`
+}
+
+// infoStreamJSON is the information about a single raw stream (for example, "01").
+type infoStreamJSON struct {
+	ID         string `json:"id"`
+	Chunks     int    `json:"chunks"`
+	AudioBytes int    `json:"audioBytes"`
+	VideoBytes int    `json:"videoBytes"`
+	Images     int    `json:"images"`
+}
+
+// infoVideoJSON is the information about the video from a camera.
+type infoVideoJSON struct {
+	Stream          string  `json:"stream"` // The logical stream ID (for example, "0").
+	Codec           string  `json:"codec"`
+	Profile         string  `json:"profile,omitempty"`
+	Level           string  `json:"level,omitempty"`
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	Frames          int     `json:"frames"`
+	IDRFrames       int     `json:"idrFrames"`
+	FrameRate       float64 `json:"frameRate"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Bitrate         float64 `json:"bitrate"` // In bits per second.
+}
+
+// infoAudioJSON is the information about the audio from a camera.
+type infoAudioJSON struct {
+	Stream          string  `json:"stream"` // The full stream ID (for example, "07").
+	Codec           string  `json:"codec"`  // Either "pcm" or "opus".
+	SampleRate      int     `json:"sampleRate"`
+	Channels        int     `json:"channels"`
+	BitDepth        int     `json:"bitDepth,omitempty"` // Only set for PCM.
+	Chunks          int     `json:"chunks"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// infoVideoGapJSON is a gap in the video from a camera.
+type infoVideoGapJSON struct {
+	Camera          string  `json:"camera"`
+	StartSeconds    float64 `json:"startSeconds"` // From the first frame from the camera.
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// makeInfoFileJSON describes a file for "info --json".
+func makeInfoFileJSON(filename string, headerOnly bool) infoFileJSON {
+	result := infoFileJSON{
+		Path:       filename,
+		HeaderOnly: headerOnly,
+		Metadata:   map[string]interface{}{},
+		Streams:    []infoStreamJSON{},
+		Videos:     []infoVideoJSON{},
+		Audio:      []infoAudioJSON{},
+		VideoGaps:  []infoVideoGapJSON{},
+	}
+
+	handle, err := os.Open(filename)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer handle.Close()
+
+	if stat, err := handle.Stat(); err == nil {
+		result.Size = stat.Size()
+	}
+
+	info, err := rosco.ParseReader(handle, headerOnly)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Format = info.Format
+	result.Filename = info.Filename
+	for _, entry := range info.Metadata.Flatten() {
+		value := entry.Value
+		// JSON has no way to write these.
+		if v, okay := value.(float64); okay && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			value = nil
+		}
+		result.Metadata[entry.Name] = value
+	}
+	if startTime, okay := info.StartTime(); okay {
+		result.StartTime = &startTime
+	}
+	if info.Metadata != nil {
+		if entry := info.Metadata.Entry("_duration"); entry != nil {
+			if duration, okay := rosco.MetadataInt64(entry.Value); okay {
+				seconds := float64(duration)
+				result.DurationSeconds = &seconds
+			}
+		}
+	}
+
+	for _, streamID := range info.StreamIDs() {
+		stream := infoStreamJSON{
+			ID: streamID,
+		}
+		for _, chunk := range info.ChunksForStreamID(streamID) {
+			stream.Chunks++
+			if chunk.Audio != nil {
+				stream.AudioBytes += len(chunk.Audio.Media)
+			}
+			if chunk.Video != nil {
+				stream.VideoBytes += len(chunk.Video.Media)
+			}
+			if chunk.Image != nil {
+				stream.Images++
+			}
+		}
+		result.Streams = append(result.Streams, stream)
+	}
+
+	var firstTimestamp, lastTimestamp uint64
+	haveTimestamps := false
+	for _, streamID := range roscoconv.LogicalStreamIDs(info) {
+		if videoTrack, err := roscoconv.MakeVideoTrack(info, streamID); err == nil {
+			video := infoVideoJSON{
+				Stream: streamID,
+				Codec:  videoTrack.Codec,
+				Width:  videoTrack.Width,
+				Height: videoTrack.Height,
+			}
+			if streamInfo, err := roscoconv.AnalyzeVideo(info, streamID); err == nil {
+				if sps := streamInfo.SPS; sps != nil {
+					video.Profile = h264info.ProfileName(sps.ProfileIDC, sps.ConstraintFlags)
+					video.Level = h264info.LevelName(sps.ProfileIDC, sps.ConstraintFlags, sps.LevelIDC)
+					video.Width = sps.Width()
+					video.Height = sps.Height()
+				}
+				video.Frames = streamInfo.Frames
+				video.IDRFrames = streamInfo.IDRFrames
+				video.FrameRate = streamInfo.FrameRate()
+				video.DurationSeconds = float64(streamInfo.Duration()) / 1000000
+				video.Bitrate = streamInfo.Bitrate()
+			}
+			result.Videos = append(result.Videos, video)
+
+			for _, frame := range videoTrack.Frames {
+				if !haveTimestamps || frame.Timestamp < firstTimestamp {
+					firstTimestamp = frame.Timestamp
+				}
+				if !haveTimestamps || frame.Timestamp > lastTimestamp {
+					lastTimestamp = frame.Timestamp
+				}
+				haveTimestamps = true
+			}
+		}
+
+		if audioTrack, err := roscoconv.MakeAudioTrack(info, streamID); err == nil {
+			timestamps := make([]uint64, len(audioTrack.Frames))
+			for i, frame := range audioTrack.Frames {
+				timestamps[i] = frame.Timestamp
+			}
+			// The audio is in file order, and its timestamps aren't always in order (see "repair").
+			sort.Slice(timestamps, func(i, j int) bool {
+				return timestamps[i] < timestamps[j]
+			})
+			first := timestamps[0]
+			last := timestamps[len(timestamps)-1]
+			audio := infoAudioJSON{
+				Stream:          audioTrack.StreamID,
+				Codec:           audioTrack.Codec,
+				SampleRate:      audioTrack.SampleRate,
+				Channels:        audioTrack.ChannelCount,
+				Chunks:          len(audioTrack.Frames),
+				DurationSeconds: float64(last-first+rosco.MedianInterval(timestamps)) / 1000000,
+			}
+			if audioTrack.Codec == roscoconv.AudioCodecPCM {
+				audio.BitDepth = audioTrack.BitDepth
+			}
+			result.Audio = append(result.Audio, audio)
+		}
+	}
+
+	// Without a duration from the header, use the span of the video.
+	if result.DurationSeconds == nil && haveTimestamps {
+		seconds := float64(lastTimestamp-firstTimestamp) / 1000000
+		result.DurationSeconds = &seconds
+	}
+
+	firstTimestamps := map[string]uint64{}
+	for _, gap := range info.VideoGaps() {
+		first, okay := firstTimestamps[gap.Camera]
+		if !okay {
+			first = info.VideoTimestamps(gap.Camera)[0]
+			firstTimestamps[gap.Camera] = first
+		}
+		result.VideoGaps = append(result.VideoGaps, infoVideoGapJSON{
+			Camera:          gap.Camera,
+			StartSeconds:    float64(gap.Start-first) / 1000000,
+			DurationSeconds: float64(gap.Duration()) / 1000000,
+		})
+	}
+
+	return result
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
-	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/go-audio/audio"
 	"github.com/go-audio/wav"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/tekkamanendless/rosco-dashcam-processor/h264info"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mkv"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mp4"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mpegts"
 	"github.com/tekkamanendless/rosco-dashcam-processor/riff"
 	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
 	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
@@ -44,6 +51,7 @@ This tool processes Rosco dashcam files (typically with the extension ".nvr").
 	{
 		dumpValue := false
 		headerOnlyValue := false
+		jsonValue := false
 		var infoCommand = &cobra.Command{
 			Use:   "info <filename> [...]",
 			Short: "Show the information from the given file(s)",
@@ -51,9 +59,41 @@ This tool processes Rosco dashcam files (typically with the extension ".nvr").
 The output here isn't particularly pretty, but it should be enough for you to do whatever you need to do with the files.
 
 For a more aggressive output, use the --dump flag.
+
+For use in scripts, use the --json flag.  This writes a single JSON object with a "schemaVersion" field
+and a "files" array with one entry per file (in the same order as the arguments).  The schema version is
+only changed when a field is removed or its meaning changes; new fields may be added at any time.
+If a file could not be read, then its entry has an "error" field.
 `,
 			Args: cobra.MinimumNArgs(1),
 			Run: func(cmd *cobra.Command, args []string) {
+				if jsonValue {
+					output := infoJSON{
+						SchemaVersion: infoJSONSchemaVersion,
+						Files:         []infoFileJSON{},
+					}
+					failed := false
+					for _, filename := range args {
+						file := makeInfoFileJSON(filename, headerOnlyValue)
+						if file.Error != "" {
+							failed = true
+						}
+						output.Files = append(output.Files, file)
+					}
+
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					err := encoder.Encode(output)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+						os.Exit(1)
+					}
+					if failed {
+						os.Exit(1)
+					}
+					return
+				}
+
 				for _, filename := range args {
 					fmt.Printf("File: %s\n", filename)
 					info, err := parseFilename(filename, headerOnlyValue)
@@ -71,6 +111,7 @@ For a more aggressive output, use the --dump flag.
 		}
 		infoCommand.Flags().BoolVar(&dumpValue, "dump", false, "Dump out everything about the file")
 		infoCommand.Flags().BoolVar(&headerOnlyValue, "header-only", false, "Only read the header data")
+		infoCommand.Flags().BoolVar(&jsonValue, "json", false, "Write the information as JSON")
 		rootCommand.AddCommand(infoCommand)
 	}
 
@@ -146,6 +187,388 @@ For a more aggressive output, use the --dump flag.
 		rootCommand.AddCommand(debugCommand)
 	}
 
+	{
+		var probeCommand = &cobra.Command{
+			Use:   "probe <filename> [...]",
+			Short: "Validate the given AVI file(s)",
+			Long: `
+This reads AVI files (such as the ones that this tool exports) and checks them against the AVI and OpenDML specifications.
+This includes the header consistency, the index offsets, the keyframe flags, and the stream lengths.
+
+If any problems are found, then this exits with a non-zero status.
+`,
+			Args: cobra.MinimumNArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				okay := true
+				for _, filename := range args {
+					fmt.Printf("File: %s\n", filename)
+					problems, err := probeFile(filename)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						okay = false
+						continue
+					}
+					if len(problems) == 0 {
+						fmt.Printf("Problems: none\n")
+						continue
+					}
+					okay = false
+					fmt.Printf("Problems: %d\n", len(problems))
+					for _, problem := range problems {
+						fmt.Printf("   %s\n", problem)
+					}
+				}
+				if !okay {
+					os.Exit(1)
+				}
+			},
+		}
+		rootCommand.AddCommand(probeCommand)
+	}
+
+	{
+		var verifyCommand = &cobra.Command{
+			Use:   "verify <filename> [...]",
+			Short: "Check the H.264 data in the given file(s) for damage",
+			Long: `
+This walks every video frame in the given files and checks its H.264 data.
+This includes the start codes, the forbidden_zero_bit, truncated NAL units, slice types that don't match
+the keyframe and delta-frame substreams, and IDR frames without an SPS and PPS.
+
+Each damaged frame is reported with its timestamp and its offset in the file.
+If any damaged frames are found, then this exits with a non-zero status.
+`,
+			Args: cobra.MinimumNArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				okay := true
+				for _, filename := range args {
+					fmt.Printf("File: %s\n", filename)
+					info, err := parseFilename(filename, false)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						okay = false
+						continue
+					}
+					damagedFrames := verifyFile(info)
+					if damagedFrames == 0 {
+						fmt.Printf("Damaged frames: none\n")
+						continue
+					}
+					okay = false
+					fmt.Printf("Damaged frames: %d\n", damagedFrames)
+				}
+				if !okay {
+					os.Exit(1)
+				}
+			},
+		}
+		rootCommand.AddCommand(verifyCommand)
+	}
+
+	{
+		var repairCommand = &cobra.Command{
+			Use:   "repair <input-file> <output-file>",
+			Short: "Salvage the valid chunks from a damaged NVR file",
+			Long: `
+This reads every valid chunk from a truncated or partially overwritten NVR (DVXC4) file and writes them
+to a new NVR file with the original header.  Any bytes that can't be read as chunks are skipped.
+
+Delta frames that come before a camera's first keyframe are dropped (since they can't be decoded), and
+timestamps that go backwards (or jump ahead and come back) are replaced so that each stream's timestamps
+always increase.
+
+The file header must be intact.  ASD (DVXC) files are not supported.
+`,
+			Args: cobra.ExactArgs(2),
+			Run: func(cmd *cobra.Command, args []string) {
+				inputFile := args[0]
+				outputFile := args[1]
+				if path.Clean(inputFile) == path.Clean(outputFile) {
+					fmt.Printf("Error: the output file must be different from the input file\n")
+					os.Exit(1)
+				}
+
+				data, err := os.ReadFile(inputFile)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+
+				salvage, err := rosco.SalvageXC4(data)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Printf("Chunks salvaged: %d\n", len(salvage.Info.Chunks))
+				fmt.Printf("Damaged regions: %d (%d bytes)\n", salvage.DamagedRegions, salvage.DamagedBytes)
+				fmt.Printf("Orphan frames dropped: %d\n", salvage.DropOrphanFrames())
+				fmt.Printf("Timestamps fixed: %d\n", salvage.FixTimestamps())
+
+				out, err := os.Create(outputFile)
+				if err != nil {
+					fmt.Printf("Error: couldn't create output file: %v\n", err)
+					os.Exit(1)
+				}
+				defer out.Close()
+
+				err = salvage.Write(out)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Printf("Wrote %s.\n", outputFile)
+			},
+		}
+		rootCommand.AddCommand(repairCommand)
+	}
+
+	{
+		library := ""
+		listen := "localhost:8080"
+		var serveCommand = &cobra.Command{
+			Use:   "serve --library <directory>",
+			Short: "Serve a directory of recordings over HTTP",
+			Long: `
+This serves the recordings in a directory over HTTP, with a web UI for watching them in a browser.
+
+The REST API has these endpoints:
+   GET /api/recordings                   Lists the recordings (from their headers only).
+   GET /api/recordings/{file}            Shows everything about a recording (the same as "info --json").
+   GET /video/{file}/{stream}.mp4        Exports a camera's video (with audio) as an MP4 file.
+   GET /video/{file}/{stream}.avi        Exports a camera's video (with audio) as an AVI file.
+
+The videos are exported on the fly (and the most recent few are kept in memory), and range requests
+are supported, so the browser can seek.
+`,
+			Args: cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				fileInfo, err := os.Stat(library)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				if !fileInfo.IsDir() {
+					fmt.Printf("Error: %s is not a directory\n", library)
+					os.Exit(1)
+				}
+
+				server := &libraryServer{
+					Library: library,
+				}
+				handler, err := server.Handler()
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+
+				fmt.Printf("Serving %s on http://%s/\n", library, listen)
+				err = http.ListenAndServe(listen, handler)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+			},
+		}
+		serveCommand.Flags().StringVar(&library, "library", library, "The directory with the recordings")
+		serveCommand.Flags().StringVar(&listen, "listen", listen, "The address to listen on")
+		serveCommand.MarkFlagRequired("library")
+		rootCommand.AddCommand(serveCommand)
+	}
+
+	{
+		options := dvproOptions{
+			Format: "avi",
+		}
+		stateFile := ""
+		interval := 10 * time.Second
+		settle := 30 * time.Second
+		var watchCommand = &cobra.Command{
+			Use:   "watch <directory> --output <directory>",
+			Short: "Export new recordings as they show up in a directory",
+			Long: `
+This watches a directory (for example, where a card reader mounts) for new NVR and ASD files.
+Once a file has stopped growing, it is exported to the output directory in the same way as "export dvpro".
+
+The directory is checked every "--interval".  A file is exported once its size and modification time are
+the same as on the previous check and it hasn't been modified for "--settle".
+
+Every file that has been exported (by its name and size) is recorded in the state file, so that nothing is
+exported twice, even if the card is mounted somewhere else the next time.  If an export fails, then it is
+tried again after a minute, then after two minutes, and so on, up to 5 attempts; restarting the command
+starts over.
+
+This runs until it is stopped.
+`,
+			Args: cobra.ExactArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				directory := args[0]
+				switch options.Subtitles {
+				case "", "srt", "vtt":
+				default:
+					fmt.Printf("Error: invalid subtitle format: %s\n", options.Subtitles)
+					os.Exit(1)
+				}
+				switch options.Format {
+				case "avi", "mkv":
+				default:
+					fmt.Printf("Error: invalid video format: %s\n", options.Format)
+					os.Exit(1)
+				}
+				if interval <= 0 {
+					fmt.Printf("Error: the interval must be positive\n")
+					os.Exit(1)
+				}
+
+				err := os.MkdirAll(options.OutputDirectory, 0755)
+				if err != nil {
+					fmt.Printf("Error: could not create the output directory: %v\n", err)
+					os.Exit(1)
+				}
+				if stateFile == "" {
+					stateFile = path.Join(options.OutputDirectory, ".rosco-watch.json")
+				}
+				state, err := loadWatchState(stateFile)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+
+				w := &watcher{
+					Directory: directory,
+					StateFile: stateFile,
+					Settle:    settle,
+					Options:   options,
+					state:     state,
+				}
+				fmt.Printf("Watching %s (%d files already exported)...\n", directory, len(state.Files))
+				for {
+					w.poll(time.Now())
+					time.Sleep(interval)
+				}
+			},
+		}
+		watchCommand.Flags().StringVarP(&options.OutputDirectory, "output", "o", options.OutputDirectory, "The output directory")
+		watchCommand.Flags().StringVar(&options.Format, "format", options.Format, "The output file format (can be one of: avi, mkv)")
+		watchCommand.Flags().BoolVar(&options.MetadataTrack, "metadata-track", options.MetadataTrack, "Include a text track with the per-frame metadata (mkv only)")
+		watchCommand.Flags().StringVar(&options.Subtitles, "subtitles", options.Subtitles, "Also write the telemetry as subtitles next to each video (can be one of: srt, vtt)")
+		watchCommand.Flags().StringVar(&stateFile, "state-file", stateFile, "The file that records what has been processed; if not specified, it is \".rosco-watch.json\" in the output directory")
+		watchCommand.Flags().DurationVar(&interval, "interval", interval, "How often to check the directory")
+		watchCommand.Flags().DurationVar(&settle, "settle", settle, "How long a file must go without changing before it is exported")
+		watchCommand.MarkFlagRequired("output")
+		rootCommand.AddCommand(watchCommand)
+	}
+
+	{
+		fromText := ""
+		toText := ""
+		streamID := "0"
+		outputFilename := ""
+		format := ""
+		var extractCommand = &cobra.Command{
+			Use:   "extract --from <time> --to <time> [--camera <stream>] <input-file|directory>[ ...]",
+			Short: "Export the video from a camera for a wall-clock time range, across files",
+			Long: `
+This finds every file that covers the given time range and exports that part of them as a single video.
+Only the headers and filenames are used to find the files, so this is quick even for a large directory.
+
+The times can be a date and time (such as "2026-03-04 14:02") or a time of day (such as "14:05").
+A time of day for "--to" is on the same day as "--from"; a time of day for "--from" is today.
+
+The video starts at the keyframe at or before "--from".
+If the output file is not given, then it is named after the time range and camera (as an MP4 file).
+`,
+			Args: cobra.MinimumNArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				from, err := parseWallClock(fromText, time.Now())
+				if err != nil {
+					fmt.Printf("Error: invalid --from: %v\n", err)
+					os.Exit(1)
+				}
+				to, err := parseWallClock(toText, from)
+				if err != nil {
+					fmt.Printf("Error: invalid --to: %v\n", err)
+					os.Exit(1)
+				}
+				if !to.After(from) {
+					fmt.Printf("Error: --to (%v) is not after --from (%v)\n", to, from)
+					os.Exit(1)
+				}
+				if outputFilename == "-" {
+					fmt.Printf("Error: the video cannot be written to stdout\n")
+					os.Exit(1)
+				}
+				if outputFilename == "" {
+					outputFilename = fmt.Sprintf("%s-%s_%s.mp4", from.Format("20060102-150405"), to.Format("150405"), streamID)
+				}
+				if format == "" {
+					format = strings.TrimPrefix(path.Ext(outputFilename), ".")
+				}
+
+				inputFiles, err := dvproInputFiles(args)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				filenames := findRecordings(inputFiles, from, to)
+				if len(filenames) == 0 {
+					fmt.Printf("Error: none of the files cover %v to %v\n", from, to)
+					os.Exit(1)
+				}
+
+				infos := []*rosco.FileInfo{}
+				for _, filename := range filenames {
+					fmt.Printf("Reading %s...\n", filename)
+					info, err := parseFilename(filename, false)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+					infos = append(infos, info)
+				}
+
+				info, err := roscoconv.Concat(infos)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				start, err := timestampForTime(info, from)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				end, err := timestampForTime(info, to)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				if !hasChunksInRange(info, start, end) {
+					fmt.Printf("Error: none of the files cover %v to %v\n", from, to)
+					os.Exit(1)
+				}
+				info, err = roscoconv.Trim(info, start, end)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+
+				fmt.Printf("-> %s\n", outputFilename)
+				err = writeJoinedVideo(info, streamID, format, outputFilename)
+				if err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+			},
+		}
+		extractCommand.Flags().StringVar(&fromText, "from", fromText, "The start of the time range")
+		extractCommand.Flags().StringVar(&toText, "to", toText, "The end of the time range")
+		extractCommand.Flags().StringVar(&streamID, "camera", streamID, "The logical stream of the camera to export")
+		extractCommand.Flags().StringVarP(&outputFilename, "output", "o", outputFilename, "The output file")
+		extractCommand.Flags().StringVar(&format, "format", format, "The output file format (can be one of: avi, mp4); if not specified, it is taken from the output file's extension")
+		extractCommand.MarkFlagRequired("from")
+		extractCommand.MarkFlagRequired("to")
+		rootCommand.AddCommand(extractCommand)
+	}
+
 	{
 		var exportCommand = &cobra.Command{
 			Use:   "export",
@@ -160,6 +583,9 @@ For a more aggressive output, use the --dump flag.
 
 		{
 			format := "wav"
+			startText := ""
+			endText := ""
+			timeZone := "UTC"
 			var exportAudioCommand = &cobra.Command{
 				Use:   "audio <input-file> <stream> <output-file>",
 				Short: "Export an audio stream from a file",
@@ -167,6 +593,13 @@ For a more aggressive output, use the --dump flag.
 This provides a more targeted approach to exporting audio data.
 It operates on a single file at a time, allowing you to specify exactly which stream you want to export.
 You may also choose the output format.
+
+WAV files are written as Broadcast WAV files, with the time of the recording as their origination date,
+time, and time reference (samples since midnight).  These are in UTC unless "--time-zone" says otherwise
+(for example, "America/New_York", or "Local" for this computer's time zone), so that the same file gets
+the same timeline position wherever it is exported.
+
+Use "--start" and "--end" to export only part of the file.
 `,
 				Args: cobra.ExactArgs(3),
 				Run: func(cmd *cobra.Command, args []string) {
@@ -174,12 +607,24 @@ You may also choose the output format.
 					streamID := args[1]
 					destinationFilename := args[2]
 
+					location, err := time.LoadLocation(timeZone)
+					if err != nil {
+						fmt.Printf("Error: invalid time zone %q: %v\n", timeZone, err)
+						os.Exit(1)
+					}
+
 					info, err := parseFilename(inputFile, false)
 					if err != nil {
 						fmt.Printf("Error: %v\n", err)
 						os.Exit(1)
 					}
 
+					info, err = trimRecording(info, startText, endText)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+
 					if len(streamID) == 1 {
 						for _, id := range info.StreamIDs() {
 							if strings.HasPrefix(id, streamID) {
@@ -199,26 +644,8 @@ You may also choose the output format.
 						}
 					}
 
-					rawPCM := strings.HasSuffix(streamID, "7")
+					rawPCM, audioBitDepth, wavAudioFormat := roscoconv.AudioFormat(info, streamID)
 					logrus.Debugf("Raw PCM: %t", rawPCM)
-
-					wavAudioFormat := 0x0001 // PCM
-					audioBitDepth := 8
-					if rawPCM {
-						wavAudioFormat = 0x0007 // mu-law
-
-						entry := info.Metadata.Entry("_audioBitDepth")
-						if entry != nil {
-							audioBitDepth = int(entry.Value.(int64))
-							logrus.Debugf("Audio bit depth (from the metadata): %d", audioBitDepth)
-						}
-
-						entry = info.Metadata.Entry("_wavAudioFormat")
-						if entry != nil {
-							wavAudioFormat = int(entry.Value.(int64))
-							logrus.Debugf("WAV audio format (from the metadata): %d", wavAudioFormat)
-						}
-					}
 					logrus.Debugf("WAV audio format: %d", wavAudioFormat)
 
 					var intBuffers []*audio.IntBuffer
@@ -277,6 +704,17 @@ You may also choose the output format.
 						fmt.Printf("WAV encoder: Sample rate: %d, Bit Depth: %d, Channels: %d, Format: 0x%x\n", intBuffer.Format.SampleRate, intBuffer.SourceBitDepth, intBuffer.Format.NumChannels, wavAudioFormat)
 						wavEncoder.Write(intBuffer)
 						wavEncoder.Close()
+
+						bwfChunks, err := roscoconv.MakeBWFChunks(info, streamID, intBuffer.Format.SampleRate, intBuffer.SourceBitDepth, intBuffer.Format.NumChannels, location)
+						if err != nil {
+							fmt.Printf("Couldn't create the BWF metadata: %v\n", err)
+							os.Exit(1)
+						}
+						err = riff.AppendChunks(out, bwfChunks...)
+						if err != nil {
+							fmt.Printf("Couldn't write the BWF metadata: %v\n", err)
+							os.Exit(1)
+						}
 					default:
 						fmt.Printf("Invalid audio format: %s\n", format)
 						os.Exit(1)
@@ -284,158 +722,664 @@ You may also choose the output format.
 				},
 			}
 			exportAudioCommand.Flags().StringVar(&format, "format", format, "The output file format (can be one of: raw, wav)")
+			exportAudioCommand.Flags().StringVar(&startText, "start", startText, "Only export from this point on: an offset from the start of the recording (such as \"1m30s\" or \"90\") or a wall-clock time (such as \"2026-03-04 14:02:00\" or \"14:02:00\")")
+			exportAudioCommand.Flags().StringVar(&endText, "end", endText, "Only export up to this point (in the same form as --start)")
+			exportAudioCommand.Flags().StringVar(&timeZone, "time-zone", timeZone, "The time zone for the Broadcast WAV origination time and time reference (such as \"UTC\", \"America/New_York\", or \"Local\")")
 			exportCommand.AddCommand(exportAudioCommand)
 		}
 
 		{
 			format := "avi"
+			metadataTrack := false
+			timestampsFilename := ""
+			allStreams := false
+			startText := ""
+			endText := ""
+			subtitlesFormat := ""
 			var exportVideoCommand = &cobra.Command{
-				Use:   "video <input-file> <stream> <output-file>",
+				Use:   "video <input-file> {<stream>|--all-streams} <output-file>",
 				Short: "Export a video stream from a file",
 				Long: `
 This provides a more targeted approach to exporting video data.
 It operates on a single file at a time, allowing you to specify exactly which stream you want to export.
+
+If the output file is "-", then the video is written to stdout (and any messages go to stderr).
+This is most useful with the "ts" format, which can be piped directly into other tools.
+
+The "h264" format is the raw H.264 elementary stream (with start codes).
+Since that has no timing information, you can use "--timestamps" to also write the frame timestamps
+in the mkvmerge "timestamp format v2" format.
+
+With "--all-streams", every camera is exported to the same file (and the stream is not given).
+This is only supported by the "avi" and "mkv" formats.
+
+Use "--start" and "--end" to export only part of the file.
+The video starts at the keyframe at or before the start, and the audio is cut to match it.
+
+Use "--subtitles" to also write the telemetry (time, speed, and GPS position) as a subtitle file next to the video.
 `,
-				Args: cobra.ExactArgs(3),
+				Args: cobra.RangeArgs(2, 3),
 				Run: func(cmd *cobra.Command, args []string) {
 					inputFile := args[0]
-					streamID := args[1]
-					destinationFilename := args[2]
+					streamID := ""
+					destinationFilename := args[len(args)-1]
+
+					// If we're writing the video to stdout, then keep our messages (and errors) out of it.
+					messageOutput := os.Stdout
+					if destinationFilename == "-" {
+						messageOutput = os.Stderr
+					}
+
+					if allStreams != (len(args) == 2) {
+						fmt.Fprintf(messageOutput, "Error: give either a stream or --all-streams\n")
+						os.Exit(1)
+					}
+					if !allStreams {
+						streamID = args[1]
+					}
+					switch subtitlesFormat {
+					case "", "srt", "vtt":
+					default:
+						fmt.Fprintf(messageOutput, "Error: invalid subtitle format: %s\n", subtitlesFormat)
+						os.Exit(1)
+					}
+					if subtitlesFormat != "" && destinationFilename == "-" {
+						fmt.Fprintf(messageOutput, "Error: subtitles cannot be written alongside stdout\n")
+						os.Exit(1)
+					}
 
 					info, err := parseFilename(inputFile, false)
 					if err != nil {
-						fmt.Printf("Error: %v\n", err)
+						fmt.Fprintf(messageOutput, "Error: %v\n", err)
+						os.Exit(1)
+					}
+
+					info, err = trimRecording(info, startText, endText)
+					if err != nil {
+						fmt.Fprintf(messageOutput, "Error: %v\n", err)
 						os.Exit(1)
 					}
 
+					streamIDs := []string{streamID}
+					if allStreams {
+						switch format {
+						case "avi", "mkv":
+						default:
+							fmt.Fprintf(messageOutput, "Error: the %s format does not support --all-streams\n", format)
+							os.Exit(1)
+						}
+						streamIDs = roscoconv.LogicalStreamIDs(info)
+						streamID = strings.Join(streamIDs, ", ")
+					}
+
 					switch format {
 					case "avi":
-						fmt.Printf("Exporting video data from stream %s...\n", streamID)
-						file, err := roscoconv.MakeAVI(info, streamID)
+						// The AVI headers are patched at the end, so we need to be able to seek.
+						if destinationFilename == "-" {
+							fmt.Fprintf(messageOutput, "Error: the avi format cannot be written to stdout\n")
+							os.Exit(1)
+						}
+
+						fmt.Fprintf(messageOutput, "Exporting video data from stream %s...\n", streamID)
+						out, err := createOutputFile(destinationFilename)
 						if err != nil {
-							fmt.Printf("Error: %v\n", err)
+							panic(fmt.Sprintf("Couldn't create output file: %v", err))
+						}
+						defer out.Close()
+						err = roscoconv.WriteAVI(out, info, streamIDs)
+						if err != nil {
+							fmt.Fprintf(messageOutput, "Error: %v\n", err)
+							os.Exit(1)
+						}
+					case "mp4":
+						fmt.Fprintf(messageOutput, "Exporting video data from stream %s...\n", streamID)
+						file, err := roscoconv.MakeMP4(info, streamID)
+						if err != nil {
+							fmt.Fprintf(messageOutput, "Error: %v\n", err)
 							os.Exit(1)
 						}
 
-						out, err := os.Create(destinationFilename)
+						out, err := createOutputFile(destinationFilename)
 						if err != nil {
 							panic(fmt.Sprintf("Couldn't create output file: %v", err))
 						}
 						defer out.Close()
-						err = riff.Write(out, file)
+						err = mp4.Write(out, file)
 						if err != nil {
 							panic(err)
 						}
-					default:
-						fmt.Printf("Invalid video format: %s\n", format)
-						os.Exit(1)
+					case "mkv":
+						fmt.Fprintf(messageOutput, "Exporting video data from stream %s...\n", streamID)
+						file, err := roscoconv.MakeMKV(info, streamIDs, metadataTrack)
+						if err != nil {
+							fmt.Fprintf(messageOutput, "Error: %v\n", err)
+							os.Exit(1)
+						}
+
+						out, err := createOutputFile(destinationFilename)
+						if err != nil {
+							panic(fmt.Sprintf("Couldn't create output file: %v", err))
+						}
+						defer out.Close()
+						err = mkv.Write(out, file)
+						if err != nil {
+							panic(err)
+						}
+					case "h264":
+						fmt.Fprintf(messageOutput, "Exporting video data from stream %s...\n", streamID)
+						videoTrack, err := roscoconv.MakeVideoTrack(info, streamID)
+						if err != nil {
+							fmt.Fprintf(messageOutput, "Error: %v\n", err)
+							os.Exit(1)
+						}
+
+						out, err := createOutputFile(destinationFilename)
+						if err != nil {
+							panic(fmt.Sprintf("Couldn't create output file: %v", err))
+						}
+						defer out.Close()
+						err = roscoconv.WriteAnnexB(out, videoTrack)
+						if err != nil {
+							panic(err)
+						}
+
+						if timestampsFilename != "" {
+							fmt.Fprintf(messageOutput, "Writing timestamps to %s...\n", timestampsFilename)
+							timestampsOut, err := os.Create(timestampsFilename)
+							if err != nil {
+								panic(fmt.Sprintf("Couldn't create timestamps file: %v", err))
+							}
+							defer timestampsOut.Close()
+							err = roscoconv.WriteTimestamps(timestampsOut, videoTrack)
+							if err != nil {
+								panic(err)
+							}
+						}
+					case "ts":
+						fmt.Fprintf(messageOutput, "Exporting video data from stream %s...\n", streamID)
+						file, err := roscoconv.MakeTS(info, streamID)
+						if err != nil {
+							fmt.Fprintf(messageOutput, "Error: %v\n", err)
+							os.Exit(1)
+						}
+
+						out, err := createOutputFile(destinationFilename)
+						if err != nil {
+							panic(fmt.Sprintf("Couldn't create output file: %v", err))
+						}
+						defer out.Close()
+						err = mpegts.Write(out, file)
+						if err != nil {
+							panic(err)
+						}
+					default:
+						fmt.Fprintf(messageOutput, "Invalid video format: %s\n", format)
+						os.Exit(1)
+					}
+
+					if subtitlesFormat != "" {
+						// The AVI and H.264 exports start at the first video frame; the others start at the first video frame or audio chunk.
+						videoOnly := format == "avi" || format == "h264"
+						err = writeSubtitles(info, streamIDs, videoOnly, subtitlesFormat, subtitlesFilename(destinationFilename, subtitlesFormat))
+						if err != nil {
+							fmt.Fprintf(messageOutput, "Error: %v\n", err)
+							os.Exit(1)
+						}
 					}
 				},
 			}
-			exportVideoCommand.Flags().StringVar(&format, "format", format, "The output file format (can be one of: avi)")
+			exportVideoCommand.Flags().StringVar(&format, "format", format, "The output file format (can be one of: avi, h264, mkv, mp4, ts)")
+			exportVideoCommand.Flags().BoolVar(&metadataTrack, "metadata-track", metadataTrack, "Include a text track with the per-frame metadata (mkv only)")
+			exportVideoCommand.Flags().BoolVar(&allStreams, "all-streams", allStreams, "Export every camera to the same file (avi and mkv only)")
+			exportVideoCommand.Flags().StringVar(&timestampsFilename, "timestamps", timestampsFilename, "Also write the frame timestamps (mkvmerge v2 format) to this file (h264 only)")
+			exportVideoCommand.Flags().StringVar(&startText, "start", startText, "Only export from this point on: an offset from the start of the recording (such as \"1m30s\" or \"90\") or a wall-clock time (such as \"2026-03-04 14:02:00\" or \"14:02:00\")")
+			exportVideoCommand.Flags().StringVar(&endText, "end", endText, "Only export up to this point (in the same form as --start)")
+			exportVideoCommand.Flags().StringVar(&subtitlesFormat, "subtitles", subtitlesFormat, "Also write the telemetry as subtitles next to the video (can be one of: srt, vtt)")
 			exportCommand.AddCommand(exportVideoCommand)
 		}
 
 		{
-			outputDirectory := ""
+			format := ""
+			startText := ""
+			endText := ""
+			videoOnly := false
+			var exportSubtitlesCommand = &cobra.Command{
+				Use:   "subtitles <input-file> <stream> <output-file>",
+				Short: "Export the telemetry from a file as subtitles",
+				Long: `
+This writes a subtitle file with the wall-clock time, speed, and GPS position for each second of the video.
+Any player can show these on top of the video exported from the same stream.
+
+For NVR files, the telemetry comes from the metadata on the video frames; for ASD files, it comes from the GPS packets.
+
+The subtitles start at the first video frame or audio chunk, which is where the "mkv", "mp4", and "ts" exports start.
+AVI exports start at the first video frame, so use "--video-only" for those.
+
+If the format is not given, then it is taken from the output file's extension.
+`,
+				Args: cobra.ExactArgs(3),
+				Run: func(cmd *cobra.Command, args []string) {
+					inputFile := args[0]
+					streamID := args[1]
+					destinationFilename := args[2]
+					if format == "" {
+						format = strings.TrimPrefix(path.Ext(destinationFilename), ".")
+					}
+
+					info, err := parseFilename(inputFile, false)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+
+					info, err = trimRecording(info, startText, endText)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+
+					err = writeSubtitles(info, []string{streamID}, videoOnly, format, destinationFilename)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+				},
+			}
+			exportSubtitlesCommand.Flags().StringVar(&format, "format", format, "The output file format (can be one of: srt, vtt); if not specified, it is taken from the output file's extension")
+			exportSubtitlesCommand.Flags().BoolVar(&videoOnly, "video-only", videoOnly, "Start the subtitles at the first video frame (for AVI exports)")
+			exportSubtitlesCommand.Flags().StringVar(&startText, "start", startText, "Only export from this point on: an offset from the start of the recording (such as \"1m30s\" or \"90\") or a wall-clock time (such as \"2026-03-04 14:02:00\" or \"14:02:00\")")
+			exportSubtitlesCommand.Flags().StringVar(&endText, "end", endText, "Only export up to this point (in the same form as --start)")
+			exportCommand.AddCommand(exportSubtitlesCommand)
+		}
+
+		{
+			format := ""
+			var exportTelemetryCommand = &cobra.Command{
+				Use:   "telemetry <input-file> <output-file>",
+				Short: "Export the telemetry from a file as a table",
+				Long: `
+This writes all of the per-frame metadata from a file as a table, with one row per video frame or GPS packet.
+Each row has the stream ID ("stream_id"), the timestamp from the start of the recording in microseconds ("timestamp_us"),
+and the wall-clock time ("time"), followed by one column per metadata entry.  Sub-metadata entries are given dotted names
+(for example, "gps.lat").
+
+For NVR files, the rows come from the video frames that have metadata; for ASD files, they come from the GPS packets.
+
+The format can be "csv" (with a header row) or "ndjson" (one JSON object per line).
+If the format is not given, then it is taken from the output file's extension.
+
+If the output file is "-", then the table is written to stdout (and any messages go to stderr).
+`,
+				Args: cobra.ExactArgs(2),
+				Run: func(cmd *cobra.Command, args []string) {
+					inputFile := args[0]
+					destinationFilename := args[1]
+
+					// If we're writing the table to stdout, then keep our messages (and errors) out of it.
+					messageOutput := os.Stdout
+					if destinationFilename == "-" {
+						messageOutput = os.Stderr
+					}
+					if format == "" {
+						format = strings.TrimPrefix(path.Ext(destinationFilename), ".")
+					}
+					var write func(io.Writer, *roscoconv.TelemetryTable) error
+					switch format {
+					case "csv":
+						write = roscoconv.WriteTelemetryCSV
+					case "ndjson", "jsonl":
+						write = roscoconv.WriteTelemetryNDJSON
+					default:
+						fmt.Fprintf(messageOutput, "Error: invalid telemetry format: %s\n", format)
+						os.Exit(1)
+					}
+
+					info, err := parseFilename(inputFile, false)
+					if err != nil {
+						fmt.Fprintf(messageOutput, "Error: %v\n", err)
+						os.Exit(1)
+					}
+
+					table := roscoconv.MakeTelemetryTable(info)
+					if len(table.Rows) == 0 {
+						fmt.Fprintf(messageOutput, "Error: there is no telemetry in %s\n", inputFile)
+						os.Exit(1)
+					}
+
+					fmt.Fprintf(messageOutput, "Writing %d rows of telemetry...\n", len(table.Rows))
+					out, err := createOutputFile(destinationFilename)
+					if err != nil {
+						fmt.Fprintf(messageOutput, "Error: couldn't create output file: %v\n", err)
+						os.Exit(1)
+					}
+					defer out.Close()
+
+					err = write(out, table)
+					if err != nil {
+						fmt.Fprintf(messageOutput, "Error: %v\n", err)
+						os.Exit(1)
+					}
+				},
+			}
+			exportTelemetryCommand.Flags().StringVar(&format, "format", format, "The output file format (can be one of: csv, ndjson); if not specified, it is taken from the output file's extension")
+			exportCommand.AddCommand(exportTelemetryCommand)
+		}
+
+		{
+			options := dvproOptions{
+				Format: "avi",
+			}
+			filter := dvproFilter{}
+			recursive := false
+			fromText := ""
+			toText := ""
+			skipExisting := false
+			dryRun := false
 			var exportDvproCommand = &cobra.Command{
 				Use:   "dvpro <input-file>[ ...]",
 				Short: "Export a video streams from a list of files and/or directories",
 				Long: `
 The intent of this command is to replicate the functionality from the DV-Pro tools provided by Rosco.
 With this, you can quickly export all of the videos from a particular directory or collection of files.
+
+With the "avi" format, each camera is exported to its own file ("_1.avi", "_2.avi", and so on).
+With the "mkv" format, every camera and audio stream is exported to a single file.
+
+Use "--start" and "--end" to export only part of each file; files with nothing in that range are skipped.
+
+Use "--subtitles" to also write the telemetry (time, speed, and GPS position) as a subtitle file next to each video.
+
+Directories are searched for ".nvr" and ".asd" files (in any case); use "--recursive" to search their
+subdirectories too.  The files can be narrowed down with "--include" and "--exclude", which are globs
+that are matched against the file names (in any case), and with "--from" and "--to", which keep only
+the files that were recording during that time (according to their headers and filenames, or the
+recordings themselves when those don't say when they ended).
+
+Use "--skip-existing" to leave out the files whose output files are all already there, and "--dry-run"
+to only list the files that would be created, along with their estimated sizes.  The output files are
+written under temporary names (ending in ".partial") and renamed once they are all done, so an export
+that was interrupted will be done again; "--skip-existing" only needs to read the headers of the files
+that it skips.
 `,
 				Args: cobra.MinimumNArgs(1),
 				Run: func(cmd *cobra.Command, args []string) {
-					inputFiles := []string{}
-					for _, arg := range args {
-						fileInfo, err := os.Stat(arg)
+					switch options.Subtitles {
+					case "", "srt", "vtt":
+					default:
+						fmt.Printf("Error: invalid subtitle format: %s\n", options.Subtitles)
+						os.Exit(1)
+					}
+					err := filter.Validate()
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+					if fromText != "" {
+						filter.From, err = parseWallClock(fromText, time.Now())
 						if err != nil {
-							fmt.Printf("Error: %v\n", err)
+							fmt.Printf("Error: invalid --from: %v\n", err)
 							os.Exit(1)
 						}
-						if fileInfo.IsDir() {
-							fileInfos, err := ioutil.ReadDir(arg)
-							if err != nil {
-								fmt.Printf("Error: %v\n", err)
-								os.Exit(1)
-							}
-							for _, fileInfo := range fileInfos {
-								if !fileInfo.IsDir() {
-									if strings.HasSuffix(fileInfo.Name(), ".nvr") || strings.HasSuffix(fileInfo.Name(), ".asd") {
-										inputFiles = append(inputFiles, arg+"/"+fileInfo.Name())
-									}
+					}
+					if toText != "" {
+						reference := filter.From
+						if reference.IsZero() {
+							reference = time.Now()
+						}
+						filter.To, err = parseWallClock(toText, reference)
+						if err != nil {
+							fmt.Printf("Error: invalid --to: %v\n", err)
+							os.Exit(1)
+						}
+					}
+					if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+						fmt.Printf("Error: --to must be after --from\n")
+						os.Exit(1)
+					}
+
+					inputFiles, err := findInputFiles(args, recursive)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+					inputFiles = filter.Apply(inputFiles)
+
+					var totalSize int64
+					outputCount := 0
+					for _, inputFile := range inputFiles {
+						plan, err := planDvproFile(inputFile, options)
+						if err != nil {
+							fmt.Printf("Error: %s: %v\n", inputFile, err)
+							os.Exit(1)
+						}
+						if skipExisting && plan.Exists() {
+							fmt.Printf("Skipping %s: its output files already exist.\n", inputFile)
+							continue
+						}
+						err = plan.Load()
+						if err != nil {
+							fmt.Printf("Error: %s: %v\n", inputFile, err)
+							os.Exit(1)
+						}
+						if dryRun {
+							fmt.Printf("%s:\n", inputFile)
+							for _, output := range plan.Outputs {
+								if output.EstimatedSize < 0 {
+									fmt.Printf("-> %s\n", output.Path)
+									continue
 								}
+								fmt.Printf("-> %s (about %s)\n", output.Path, formatSize(output.EstimatedSize))
 							}
-						} else {
-							inputFiles = append(inputFiles, arg)
+							totalSize += plan.EstimatedSize()
+							outputCount += len(plan.Outputs)
+							continue
+						}
+
+						_, err = writeDvproPlan(plan)
+						if err != nil {
+							fmt.Printf("Error: %v\n", err)
+							os.Exit(1)
 						}
 					}
+					if dryRun {
+						fmt.Printf("%d files would be created (about %s).\n", outputCount, formatSize(totalSize))
+					}
+				},
+			}
+			exportDvproCommand.Flags().StringVar(&options.OutputDirectory, "output-directory", options.OutputDirectory, "The output directory; if not specified, the new files will be created next to the NVR files")
+			exportDvproCommand.Flags().StringVar(&options.Format, "format", options.Format, "The output file format (can be one of: avi, mkv)")
+			exportDvproCommand.Flags().BoolVar(&options.MetadataTrack, "metadata-track", options.MetadataTrack, "Include a text track with the per-frame metadata (mkv only)")
+			exportDvproCommand.Flags().StringVar(&options.Start, "start", options.Start, "Only export from this point on: an offset from the start of the recording (such as \"1m30s\" or \"90\") or a wall-clock time (such as \"2026-03-04 14:02:00\" or \"14:02:00\")")
+			exportDvproCommand.Flags().StringVar(&options.End, "end", options.End, "Only export up to this point (in the same form as --start)")
+			exportDvproCommand.Flags().StringVar(&options.Subtitles, "subtitles", options.Subtitles, "Also write the telemetry as subtitles next to each video (can be one of: srt, vtt)")
+			exportDvproCommand.Flags().BoolVarP(&recursive, "recursive", "r", recursive, "Search the subdirectories of the given directories too")
+			exportDvproCommand.Flags().StringArrayVar(&filter.Include, "include", filter.Include, "Only export the files whose names match this glob (such as \"*.nvr\"); this may be given more than once")
+			exportDvproCommand.Flags().StringArrayVar(&filter.Exclude, "exclude", filter.Exclude, "Don't export the files whose names match this glob; this may be given more than once")
+			exportDvproCommand.Flags().StringVar(&fromText, "from", fromText, "Only export the files that were recording at or after this time (such as \"2026-03-04 14:02:00\"; a time of day is today)")
+			exportDvproCommand.Flags().StringVar(&toText, "to", toText, "Only export the files that were recording before this time (a time of day is on the same day as --from)")
+			exportDvproCommand.Flags().BoolVar(&skipExisting, "skip-existing", skipExisting, "Skip the files whose output files already exist")
+			exportDvproCommand.Flags().BoolVar(&dryRun, "dry-run", dryRun, "Only show the files that would be created, with their estimated sizes")
+			exportCommand.AddCommand(exportDvproCommand)
+		}
+
+		{
+			streamID := "0"
+			outputFilename := ""
+			format := ""
+			var exportConcatCommand = &cobra.Command{
+				Use:   "concat <input-file>[ ...] --output <output-file>",
+				Short: "Export a video stream from several files as a single video",
+				Long: `
+This joins the same stream from several files (and/or directories of files) into a single video.
+
+The files are put in order by their recording time, and the gaps between them are kept, so that
+the video lines up with the wall-clock time.  The gaps show the last frame before them, and the
+audio is silent.
 
+If the format is not given, then it is taken from the output file's extension.
+`,
+				Args: cobra.MinimumNArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					if outputFilename == "-" {
+						fmt.Printf("Error: the video cannot be written to stdout\n")
+						os.Exit(1)
+					}
+					if format == "" {
+						format = strings.TrimPrefix(path.Ext(outputFilename), ".")
+					}
+
+					inputFiles, err := dvproInputFiles(args)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+
+					infos := []*rosco.FileInfo{}
 					for _, inputFile := range inputFiles {
+						fmt.Printf("Reading %s...\n", inputFile)
 						info, err := parseFilename(inputFile, false)
 						if err != nil {
 							fmt.Printf("Error: %v\n", err)
 							os.Exit(1)
 						}
+						infos = append(infos, info)
+					}
 
-						logicalStreamIDs := []string{}
-						{
-							logicalStreamMap := map[string]bool{}
-							for _, streamID := range info.StreamIDs() {
-								if len(streamID) < 1 {
-									continue
-								}
-								if streamID == "images" {
-									continue
-								}
-								logicalStreamMap[string(streamID[0])] = true
-							}
-							for id := range logicalStreamMap {
-								logicalStreamIDs = append(logicalStreamIDs, id)
-							}
-							sort.Strings(logicalStreamIDs)
-						}
+					info, err := roscoconv.Concat(infos)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
 
-						for streamIndex, streamID := range logicalStreamIDs {
-							fmt.Printf("Exporting video data from stream %s...\n", streamID)
-							file, err := roscoconv.MakeAVI(info, streamID)
-							if err != nil {
-								fmt.Printf("Error: %v\n", err)
-								os.Exit(1)
-							}
+					err = writeJoinedVideo(info, streamID, format, outputFilename)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+				},
+			}
+			exportConcatCommand.Flags().StringVar(&streamID, "stream", streamID, "The logical stream to export")
+			exportConcatCommand.Flags().StringVarP(&outputFilename, "output", "o", outputFilename, "The output file")
+			exportConcatCommand.Flags().StringVar(&format, "format", format, "The output file format (can be one of: avi, mp4); if not specified, it is taken from the output file's extension")
+			exportConcatCommand.MarkFlagRequired("output")
+			exportCommand.AddCommand(exportConcatCommand)
+		}
 
-							destinationFolder := outputDirectory
-							if len(outputDirectory) == 0 {
-								destinationFolder = path.Dir(inputFile)
-							}
-							destinationBaseName := path.Base(inputFile)
-							if strings.HasSuffix(destinationBaseName, ".nvr") {
-								destinationBaseName = strings.TrimSuffix(path.Base(inputFile), ".nvr")
-							} else if strings.HasSuffix(destinationBaseName, ".asd") {
-								destinationBaseName = strings.TrimSuffix(path.Base(info.Filename), ".asd")
-							}
-							destinationFilename := fmt.Sprintf("%s_%d.avi", destinationBaseName, streamIndex+1)
-							destinationFullPath := destinationFilename
-							if len(destinationFolder) > 0 {
-								destinationFullPath = strings.TrimSuffix(destinationFolder, "/") + "/" + destinationFullPath
-							}
-							fmt.Printf("-> %s\n", destinationFullPath)
-							out, err := os.Create(destinationFullPath)
-							if err != nil {
-								panic(fmt.Sprintf("Couldn't create output file: %v", err))
-							}
-							defer out.Close()
-							err = riff.Write(out, file)
-							if err != nil {
-								panic(err)
-							}
+		{
+			segmentDuration := 6 * time.Second
+			noAudio := false
+			segmentFormat := hlsSegmentFormatFMP4
+			startText := ""
+			endText := ""
+			var exportHLSCommand = &cobra.Command{
+				Use:   "hls <input-file> <output-directory>",
+				Short: "Export the video streams from a file for HTTP Live Streaming (HLS)",
+				Long: `
+This cuts each camera's video (with audio) into segments that start at keyframes, and writes a video-on-demand
+playlist for each camera ("camera0.m3u8", and so on).  The output directory can be served from any static file server.
+
+Each segment is at least as long as the segment duration, and it ends at the next keyframe after that.
+
+The segment format is one of:
+* fmp4: Fragmented MP4 segments (the default), which browsers can play with the Opus audio.
+* ts: MPEG transport stream segments; not every player supports Opus audio in these, so you may need "--no-audio".
+`,
+				Args: cobra.ExactArgs(2),
+				Run: func(cmd *cobra.Command, args []string) {
+					inputFile := args[0]
+					outputDirectory := args[1]
+					if segmentDuration <= 0 {
+						fmt.Printf("Error: the segment duration must be positive\n")
+						os.Exit(1)
+					}
+					if segmentFormat != hlsSegmentFormatFMP4 && segmentFormat != hlsSegmentFormatTS {
+						fmt.Printf("Error: unsupported segment format: %s\n", segmentFormat)
+						os.Exit(1)
+					}
+
+					info, err := parseFilename(inputFile, false)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+
+					info, err = trimRecording(info, startText, endText)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+
+					err = exportHLS(info, outputDirectory, segmentDuration, segmentFormat, noAudio)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+				},
+			}
+			exportHLSCommand.Flags().DurationVar(&segmentDuration, "segment-duration", segmentDuration, "The target duration of each segment")
+			exportHLSCommand.Flags().StringVar(&segmentFormat, "segment-format", segmentFormat, "The segment format (fmp4 or ts)")
+			exportHLSCommand.Flags().BoolVar(&noAudio, "no-audio", noAudio, "Leave out the audio")
+			exportHLSCommand.Flags().StringVar(&startText, "start", startText, "Only export from this point on: an offset from the start of the recording (such as \"1m30s\" or \"90\") or a wall-clock time (such as \"2026-03-04 14:02:00\" or \"14:02:00\")")
+			exportHLSCommand.Flags().StringVar(&endText, "end", endText, "Only export up to this point (in the same form as --start)")
+			exportCommand.AddCommand(exportHLSCommand)
+		}
+
+		{
+			outputFilename := ""
+			format := ""
+			var exportTrackCommand = &cobra.Command{
+				Use:   "track <input-file>[ ...] --output <output-file>",
+				Short: "Export the GPS track from one or more files",
+				Long: `
+This writes the GPS positions, speeds, and times from one or more files (and/or directories of files)
+as a GPX, KML, or GeoJSON track, which can be opened in most mapping software.
+
+For NVR files, the positions come from the GPS metadata on the video frames; for ASD files, they come from the GPS packets.
+Each file is its own segment of the track, and the segments are put in order by time.
+
+If the format is not given, then it is taken from the output file's extension.
+`,
+				Args: cobra.MinimumNArgs(1),
+				Run: func(cmd *cobra.Command, args []string) {
+					if format == "" {
+						format = strings.TrimPrefix(path.Ext(outputFilename), ".")
+					}
+					switch format {
+					case "gpx", "kml", "geojson", "json":
+					default:
+						fmt.Printf("Error: invalid track format: %s\n", format)
+						os.Exit(1)
+					}
+
+					inputFiles, err := dvproInputFiles(args)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
+					}
+
+					infos := []*rosco.FileInfo{}
+					for _, inputFile := range inputFiles {
+						fmt.Printf("Reading %s...\n", inputFile)
+						info, err := parseFilename(inputFile, false)
+						if err != nil {
+							fmt.Printf("Error: %v\n", err)
+							os.Exit(1)
 						}
+						infos = append(infos, info)
+					}
+
+					name := strings.TrimSuffix(path.Base(outputFilename), path.Ext(outputFilename))
+					err = writeTrack(roscoconv.MakeTrack(name, infos), format, outputFilename)
+					if err != nil {
+						fmt.Printf("Error: %v\n", err)
+						os.Exit(1)
 					}
 				},
 			}
-			exportDvproCommand.Flags().StringVar(&outputDirectory, "output-directory", outputDirectory, "The output directory; if not specified, the new files will be created next to the NVR files")
-			exportCommand.AddCommand(exportDvproCommand)
+			exportTrackCommand.Flags().StringVarP(&outputFilename, "output", "o", outputFilename, "The output file")
+			exportTrackCommand.Flags().StringVar(&format, "format", format, "The output file format (can be one of: gpx, kml, geojson); if not specified, it is taken from the output file's extension")
+			exportTrackCommand.MarkFlagRequired("output")
+			exportCommand.AddCommand(exportTrackCommand)
 		}
 	}
 
@@ -450,14 +1394,14 @@ With this, you can quickly export all of the videos from a particular directory
 func parseFilename(filename string, headerOnly bool) (*rosco.FileInfo, error) {
 	handle, err := os.Open(filename)
 	if err != nil {
-		fmt.Printf("Could not open file '%s': %v\n", filename, err)
+		fmt.Fprintf(os.Stderr, "Could not open file '%s': %v\n", filename, err)
 		return nil, err
 	}
 	defer handle.Close()
 
 	info, err := rosco.ParseReader(handle, headerOnly)
 	if err != nil {
-		fmt.Printf("Could not parse file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Could not parse file: %v\n", err)
 		return nil, err
 	}
 
@@ -539,4 +1483,62 @@ func printFileInfo(info *rosco.FileInfo) {
 		fmt.Printf("   Video: %d bytes\n", videoDataLength)
 		fmt.Printf("   Images: %d\n", images)
 	}
+
+	for _, streamID := range roscoconv.LogicalStreamIDs(info) {
+		streamInfo, err := roscoconv.AnalyzeVideo(info, streamID)
+		if err != nil {
+			continue // There's no video in this stream.
+		}
+		fmt.Printf("Video: %s\n", streamID)
+		if sps := streamInfo.SPS; sps != nil {
+			fmt.Printf("   Profile: %s\n", h264info.ProfileName(sps.ProfileIDC, sps.ConstraintFlags))
+			fmt.Printf("   Level: %s\n", h264info.LevelName(sps.ProfileIDC, sps.ConstraintFlags, sps.LevelIDC))
+			fmt.Printf("   Resolution: %dx%d\n", sps.Width(), sps.Height())
+			fmt.Printf("   Coded size: %dx%d\n", sps.WidthInMacroblocks*16, sps.HeightInMapUnits*16)
+			fmt.Printf("   Cropping: left %d, right %d, top %d, bottom %d\n", sps.CropLeft, sps.CropRight, sps.CropTop, sps.CropBottom)
+			fmt.Printf("   Chroma format: %d\n", sps.ChromaFormatIDC)
+			fmt.Printf("   Bit depth: %d\n", sps.BitDepthLuma)
+			fmt.Printf("   Interlaced: %t\n", !sps.FrameMBsOnly)
+			if rate, scale, okay := sps.FrameRate(); okay {
+				fmt.Printf("   VUI frame rate: %.3f (%d / %d)\n", float64(rate)/float64(scale), rate, scale)
+			} else {
+				fmt.Printf("   VUI frame rate: (none)\n")
+			}
+		} else {
+			fmt.Printf("   SPS: (none)\n")
+		}
+		if pps := streamInfo.PPS; pps != nil {
+			entropyCoding := "CAVLC"
+			if pps.EntropyCodingModeCABAC {
+				entropyCoding = "CABAC"
+			}
+			fmt.Printf("   Entropy coding: %s\n", entropyCoding)
+		}
+		fmt.Printf("   Frames: %d\n", streamInfo.Frames)
+		fmt.Printf("   IDR frames: %d\n", streamInfo.IDRFrames)
+		fmt.Printf("   GOP length: %.1f average, %d maximum\n", streamInfo.AverageGOPLength(), streamInfo.MaxGOPLength)
+		fmt.Printf("   Frame rate: %.3f\n", streamInfo.FrameRate())
+		fmt.Printf("   Duration: %v\n", time.Duration(streamInfo.Duration())*time.Microsecond)
+		fmt.Printf("   Bitrate: %.0f bits/s\n", streamInfo.Bitrate())
+	}
+
+	gaps := info.VideoGaps()
+	fmt.Printf("Video gaps: (%d)\n", len(gaps))
+	firstTimestamps := map[string]uint64{}
+	for _, gap := range gaps {
+		firstTimestamp, ok := firstTimestamps[gap.Camera]
+		if !ok {
+			firstTimestamp = info.VideoTimestamps(gap.Camera)[0]
+			firstTimestamps[gap.Camera] = firstTimestamp
+		}
+		fmt.Printf("   Camera %s: at %v for %v\n", gap.Camera, time.Duration(gap.Start-firstTimestamp)*time.Microsecond, time.Duration(gap.Duration())*time.Microsecond)
+	}
+}
+
+// createOutputFile creates the given output file; "-" means stdout.
+func createOutputFile(filename string) (*os.File, error) {
+	if filename == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(filename)
 }
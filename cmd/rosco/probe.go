@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/riff"
+)
+
+// probeFile reads an AVI file, prints a summary of it, and validates it.
+//
+// This returns the list of problems that were found.
+func probeFile(filename string) ([]string, error) {
+	handle, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	contents, err := riff.Read(handle)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Segments: %d\n", len(contents.Nodes))
+	fmt.Printf("OpenDML: %t\n", contents.ExtendedHeader != nil)
+	fmt.Printf("Main header:\n")
+	fmt.Printf("   Microseconds per frame: %d\n", contents.Header.MicroSecPerFrame)
+	fmt.Printf("   Flags: 0x%08x\n", contents.Header.Flags)
+	fmt.Printf("   Total frames: %d\n", contents.Header.TotalFrames)
+	if contents.ExtendedHeader != nil {
+		fmt.Printf("   Total frames (OpenDML): %d\n", contents.ExtendedHeader.TotalFrames)
+	}
+	fmt.Printf("   Dimensions: %dx%d\n", contents.Header.Width, contents.Header.Height)
+	if contents.Date != "" {
+		fmt.Printf("Date: %s\n", contents.Date)
+	}
+	if len(contents.Info) > 0 {
+		fmt.Printf("Info:\n")
+		for _, entry := range contents.Info {
+			fmt.Printf("   %s: %s\n", entry.ID, entry.Value)
+		}
+	}
+
+	chunkCounts := make([]int, len(contents.Streams))
+	for _, chunk := range contents.MovieChunks {
+		if chunk.StreamIndex >= 0 && chunk.StreamIndex < len(chunkCounts) {
+			chunkCounts[chunk.StreamIndex]++
+		}
+	}
+	for streamIndex, stream := range contents.Streams {
+		fmt.Printf("Stream %d:\n", streamIndex)
+		fmt.Printf("   Type: %s\n", stream.Header.Type)
+		fmt.Printf("   Handler: %q\n", stream.Header.Handler)
+		fmt.Printf("   Rate: %d / %d\n", stream.Header.Rate, stream.Header.Scale)
+		fmt.Printf("   Length: %d\n", stream.Header.Length)
+		fmt.Printf("   Sample size: %d\n", stream.Header.SampleSize)
+		fmt.Printf("   Chunks: %d\n", chunkCounts[streamIndex])
+		switch fmt.Sprintf("%s", stream.Header.Type) {
+		case "vids":
+			fmt.Printf("   Compression: %s\n", stream.VideoFormat.Compression)
+			fmt.Printf("   Dimensions: %dx%d\n", stream.VideoFormat.Width, stream.VideoFormat.Height)
+			fmt.Printf("   Bit count: %d\n", stream.VideoFormat.BitCount)
+		case "auds":
+			fmt.Printf("   Format tag: 0x%04x\n", stream.AudioFormat.FormatTag)
+			fmt.Printf("   Channels: %d\n", stream.AudioFormat.Channels)
+			fmt.Printf("   Sample rate: %d\n", stream.AudioFormat.SamplesPerSec)
+			fmt.Printf("   Bits per sample: %d\n", stream.AudioFormat.BitsPerSample)
+			fmt.Printf("   Average bytes per second: %d\n", stream.AudioFormat.AvgBytesPerSec)
+		}
+		if contents.SuperIndexes[streamIndex] != nil {
+			fmt.Printf("   Super index entries: %d\n", len(contents.SuperIndexes[streamIndex]))
+		}
+	}
+	if contents.Index != nil {
+		fmt.Printf("Index entries: %d\n", len(contents.Index))
+	}
+
+	return contents.Validate(), nil
+}
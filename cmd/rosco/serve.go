@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mp4"
+	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
+)
+
+//go:embed ui
+var serveUI embed.FS
+
+// serveCacheSize is the number of exported videos that the server keeps in memory.
+//
+// Browsers fetch videos with many small range requests, so the video has to stick around between them.
+const serveCacheSize = 4
+
+// serveListingLifetime is how long the library listing is kept before the directory is read again.
+//
+// The listing is also read again as soon as the directory changes.
+const serveListingLifetime = 10 * time.Second
+
+// libraryServer serves the recordings in a directory over HTTP.
+type libraryServer struct {
+	Library string // The directory with the recordings.
+
+	listingLock     sync.Mutex
+	listingFiles    map[string]string // The recordings in the library, by their names.
+	listingNames    []string
+	listingModified time.Time // The modification time of the directory when it was read.
+	listingTime     time.Time // When the directory was read.
+
+	lock    sync.Mutex
+	cache   []*servedVideo           // The most recently used video is last.
+	pending map[string]*pendingVideo // The videos that are being exported, by their keys.
+}
+
+// pendingVideo is a video that is being exported; `done` is closed once it is finished.
+type pendingVideo struct {
+	done  chan struct{}
+	video *servedVideo
+	err   error
+}
+
+// servedVideo is an exported video that is being served.
+type servedVideo struct {
+	Key      string // The file, its size and modification time, the stream, and the format.
+	Data     []byte
+	Modified time.Time
+}
+
+// recordingJSON is a recording in the library listing.
+type recordingJSON struct {
+	File      string     `json:"file"` // The name of the file in the library.
+	Size      int64      `json:"size"` // In bytes.
+	Modified  time.Time  `json:"modified"`
+	Format    string     `json:"format"`
+	Filename  string     `json:"filename"` // The filename from the file itself.
+	StartTime *time.Time `json:"startTime,omitempty"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+}
+
+// Handler returns the HTTP handler for the server.
+//
+// These are the endpoints:
+//
+//	GET /api/recordings                   The recordings in the library (from their headers).
+//	GET /api/recordings/{file}            Everything about a recording (the same as "info --json").
+//	GET /video/{file}/{stream}.{mp4|avi}  A camera's video (with audio), exported on the fly.
+//	GET /                                 The web UI.
+func (s *libraryServer) Handler() (http.Handler, error) {
+	ui, err := fs.Sub(serveUI, "ui")
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/recordings", s.handleRecordings)
+	mux.HandleFunc("GET /api/recordings/{file}", s.handleRecording)
+	mux.HandleFunc("GET /video/{file}/{name}", s.handleVideo)
+	mux.Handle("GET /", http.FileServer(http.FS(ui)))
+	return logRequests(mux), nil
+}
+
+// logRequests logs every request.
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logrus.Debugf("%s %s (range: %q)", r.Method, r.URL.Path, r.Header.Get("Range"))
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(value)
+	if err != nil {
+		logrus.Warnf("Could not write the response: %v", err)
+	}
+}
+
+// writeJSONError writes an error as a JSON response.
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// libraryFiles returns the recordings in the library, by their names.
+//
+// The directory is only read again if it has changed or the listing is old, since browsers send
+// a request for every range of a video that they play.
+func (s *libraryServer) libraryFiles() (map[string]string, []string, error) {
+	stat, err := os.Stat(s.Library)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.listingLock.Lock()
+	defer s.listingLock.Unlock()
+
+	if s.listingFiles != nil && stat.ModTime().Equal(s.listingModified) && time.Since(s.listingTime) < serveListingLifetime {
+		return s.listingFiles, s.listingNames, nil
+	}
+
+	inputFiles, err := dvproInputFiles([]string{s.Library})
+	if err != nil {
+		return nil, nil, err
+	}
+	files := map[string]string{}
+	names := []string{}
+	for _, inputFile := range inputFiles {
+		name := path.Base(inputFile)
+		files[name] = inputFile
+		names = append(names, name)
+	}
+	s.listingFiles = files
+	s.listingNames = names
+	s.listingModified = stat.ModTime()
+	s.listingTime = time.Now()
+	return files, names, nil
+}
+
+// libraryFile returns the path of a recording in the library.
+//
+// Only the recordings that the library lists can be found, so there is no way to get at anything else.
+func (s *libraryServer) libraryFile(name string) (string, error) {
+	files, _, err := s.libraryFiles()
+	if err != nil {
+		return "", err
+	}
+	filename, okay := files[name]
+	if !okay {
+		return "", fmt.Errorf("no such recording: %s", name)
+	}
+	return filename, nil
+}
+
+// handleRecordings lists the recordings in the library.
+func (s *libraryServer) handleRecordings(w http.ResponseWriter, r *http.Request) {
+	files, names, err := s.libraryFiles()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	recordings := []recordingJSON{}
+	for _, name := range names {
+		filename := files[name]
+		stat, err := os.Stat(filename)
+		if err != nil {
+			logrus.Warnf("Skipping %s: %v", filename, err)
+			continue
+		}
+		info, err := parseFilename(filename, true)
+		if err != nil {
+			logrus.Warnf("Skipping %s: %v", filename, err)
+			continue
+		}
+		recording := recordingJSON{
+			File:     name,
+			Size:     stat.Size(),
+			Modified: stat.ModTime(),
+			Format:   info.Format,
+			Filename: info.Filename,
+		}
+		if span, err := recordingSpanForFile(filename); err == nil {
+			recording.StartTime = &span.Start
+			if !span.End.IsZero() {
+				recording.EndTime = &span.End
+			}
+		}
+		recordings = append(recordings, recording)
+	}
+	writeJSON(w, http.StatusOK, recordings)
+}
+
+// handleRecording shows everything about a recording.
+func (s *libraryServer) handleRecording(w http.ResponseWriter, r *http.Request) {
+	filename, err := s.libraryFile(r.PathValue("file"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	file := makeInfoFileJSON(filename, false)
+	file.Path = r.PathValue("file")
+	if file.Error != "" {
+		writeJSON(w, http.StatusUnprocessableEntity, file)
+		return
+	}
+	writeJSON(w, http.StatusOK, file)
+}
+
+// handleVideo serves a camera's video, exporting it if it isn't already in the cache.
+//
+// This supports range requests, so that browsers can seek.
+func (s *libraryServer) handleVideo(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	format := strings.TrimPrefix(path.Ext(name), ".")
+	streamID := strings.TrimSuffix(name, path.Ext(name))
+	contentType := ""
+	switch format {
+	case "mp4":
+		contentType = "video/mp4"
+	case "avi":
+		contentType = "video/x-msvideo"
+	default:
+		http.Error(w, fmt.Sprintf("invalid video format: %s", format), http.StatusNotFound)
+		return
+	}
+
+	filename, err := s.libraryFile(r.PathValue("file"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	video, err := s.video(filename, stat, streamID, format)
+	if err != nil {
+		logrus.Warnf("Could not export stream %s of %s: %v", streamID, filename, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, name, video.Modified, bytes.NewReader(video.Data))
+}
+
+// video returns the exported video for a stream of a file, from the cache if possible.
+//
+// If the same video is already being exported (browsers often ask for a video several times at once),
+// then this waits for that export instead of starting another one.
+func (s *libraryServer) video(filename string, stat os.FileInfo, streamID string, format string) (*servedVideo, error) {
+	key := fmt.Sprintf("%s|%d|%d|%s|%s", filename, stat.Size(), stat.ModTime().UnixNano(), streamID, format)
+
+	s.lock.Lock()
+	for i, video := range s.cache {
+		if video.Key == key {
+			s.cache = append(append(s.cache[0:i:i], s.cache[i+1:]...), video)
+			s.lock.Unlock()
+			return video, nil
+		}
+	}
+	if pending, okay := s.pending[key]; okay {
+		s.lock.Unlock()
+		<-pending.done
+		return pending.video, pending.err
+	}
+	pending := &pendingVideo{
+		done: make(chan struct{}),
+	}
+	if s.pending == nil {
+		s.pending = map[string]*pendingVideo{}
+	}
+	s.pending[key] = pending
+	s.lock.Unlock()
+
+	pending.video, pending.err = exportServedVideo(key, filename, stat, streamID, format)
+
+	s.lock.Lock()
+	delete(s.pending, key)
+	if pending.err == nil {
+		s.cache = append(s.cache, pending.video)
+		if len(s.cache) > serveCacheSize {
+			s.cache = s.cache[len(s.cache)-serveCacheSize:]
+		}
+	}
+	s.lock.Unlock()
+	close(pending.done)
+
+	return pending.video, pending.err
+}
+
+// exportServedVideo exports a stream of a file as a video to serve.
+func exportServedVideo(key string, filename string, stat os.FileInfo, streamID string, format string) (*servedVideo, error) {
+	logrus.Infof("Exporting stream %s of %s as %s.", streamID, filename, format)
+	info, err := parseFilename(filename, false)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &seekBuffer{}
+	switch format {
+	case "mp4":
+		file, err := roscoconv.MakeMP4(info, streamID)
+		if err != nil {
+			return nil, err
+		}
+		err = mp4.Write(out, file)
+		if err != nil {
+			return nil, err
+		}
+	case "avi":
+		err = roscoconv.WriteAVI(out, info, []string{streamID})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid video format: %s", format)
+	}
+
+	return &servedVideo{
+		Key:      key,
+		Data:     out.Bytes(),
+		Modified: stat.ModTime(),
+	}, nil
+}
+
+// seekBuffer is an in-memory `io.WriteSeeker`.
+type seekBuffer struct {
+	data     []byte
+	position int64
+}
+
+// Write implements `io.Writer`.
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	end := b.position + int64(len(p))
+	if end > int64(len(b.data)) {
+		b.data = append(b.data, make([]byte, end-int64(len(b.data)))...)
+	}
+	copy(b.data[b.position:], p)
+	b.position = end
+	return len(p), nil
+}
+
+// Seek implements `io.Seeker`.
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = b.position + offset
+	case io.SeekEnd:
+		position = int64(len(b.data)) + offset
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if position < 0 {
+		return 0, fmt.Errorf("negative position: %d", position)
+	}
+	b.position = position
+	return position, nil
+}
+
+// Bytes returns everything that was written.
+func (b *seekBuffer) Bytes() []byte {
+	return b.data
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
+)
+
+// subtitlesFilename returns the name of the subtitle file that goes alongside a video file.
+func subtitlesFilename(videoFilename string, format string) string {
+	return strings.TrimSuffix(videoFilename, path.Ext(videoFilename)) + "." + format
+}
+
+// writeSubtitles writes the telemetry subtitles for the given streams to a file.
+//
+// The format can be either "srt" or "vtt".  If `videoOnly` is true, then the subtitles start at the
+// first video frame (as AVI files do); otherwise, they start at the first video frame or audio chunk.
+func writeSubtitles(info *rosco.FileInfo, streamIDs []string, videoOnly bool, format string, filename string) error {
+	var write func(io.Writer, []roscoconv.SubtitleCue) error
+	switch format {
+	case "srt":
+		write = roscoconv.WriteSRT
+	case "vtt":
+		write = roscoconv.WriteWebVTT
+	default:
+		return fmt.Errorf("invalid subtitle format: %s", format)
+	}
+
+	cues, err := roscoconv.MakeSubtitles(info, streamIDs, videoOnly)
+	if err != nil {
+		return err
+	}
+	if len(cues) == 0 {
+		return fmt.Errorf("there is no time or telemetry for the subtitles")
+	}
+
+	fmt.Printf("Writing subtitles to %s...\n", filename)
+	out, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("couldn't create subtitle file: %v", err)
+	}
+	defer out.Close()
+	return write(out, cues)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
+)
+
+// wallClockFormats are the formats that a wall-clock time can be given in.
+var wallClockFormats = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+}
+
+// timeOfDayFormats are the formats that a time of day can be given in; the date comes from elsewhere.
+var timeOfDayFormats = []string{
+	"15:04:05",
+	"15:04",
+}
+
+// parseWallClock parses a wall-clock time.
+//
+// This can be a full date and time, or just a time of day, in which case the date is taken from
+// the reference time.  Times without a time zone are in the local time zone.
+func parseWallClock(text string, reference time.Time) (time.Time, error) {
+	for _, format := range wallClockFormats {
+		if value, err := time.ParseInLocation(format, text, time.Local); err == nil {
+			return value, nil
+		}
+	}
+	for _, format := range timeOfDayFormats {
+		if value, err := time.ParseInLocation(format, text, time.Local); err == nil {
+			reference = reference.In(time.Local)
+			return time.Date(reference.Year(), reference.Month(), reference.Day(), value.Hour(), value.Minute(), value.Second(), 0, time.Local), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("could not parse time: %s", text)
+}
+
+// parseOffset parses a relative offset.
+//
+// This is either a duration (such as "1m30s") or a number of seconds (such as "90").
+func parseOffset(text string) (time.Duration, bool) {
+	if value, err := time.ParseDuration(text); err == nil {
+		return value, true
+	}
+	if value, err := strconv.ParseFloat(text, 64); err == nil {
+		return time.Duration(value * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// parseTimeRangeValue turns a "--start" or "--end" value into a chunk timestamp.
+//
+// A relative offset is from the start of the recording; anything else is a wall-clock time.
+func parseTimeRangeValue(info *rosco.FileInfo, text string) (uint64, error) {
+	firstTimestamp, _ := roscoconv.FirstTimestamp(info)
+	if offset, okay := parseOffset(text); okay {
+		if offset < 0 {
+			return 0, fmt.Errorf("offset is negative: %s", text)
+		}
+		return firstTimestamp + uint64(offset.Microseconds()), nil
+	}
+
+	startTime, okay := info.Time(firstTimestamp)
+	if !okay {
+		return 0, fmt.Errorf("the recording time of %s is unknown, so only relative offsets can be used", info.Filename)
+	}
+	value, err := parseWallClock(strings.TrimSpace(text), startTime)
+	if err != nil {
+		return 0, err
+	}
+	return timestampForTime(info, value)
+}
+
+// timestampForTime converts a wall-clock time into a chunk timestamp.
+//
+// A time before the start of the recording is treated as the start.
+func timestampForTime(info *rosco.FileInfo, value time.Time) (uint64, error) {
+	origin, okay := info.TimestampOrigin()
+	if !okay {
+		return 0, fmt.Errorf("the recording time of %s is unknown", info.Filename)
+	}
+	if value.Before(origin) {
+		return 0, nil
+	}
+	return uint64(value.Sub(origin).Microseconds()), nil
+}
+
+// parseTimeRange parses the "--start" and "--end" options into a range of chunk timestamps.
+//
+// If the start is not given, then the range starts at 0; if the end is not given, then the range does not end.
+func parseTimeRange(info *rosco.FileInfo, startText string, endText string) (start uint64, end uint64, err error) {
+	end = roscoconv.NoEnd
+	if startText != "" {
+		start, err = parseTimeRangeValue(info, startText)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid start: %v", err)
+		}
+	}
+	if endText != "" {
+		end, err = parseTimeRangeValue(info, endText)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid end: %v", err)
+		}
+	}
+	return start, end, nil
+}
+
+// hasChunksInRange returns true if any of the audio or video in the recording is in the range.
+func hasChunksInRange(info *rosco.FileInfo, start uint64, end uint64) bool {
+	for _, chunk := range info.Chunks {
+		switch {
+		case chunk.Video != nil:
+			if chunk.Video.Timestamp >= start && chunk.Video.Timestamp < end {
+				return true
+			}
+		case chunk.Audio != nil:
+			if chunk.Audio.Timestamp >= start && chunk.Audio.Timestamp < end {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trimRecording applies the "--start" and "--end" options to a recording.
+//
+// If neither option was given, then the recording is returned as it is.
+func trimRecording(info *rosco.FileInfo, startText string, endText string) (*rosco.FileInfo, error) {
+	if startText == "" && endText == "" {
+		return info, nil
+	}
+	start, end, err := parseTimeRange(info, startText, endText)
+	if err != nil {
+		return nil, err
+	}
+	return roscoconv.Trim(info, start, end)
+}
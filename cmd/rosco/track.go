@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
+)
+
+// writeTrack writes a GPS track to a file.
+//
+// The format can be "gpx", "kml", or "geojson" (or "json").
+func writeTrack(track *roscoconv.Track, format string, filename string) error {
+	var write func(io.Writer, *roscoconv.Track) error
+	switch format {
+	case "gpx":
+		write = roscoconv.WriteGPX
+	case "kml":
+		write = roscoconv.WriteKML
+	case "geojson", "json":
+		write = roscoconv.WriteGeoJSON
+	default:
+		return fmt.Errorf("invalid track format: %s", format)
+	}
+
+	if track.PointCount() == 0 {
+		return fmt.Errorf("there are no GPS positions in the recording")
+	}
+
+	fmt.Printf("Writing %d GPS positions to %s...\n", track.PointCount(), filename)
+	out, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("couldn't create track file: %v", err)
+	}
+	defer out.Close()
+	return write(out, track)
+}
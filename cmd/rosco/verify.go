@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/h264info"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+	"github.com/tekkamanendless/rosco-dashcam-processor/roscoconv"
+)
+
+// verifyFile checks the H.264 data in every video chunk of a file and prints each damaged frame.
+//
+// This returns the number of damaged frames.
+func verifyFile(info *rosco.FileInfo) int {
+	damagedFrames := 0
+	for _, streamID := range roscoconv.LogicalStreamIDs(info) {
+		fmt.Printf("Stream %s:\n", streamID)
+		verifier := h264info.NewVerifier()
+		frames := 0
+		streamDamagedFrames := 0
+		for _, chunk := range info.Chunks {
+			if chunk.Video == nil || !strings.HasPrefix(chunk.ID, streamID) {
+				continue
+			}
+			frames++
+
+			report := verifier.Check(chunk.Video.Media)
+			problems := []string{}
+			for _, problem := range report.Problems {
+				problems = append(problems, fmt.Sprintf("offset %d: %s", chunk.Video.MediaOffset+int64(problem.Offset), problem.Message))
+			}
+
+			// The substream tells us what kind of frame the recorder thinks that this is.
+			switch {
+			case strings.HasSuffix(chunk.ID, "0"):
+				if !report.IDR {
+					problems = append(problems, fmt.Sprintf("offset %d: keyframe substream, but there is no IDR slice", chunk.Offset))
+				}
+			case strings.HasSuffix(chunk.ID, "1"):
+				if report.IDR {
+					problems = append(problems, fmt.Sprintf("offset %d: delta-frame substream, but there is an IDR slice", chunk.Offset))
+				}
+			}
+			if report.IDR && (!report.HasSPS || !report.HasPPS) {
+				missing := []string{}
+				if !report.HasSPS {
+					missing = append(missing, "SPS")
+				}
+				if !report.HasPPS {
+					missing = append(missing, "PPS")
+				}
+				problems = append(problems, fmt.Sprintf("offset %d: IDR frame without a preceding %s", chunk.Offset, strings.Join(missing, " or ")))
+			}
+
+			if len(problems) == 0 {
+				continue
+			}
+			streamDamagedFrames++
+			fmt.Printf("   Frame %d (stream %s, timestamp %v, chunk offset %d):\n", frames-1, chunk.ID, time.Duration(chunk.Video.Timestamp)*time.Microsecond, chunk.Offset)
+			for _, problem := range problems {
+				fmt.Printf("      %s\n", problem)
+			}
+		}
+		fmt.Printf("   Frames: %d (%d damaged)\n", frames, streamDamagedFrames)
+		damagedFrames += streamDamagedFrames
+	}
+	return damagedFrames
+}
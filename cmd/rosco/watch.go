@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchStateVersion is the version of the state file that "watch" writes.
+const watchStateVersion = 1
+
+// watchState is what "watch" has already exported; it is saved as JSON so that nothing is exported twice.
+type watchState struct {
+	Version int                        `json:"version"`
+	Files   map[string]*watchStateFile `json:"files"` // By `watchKey`.
+}
+
+// watchStateFile is a file that "watch" has exported.
+type watchStateFile struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Processed time.Time `json:"processed"`
+	Outputs   []string  `json:"outputs,omitempty"`
+}
+
+// watchKey identifies a file in the state.
+//
+// This uses the name and size of the file (not its full path), so that a card that is mounted somewhere
+// else the next time is still recognized.
+func watchKey(filename string, size int64) string {
+	return fmt.Sprintf("%s|%d", path.Base(filename), size)
+}
+
+// loadWatchState loads the state file; if it doesn't exist, then the state is empty.
+func loadWatchState(filename string) (*watchState, error) {
+	state := &watchState{
+		Version: watchStateVersion,
+		Files:   map[string]*watchStateFile{},
+	}
+	contents, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(contents, state)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the state file %s: %v", filename, err)
+	}
+	if state.Version != watchStateVersion {
+		return nil, fmt.Errorf("unsupported state file version: %d", state.Version)
+	}
+	if state.Files == nil {
+		state.Files = map[string]*watchStateFile{}
+	}
+	return state, nil
+}
+
+// save writes the state file.
+//
+// The state is written to a temporary file first, so that the state file is never half-written.
+func (s *watchState) save(filename string) error {
+	contents, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	temporaryFilename := filename + ".tmp"
+	err = os.WriteFile(temporaryFilename, contents, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temporaryFilename, filename)
+}
+
+// The limits for retrying a failed export.
+//
+// A failure is often temporary (for example, the card was pulled out in the middle of a read, or the
+// output disk was full), so the export is tried again after a delay that doubles each time.
+const (
+	watchMaxAttempts   = 5
+	watchRetryDelay    = time.Minute
+	watchMaxRetryDelay = time.Hour
+)
+
+// watchFailure is a file whose export failed.
+type watchFailure struct {
+	Attempts int
+	RetryAt  time.Time // When to try again.
+}
+
+// watchObservation is the size and modification time of a file when it was last polled.
+type watchObservation struct {
+	Size     int64
+	Modified time.Time
+}
+
+// watcher exports the new recordings in a directory.
+type watcher struct {
+	Directory string
+	StateFile string
+	Settle    time.Duration // How long a file must go without changing before it is exported.
+	Options   dvproOptions
+
+	state        *watchState
+	observations map[string]watchObservation // By path, from the last poll.
+	failures     map[string]*watchFailure    // By `watchKey`; these are not saved, so a restart tries them again.
+}
+
+// poll looks for new recordings and exports the ones that have stopped growing.
+//
+// A file is exported once it has the same size and modification time as on the previous poll, and it
+// hasn't been modified for the settle time.
+func (w *watcher) poll(now time.Time) {
+	inputFiles, err := dvproInputFiles([]string{w.Directory})
+	if err != nil {
+		// The card may have been removed; try again on the next poll.
+		logrus.Warnf("Could not read %s: %v", w.Directory, err)
+		return
+	}
+
+	observations := map[string]watchObservation{}
+	for _, inputFile := range inputFiles {
+		stat, err := os.Stat(inputFile)
+		if err != nil {
+			logrus.Warnf("Skipping %s: %v", inputFile, err)
+			continue
+		}
+		key := watchKey(inputFile, stat.Size())
+		if _, okay := w.state.Files[key]; okay {
+			continue
+		}
+		failure := w.failures[key]
+		if failure != nil && (failure.Attempts >= watchMaxAttempts || now.Before(failure.RetryAt)) {
+			continue
+		}
+
+		observation := watchObservation{
+			Size:     stat.Size(),
+			Modified: stat.ModTime(),
+		}
+		previous, okay := w.observations[inputFile]
+		if !okay || previous != observation || now.Sub(observation.Modified) < w.Settle {
+			logrus.Debugf("Waiting for %s to stop growing (%d bytes).", inputFile, observation.Size)
+			observations[inputFile] = observation
+			continue
+		}
+
+		fmt.Printf("Exporting %s...\n", inputFile)
+		outputFiles, err := exportDvproFile(inputFile, w.Options)
+		if err != nil {
+			// Only successful exports are saved, so that a failed one can be tried again.
+			if failure == nil {
+				failure = &watchFailure{}
+				if w.failures == nil {
+					w.failures = map[string]*watchFailure{}
+				}
+				w.failures[key] = failure
+			}
+			failure.Attempts++
+			delay := watchRetryDelay << (failure.Attempts - 1)
+			if delay > watchMaxRetryDelay {
+				delay = watchMaxRetryDelay
+			}
+			failure.RetryAt = now.Add(delay)
+			if failure.Attempts >= watchMaxAttempts {
+				fmt.Printf("Error: %v (giving up on %s after %d attempts)\n", err, inputFile, failure.Attempts)
+			} else {
+				fmt.Printf("Error: %v (trying %s again in %v)\n", err, inputFile, delay)
+			}
+			continue
+		}
+		delete(w.failures, key)
+
+		w.state.Files[key] = &watchStateFile{
+			Path:      inputFile,
+			Size:      stat.Size(),
+			Processed: time.Now(),
+			Outputs:   outputFiles,
+		}
+		err = w.state.save(w.StateFile)
+		if err != nil {
+			logrus.Errorf("Could not save the state file %s: %v", w.StateFile, err)
+		}
+	}
+	w.observations = observations
+}
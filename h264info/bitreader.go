@@ -0,0 +1,85 @@
+package h264info
+
+import (
+	"fmt"
+)
+
+// RemoveEmulationPrevention removes the emulation prevention bytes (the 0x03 in 0x000003) from a NAL unit.
+func RemoveEmulationPrevention(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		result = append(result, b)
+	}
+	return result
+}
+
+// bitReader reads individual bits (and Exp-Golomb codes) from a byte slice.
+type bitReader struct {
+	data   []byte
+	offset int // The offset in bits.
+}
+
+// readBits reads up to 64 bits as an unsigned value.
+func (r *bitReader) readBits(count int) (uint64, error) {
+	if r.offset+count > len(r.data)*8 {
+		return 0, fmt.Errorf("not enough data: need %d bits at bit offset %d of %d", count, r.offset, len(r.data)*8)
+	}
+	var value uint64
+	for i := 0; i < count; i++ {
+		bit := (r.data[r.offset/8] >> (7 - uint(r.offset%8))) & 1
+		value = value<<1 | uint64(bit)
+		r.offset++
+	}
+	return value, nil
+}
+
+// readFlag reads a single bit.
+func (r *bitReader) readFlag() (bool, error) {
+	value, err := r.readBits(1)
+	return value == 1, err
+}
+
+// readUE reads an unsigned Exp-Golomb code.
+func (r *bitReader) readUE() (uint64, error) {
+	leadingZeros := 0
+	for {
+		bit, err := r.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		if bit == 1 {
+			break
+		}
+		leadingZeros++
+		if leadingZeros > 32 {
+			return 0, fmt.Errorf("invalid Exp-Golomb code at bit offset %d", r.offset)
+		}
+	}
+	value, err := r.readBits(leadingZeros)
+	if err != nil {
+		return 0, err
+	}
+	return (1 << uint(leadingZeros)) - 1 + value, nil
+}
+
+// readSE reads a signed Exp-Golomb code.
+func (r *bitReader) readSE() (int64, error) {
+	value, err := r.readUE()
+	if err != nil {
+		return 0, err
+	}
+	if value%2 == 1 {
+		return int64((value + 1) / 2), nil
+	}
+	return -int64(value / 2), nil
+}
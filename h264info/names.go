@@ -0,0 +1,57 @@
+package h264info
+
+import "fmt"
+
+// ProfileName returns the name of the given profile.
+//
+// The constraint flags are needed to tell the constrained variants apart.
+func ProfileName(profileIDC uint8, constraintFlags uint8) string {
+	constraintSet1 := constraintFlags&0x40 != 0
+	constraintSet3 := constraintFlags&0x10 != 0
+	switch profileIDC {
+	case 66:
+		if constraintSet1 {
+			return "Constrained Baseline"
+		}
+		return "Baseline"
+	case 77:
+		return "Main"
+	case 88:
+		return "Extended"
+	case 100:
+		return "High"
+	case 110:
+		if constraintSet3 {
+			return "High 10 Intra"
+		}
+		return "High 10"
+	case 122:
+		if constraintSet3 {
+			return "High 4:2:2 Intra"
+		}
+		return "High 4:2:2"
+	case 244:
+		if constraintSet3 {
+			return "High 4:4:4 Intra"
+		}
+		return "High 4:4:4 Predictive"
+	case 44:
+		return "CAVLC 4:4:4 Intra"
+	}
+	return fmt.Sprintf("Unknown (%d)", profileIDC)
+}
+
+// LevelName returns the name of the given level (for example, "3.1").
+func LevelName(profileIDC uint8, constraintFlags uint8, levelIDC uint8) string {
+	// Level 1b is signaled with constraint_set3_flag in the Baseline, Main, and Extended profiles.
+	if levelIDC == 11 && constraintFlags&0x10 != 0 {
+		switch profileIDC {
+		case 66, 77, 88:
+			return "1b"
+		}
+	}
+	if levelIDC == 9 {
+		return "1b"
+	}
+	return fmt.Sprintf("%d.%d", levelIDC/10, levelIDC%10)
+}
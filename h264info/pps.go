@@ -0,0 +1,52 @@
+package h264info
+
+import (
+	"fmt"
+)
+
+// PPS is the leading part of a parsed H.264 picture parameter set.
+type PPS struct {
+	ID                     uint64
+	SPSID                  uint64
+	EntropyCodingModeCABAC bool // True for CABAC; false for CAVLC.
+	BottomFieldPicOrder    bool
+	NumSliceGroups         uint64
+}
+
+// ParsePPS parses a PPS NAL unit (including its NAL header byte, but without a start code).
+//
+// Only the fields up to the slice groups are parsed; the rest of the PPS depends on the SPS.
+func ParsePPS(nalu []byte) (*PPS, error) {
+	if len(nalu) < 2 {
+		return nil, fmt.Errorf("PPS is too short: %d bytes", len(nalu))
+	}
+	if nalu[0]&0x1f != 8 {
+		return nil, fmt.Errorf("not a PPS: NAL unit type %d", nalu[0]&0x1f)
+	}
+
+	r := &bitReader{data: RemoveEmulationPrevention(nalu[1:])}
+	pps := &PPS{}
+	var err error
+	pps.ID, err = r.readUE()
+	if err != nil {
+		return nil, fmt.Errorf("could not read pic_parameter_set_id: %v", err)
+	}
+	pps.SPSID, err = r.readUE()
+	if err != nil {
+		return nil, fmt.Errorf("could not read seq_parameter_set_id: %v", err)
+	}
+	pps.EntropyCodingModeCABAC, err = r.readFlag()
+	if err != nil {
+		return nil, fmt.Errorf("could not read entropy_coding_mode_flag: %v", err)
+	}
+	pps.BottomFieldPicOrder, err = r.readFlag()
+	if err != nil {
+		return nil, fmt.Errorf("could not read bottom_field_pic_order_in_frame_present_flag: %v", err)
+	}
+	numSliceGroupsMinus1, err := r.readUE()
+	if err != nil {
+		return nil, fmt.Errorf("could not read num_slice_groups_minus1: %v", err)
+	}
+	pps.NumSliceGroups = numSliceGroupsMinus1 + 1
+	return pps, nil
+}
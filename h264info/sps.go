@@ -0,0 +1,250 @@
+package h264info
+
+import (
+	"fmt"
+)
+
+// SPS is a parsed H.264 sequence parameter set.
+type SPS struct {
+	ProfileIDC         uint8
+	ConstraintFlags    uint8
+	LevelIDC           uint8
+	ID                 uint64
+	ChromaFormatIDC    uint64
+	BitDepthLuma       uint64
+	BitDepthChroma     uint64
+	Log2MaxFrameNum    uint64
+	PicOrderCountType  uint64
+	MaxNumRefFrames    uint64
+	WidthInMacroblocks uint64
+	HeightInMapUnits   uint64
+	FrameMBsOnly       bool
+	CropLeft           uint64
+	CropRight          uint64
+	CropTop            uint64
+	CropBottom         uint64
+
+	VUI *VUI // This is nil if the SPS has no VUI parameters.
+}
+
+// VUI is the (leading part of the) video usability information from an SPS.
+type VUI struct {
+	AspectRatioIDC    uint8
+	SARWidth          uint16
+	SARHeight         uint16
+	VideoFullRange    bool
+	ColourPrimaries   uint8
+	TransferFunction  uint8
+	MatrixCoefficient uint8
+
+	TimingInfoPresent bool
+	NumUnitsInTick    uint32
+	TimeScale         uint32
+	FixedFrameRate    bool
+}
+
+// ParseSPS parses an SPS NAL unit (including its NAL header byte, but without a start code).
+func ParseSPS(nalu []byte) (*SPS, error) {
+	if len(nalu) < 4 {
+		return nil, fmt.Errorf("SPS is too short: %d bytes", len(nalu))
+	}
+	if nalu[0]&0x1f != 7 {
+		return nil, fmt.Errorf("not an SPS: NAL unit type %d", nalu[0]&0x1f)
+	}
+
+	r := &bitReader{data: RemoveEmulationPrevention(nalu[1:])}
+	sps := &SPS{
+		ChromaFormatIDC: 1,
+		BitDepthLuma:    8,
+		BitDepthChroma:  8,
+	}
+	var err error
+	var value uint64
+
+	// We only care about the first error; the readers keep failing once they run out of data.
+	read := func(count int) uint64 {
+		if err != nil {
+			return 0
+		}
+		value, err = r.readBits(count)
+		return value
+	}
+	readFlag := func() bool {
+		return read(1) == 1
+	}
+	readUE := func() uint64 {
+		if err != nil {
+			return 0
+		}
+		value, err = r.readUE()
+		return value
+	}
+	readSE := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var signedValue int64
+		signedValue, err = r.readSE()
+		return signedValue
+	}
+
+	sps.ProfileIDC = uint8(read(8))
+	sps.ConstraintFlags = uint8(read(8))
+	sps.LevelIDC = uint8(read(8))
+	sps.ID = readUE()
+
+	switch sps.ProfileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		sps.ChromaFormatIDC = readUE()
+		if sps.ChromaFormatIDC == 3 {
+			readFlag() // separate_colour_plane_flag
+		}
+		sps.BitDepthLuma = readUE() + 8
+		sps.BitDepthChroma = readUE() + 8
+		readFlag() // qpprime_y_zero_transform_bypass_flag
+		// seq_scaling_matrix_present_flag
+		if readFlag() {
+			listCount := 8
+			if sps.ChromaFormatIDC == 3 {
+				listCount = 12
+			}
+			for i := 0; i < listCount; i++ {
+				// seq_scaling_list_present_flag
+				if !readFlag() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				lastScale := int64(8)
+				nextScale := int64(8)
+				for j := 0; j < size; j++ {
+					if nextScale != 0 {
+						deltaScale := readSE()
+						nextScale = (lastScale + deltaScale + 256) % 256
+					}
+					if nextScale != 0 {
+						lastScale = nextScale
+					}
+				}
+			}
+		}
+	}
+
+	sps.Log2MaxFrameNum = readUE() + 4
+	sps.PicOrderCountType = readUE()
+	switch sps.PicOrderCountType {
+	case 0:
+		readUE() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		readFlag() // delta_pic_order_always_zero_flag
+		readSE()   // offset_for_non_ref_pic
+		readSE()   // offset_for_top_to_bottom_field
+		cycleLength := readUE()
+		if cycleLength > 255 {
+			return nil, fmt.Errorf("invalid num_ref_frames_in_pic_order_cnt_cycle: %d", cycleLength)
+		}
+		for i := uint64(0); i < cycleLength; i++ {
+			readSE() // offset_for_ref_frame
+		}
+	}
+	sps.MaxNumRefFrames = readUE()
+	readFlag() // gaps_in_frame_num_value_allowed_flag
+	sps.WidthInMacroblocks = readUE() + 1
+	sps.HeightInMapUnits = readUE() + 1
+	sps.FrameMBsOnly = readFlag()
+	if !sps.FrameMBsOnly {
+		readFlag() // mb_adaptive_frame_field_flag
+	}
+	readFlag() // direct_8x8_inference_flag
+	// frame_cropping_flag
+	if readFlag() {
+		sps.CropLeft = readUE()
+		sps.CropRight = readUE()
+		sps.CropTop = readUE()
+		sps.CropBottom = readUE()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse SPS: %v", err)
+	}
+
+	// vui_parameters_present_flag
+	if readFlag() && err == nil {
+		vui := &VUI{}
+		// aspect_ratio_info_present_flag
+		if readFlag() {
+			vui.AspectRatioIDC = uint8(read(8))
+			if vui.AspectRatioIDC == 255 { // Extended_SAR
+				vui.SARWidth = uint16(read(16))
+				vui.SARHeight = uint16(read(16))
+			}
+		}
+		// overscan_info_present_flag
+		if readFlag() {
+			readFlag() // overscan_appropriate_flag
+		}
+		// video_signal_type_present_flag
+		if readFlag() {
+			read(3) // video_format
+			vui.VideoFullRange = readFlag()
+			// colour_description_present_flag
+			if readFlag() {
+				vui.ColourPrimaries = uint8(read(8))
+				vui.TransferFunction = uint8(read(8))
+				vui.MatrixCoefficient = uint8(read(8))
+			}
+		}
+		// chroma_loc_info_present_flag
+		if readFlag() {
+			readUE() // chroma_sample_loc_type_top_field
+			readUE() // chroma_sample_loc_type_bottom_field
+		}
+		vui.TimingInfoPresent = readFlag()
+		if vui.TimingInfoPresent {
+			vui.NumUnitsInTick = uint32(read(32))
+			vui.TimeScale = uint32(read(32))
+			vui.FixedFrameRate = readFlag()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse VUI: %v", err)
+		}
+		sps.VUI = vui
+	}
+
+	return sps, nil
+}
+
+// Width returns the width of the decoded picture (after cropping), in pixels.
+func (s *SPS) Width() int {
+	cropUnitX := uint64(1)
+	switch s.ChromaFormatIDC {
+	case 1, 2:
+		cropUnitX = 2
+	}
+	return int(s.WidthInMacroblocks*16 - cropUnitX*(s.CropLeft+s.CropRight))
+}
+
+// Height returns the height of the decoded picture (after cropping), in pixels.
+func (s *SPS) Height() int {
+	frameHeightFactor := uint64(1)
+	if !s.FrameMBsOnly {
+		frameHeightFactor = 2
+	}
+	cropUnitY := frameHeightFactor
+	if s.ChromaFormatIDC == 1 {
+		cropUnitY *= 2
+	}
+	return int(s.HeightInMapUnits*16*frameHeightFactor - cropUnitY*(s.CropTop+s.CropBottom))
+}
+
+// FrameRate returns the frame rate from the VUI timing information as a fraction (rate / scale).
+//
+// The last return value is false if the SPS has no timing information.
+func (s *SPS) FrameRate() (rate uint32, scale uint32, okay bool) {
+	if s.VUI == nil || !s.VUI.TimingInfoPresent || s.VUI.NumUnitsInTick == 0 || s.VUI.TimeScale == 0 {
+		return 0, 0, false
+	}
+	// There are two ticks (fields) per frame.
+	return s.VUI.TimeScale, 2 * s.VUI.NumUnitsInTick, true
+}
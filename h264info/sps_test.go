@@ -0,0 +1,146 @@
+package h264info
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// These are SPS NAL units from real encoders (mostly x264, as found in SDP "sprop-parameter-sets").
+func TestParseSPS(t *testing.T) {
+	tests := []struct {
+		name            string
+		sps             string
+		profile         string
+		level           string
+		width           int
+		height          int
+		cropBottom      uint64
+		maxNumRefFrames uint64
+		pocType         uint64
+		hasVUI          bool
+		rate            uint32
+		scale           uint32
+	}{
+		{
+			name:            "x264 1080p High 4.0",
+			sps:             "67 64 00 28 ac d9 40 78 02 27 e5 84 00 00 03 00 04 00 00 03 00 f0 3c 60 c6 58",
+			profile:         "High",
+			level:           "4.0",
+			width:           1920,
+			height:          1080,
+			cropBottom:      4,
+			maxNumRefFrames: 4,
+			pocType:         0,
+			hasVUI:          true,
+			rate:            60,
+			scale:           2,
+		},
+		{
+			name:            "x264 720p High 3.1",
+			sps:             "67 64 00 1f ac d9 40 50 05 ba 10 00 00 03 00 10 00 00 03 03 c0 f1 83 19 60",
+			profile:         "High",
+			level:           "3.1",
+			width:           1280,
+			height:          720,
+			maxNumRefFrames: 4,
+			pocType:         0,
+			hasVUI:          true,
+			rate:            60,
+			scale:           2,
+		},
+		{
+			name:            "2160p High 5.1 at 29.97 fps",
+			sps:             "67 64 00 33 ac 2c a4 00 f0 01 0f b0 15 20 20 20 28 00 00 1f 48 00 07 53 04 ed 0b 16 89",
+			profile:         "High",
+			level:           "5.1",
+			width:           3840,
+			height:          2160,
+			maxNumRefFrames: 3,
+			pocType:         0,
+			hasVUI:          true,
+			rate:            60000,
+			scale:           2002,
+		},
+		{
+			name:            "480p Main 3.0 without VUI",
+			sps:             "67 4d 00 1e 95 a8 28 0f 64",
+			profile:         "Main",
+			level:           "3.0",
+			width:           640,
+			height:          480,
+			maxNumRefFrames: 1,
+			pocType:         2,
+		},
+		{
+			name:            "240p Constrained Baseline 2.1",
+			sps:             "67 42 c0 15 d9 01 41 fb 01 10 00 00 03 00 10 00 00 03 03 23 c5 8b 92 00",
+			profile:         "Constrained Baseline",
+			level:           "2.1",
+			width:           320,
+			height:          240,
+			maxNumRefFrames: 3,
+			pocType:         2,
+			hasVUI:          true,
+			rate:            50,
+			scale:           2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nalu, err := hex.DecodeString(strings.ReplaceAll(test.sps, " ", ""))
+			if err != nil {
+				t.Fatalf("Invalid test data: %v", err)
+			}
+			sps, err := ParseSPS(nalu)
+			if err != nil {
+				t.Fatalf("Could not parse the SPS: %v", err)
+			}
+			if name := ProfileName(sps.ProfileIDC, sps.ConstraintFlags); name != test.profile {
+				t.Errorf("Expected profile %q; got %q", test.profile, name)
+			}
+			if name := LevelName(sps.ProfileIDC, sps.ConstraintFlags, sps.LevelIDC); name != test.level {
+				t.Errorf("Expected level %q; got %q", test.level, name)
+			}
+			if sps.Width() != test.width || sps.Height() != test.height {
+				t.Errorf("Expected %dx%d; got %dx%d", test.width, test.height, sps.Width(), sps.Height())
+			}
+			if sps.CropBottom != test.cropBottom {
+				t.Errorf("Expected a bottom crop of %d; got %d", test.cropBottom, sps.CropBottom)
+			}
+			if sps.ChromaFormatIDC != 1 || sps.BitDepthLuma != 8 || sps.BitDepthChroma != 8 {
+				t.Errorf("Expected 8-bit 4:2:0; got chroma format %d with %d/%d bits", sps.ChromaFormatIDC, sps.BitDepthLuma, sps.BitDepthChroma)
+			}
+			if !sps.FrameMBsOnly {
+				t.Errorf("Expected progressive video")
+			}
+			if sps.MaxNumRefFrames != test.maxNumRefFrames {
+				t.Errorf("Expected %d reference frames; got %d", test.maxNumRefFrames, sps.MaxNumRefFrames)
+			}
+			if sps.PicOrderCountType != test.pocType {
+				t.Errorf("Expected picture order count type %d; got %d", test.pocType, sps.PicOrderCountType)
+			}
+			if (sps.VUI != nil) != test.hasVUI {
+				t.Errorf("Expected VUI: %t; got %t", test.hasVUI, sps.VUI != nil)
+			}
+			rate, scale, okay := sps.FrameRate()
+			if okay != (test.rate > 0) || rate != test.rate || scale != test.scale {
+				t.Errorf("Expected a frame rate of %d/%d; got %d/%d (%t)", test.rate, test.scale, rate, scale, okay)
+			}
+		})
+	}
+}
+
+func TestParseSPSErrors(t *testing.T) {
+	for _, nalu := range [][]byte{
+		nil,
+		{0x67, 0x64, 0x00},             // Too short.
+		{0x68, 0xee, 0x3c, 0x80},       // A PPS.
+		{0x67, 0x64, 0x00, 0x28, 0x00}, // Truncated.
+	} {
+		_, err := ParseSPS(nalu)
+		if err == nil {
+			t.Errorf("Expected an error for % x", nalu)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package h264info
+
+import (
+	"sort"
+
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+// StreamInfo is a summary of an H.264 stream.
+type StreamInfo struct {
+	SPS *SPS // The first SPS in the stream (nil if there wasn't one).
+	PPS *PPS // The first PPS in the stream (nil if there wasn't one).
+
+	Frames         int    // The number of access units.
+	IDRFrames      int    // The number of access units with an IDR slice.
+	MaxGOPLength   int    // The largest number of frames from one IDR frame to the next.
+	Bytes          int64  // The total size of the access units.
+	FirstTimestamp uint64 // In microseconds.
+	LastTimestamp  uint64 // In microseconds.
+
+	intervals    []uint64 // The time between consecutive access units.
+	lastAdded    uint64
+	gopLengths   []int
+	gopLength    int
+	seenIDRFrame bool
+}
+
+// Add adds an access unit to the summary.
+//
+// The access unit can be in either Annex B or AVCC form.
+func (s *StreamInfo) Add(timestamp uint64, accessUnit []byte) {
+	if s.Frames > 0 && timestamp > s.lastAdded {
+		s.intervals = append(s.intervals, timestamp-s.lastAdded)
+	}
+	s.lastAdded = timestamp
+	if s.Frames == 0 || timestamp < s.FirstTimestamp {
+		s.FirstTimestamp = timestamp
+	}
+	if timestamp > s.LastTimestamp {
+		s.LastTimestamp = timestamp
+	}
+	s.Frames++
+	s.Bytes += int64(len(accessUnit))
+
+	isIDR := false
+	nalus, _ := h264parser.SplitNALUs(accessUnit)
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch nalu[0] & 0x1f {
+		case 5: // IDR slice
+			isIDR = true
+		case 7: // SPS
+			if s.SPS == nil {
+				if sps, err := ParseSPS(nalu); err == nil {
+					s.SPS = sps
+				}
+			}
+		case 8: // PPS
+			if s.PPS == nil {
+				if pps, err := ParsePPS(nalu); err == nil {
+					s.PPS = pps
+				}
+			}
+		}
+	}
+
+	if isIDR {
+		s.IDRFrames++
+		if s.seenIDRFrame {
+			s.gopLengths = append(s.gopLengths, s.gopLength)
+			if s.gopLength > s.MaxGOPLength {
+				s.MaxGOPLength = s.gopLength
+			}
+		}
+		s.seenIDRFrame = true
+		s.gopLength = 0
+	}
+	s.gopLength++
+}
+
+// AverageGOPLength returns the average number of frames from one IDR frame to the next.
+//
+// Only complete GOPs are counted; if there are fewer than two IDR frames, this returns 0.
+func (s *StreamInfo) AverageGOPLength() float64 {
+	if len(s.gopLengths) == 0 {
+		return 0
+	}
+	total := 0
+	for _, length := range s.gopLengths {
+		total += length
+	}
+	return float64(total) / float64(len(s.gopLengths))
+}
+
+// Duration returns the time from the first frame to the last, in microseconds.
+func (s *StreamInfo) Duration() uint64 {
+	return s.LastTimestamp - s.FirstTimestamp
+}
+
+// Bitrate returns the average bitrate in bits per second.
+//
+// The duration includes one average frame interval, so that a one-second stream of 15 frames
+// is counted as one second (and not 14/15ths of one).
+func (s *StreamInfo) Bitrate() float64 {
+	if s.Frames < 2 || s.Duration() == 0 {
+		return 0
+	}
+	duration := float64(s.Duration()) * float64(s.Frames) / float64(s.Frames-1)
+	return float64(s.Bytes) * 8 * 1000000 / duration
+}
+
+// FrameRate returns the frame rate, based on the median time between frames.
+//
+// Using the median means that gaps in the recording do not throw it off.
+func (s *StreamInfo) FrameRate() float64 {
+	if len(s.intervals) == 0 {
+		return 0
+	}
+	intervals := append([]uint64{}, s.intervals...)
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i] < intervals[j]
+	})
+	median := intervals[len(intervals)/2]
+	return 1000000 / float64(median)
+}
@@ -0,0 +1,250 @@
+package h264info
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// These are the slice types (modulo 5).
+const (
+	SliceTypeP  = 0
+	SliceTypeB  = 1
+	SliceTypeI  = 2
+	SliceTypeSP = 3
+	SliceTypeSI = 4
+)
+
+// NALUnit is a single NAL unit in an access unit.
+type NALUnit struct {
+	Offset int    // The offset of the NAL header in the access unit.
+	Data   []byte // The NAL unit, starting with the NAL header (without the start code or length).
+}
+
+// Type returns the NAL unit type.
+func (n NALUnit) Type() uint8 {
+	return n.Data[0] & 0x1f
+}
+
+// Problem is something wrong with an access unit.
+type Problem struct {
+	Offset  int // The offset in the access unit.
+	Message string
+}
+
+// String implements `fmt.Stringer`.
+func (p Problem) String() string {
+	return fmt.Sprintf("at +%d: %s", p.Offset, p.Message)
+}
+
+// AccessUnitReport is the result of checking an access unit.
+type AccessUnitReport struct {
+	NALUnits   []NALUnit
+	IDR        bool  // True if the access unit has an IDR slice.
+	HasSPS     bool  // True if the access unit has an SPS.
+	HasPPS     bool  // True if the access unit has a PPS.
+	SliceTypes []int // The slice type (modulo 5) of each slice that we could parse.
+	Problems   []Problem
+}
+
+// Verifier checks the access units in an H.264 stream.
+//
+// It remembers the parameter sets that it has seen, so the access units must be given in decode order.
+type Verifier struct {
+	spsIDs map[uint64]bool
+	ppsIDs map[uint64]bool
+}
+
+// NewVerifier creates a new `Verifier`.
+func NewVerifier() *Verifier {
+	return &Verifier{
+		spsIDs: map[uint64]bool{},
+		ppsIDs: map[uint64]bool{},
+	}
+}
+
+// SplitNALUnits splits an access unit into NAL units.
+//
+// The access unit can be in either Annex B form (start codes) or AVCC form (4-byte lengths);
+// if it is neither, then a problem is reported.  A length that runs past the end of the data
+// is reported as a truncated NAL unit.
+func SplitNALUnits(data []byte) ([]NALUnit, []Problem) {
+	if len(data) == 0 {
+		return nil, []Problem{{Offset: 0, Message: "empty access unit"}}
+	}
+	if startCodeLength(data, 0) > 0 {
+		return splitAnnexB(data)
+	}
+
+	// Try AVCC.
+	nalus := []NALUnit{}
+	offset := 0
+	for offset < len(data) {
+		if offset+4 > len(data) {
+			return nalus, []Problem{{Offset: offset, Message: fmt.Sprintf("truncated NAL unit length (%d bytes left)", len(data)-offset)}}
+		}
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		if length == 0 || offset+4+length > len(data) {
+			if len(nalus) == 0 {
+				return nil, []Problem{{Offset: 0, Message: "no start code (and not length-prefixed)"}}
+			}
+			return nalus, []Problem{{Offset: offset, Message: fmt.Sprintf("truncated NAL unit: length %d, but only %d bytes left", length, len(data)-offset-4)}}
+		}
+		nalus = append(nalus, NALUnit{Offset: offset + 4, Data: data[offset+4 : offset+4+length]})
+		offset += 4 + length
+	}
+	return nalus, nil
+}
+
+// startCodeLength returns the length of the start code at the given offset (3 or 4), or 0 if there isn't one.
+func startCodeLength(data []byte, offset int) int {
+	if offset+3 <= len(data) && data[offset] == 0 && data[offset+1] == 0 && data[offset+2] == 1 {
+		return 3
+	}
+	if offset+4 <= len(data) && data[offset] == 0 && data[offset+1] == 0 && data[offset+2] == 0 && data[offset+3] == 1 {
+		return 4
+	}
+	return 0
+}
+
+// splitAnnexB splits Annex B data into NAL units.
+func splitAnnexB(data []byte) ([]NALUnit, []Problem) {
+	nalus := []NALUnit{}
+	problems := []Problem{}
+	start := -1
+	for offset := 0; offset < len(data); {
+		length := startCodeLength(data, offset)
+		if length == 0 {
+			offset++
+			continue
+		}
+		if start >= 0 {
+			nalus = append(nalus, NALUnit{Offset: start, Data: trimTrailingZeros(data[start:offset])})
+		}
+		offset += length
+		start = offset
+	}
+	if start >= 0 {
+		nalus = append(nalus, NALUnit{Offset: start, Data: trimTrailingZeros(data[start:])})
+	}
+
+	result := []NALUnit{}
+	for _, nalu := range nalus {
+		if len(nalu.Data) == 0 {
+			problems = append(problems, Problem{Offset: nalu.Offset, Message: "empty NAL unit"})
+			continue
+		}
+		result = append(result, nalu)
+	}
+	return result, problems
+}
+
+// trimTrailingZeros removes the zero bytes from the end of a NAL unit.
+//
+// These are either "trailing_zero_8bits" or the first byte of a 4-byte start code.
+func trimTrailingZeros(data []byte) []byte {
+	for len(data) > 0 && data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// Check checks an access unit.
+//
+// This checks the NAL unit framing, the forbidden_zero_bit, the RBSP stop bit (a missing one
+// means that the NAL unit was cut short), the slice headers, and that every slice refers to
+// parameter sets that have already been seen.
+func (v *Verifier) Check(accessUnit []byte) *AccessUnitReport {
+	report := &AccessUnitReport{}
+	report.NALUnits, report.Problems = SplitNALUnits(accessUnit)
+	if len(report.NALUnits) == 0 && len(report.Problems) == 0 {
+		report.Problems = append(report.Problems, Problem{Offset: 0, Message: "no NAL units"})
+	}
+
+	for _, nalu := range report.NALUnits {
+		addProblem := func(format string, args ...interface{}) {
+			report.Problems = append(report.Problems, Problem{Offset: nalu.Offset, Message: fmt.Sprintf("NAL unit type %d: ", nalu.Type()) + fmt.Sprintf(format, args...)})
+		}
+
+		if nalu.Data[0]&0x80 != 0 {
+			addProblem("forbidden_zero_bit is set")
+			continue
+		}
+		// Everything except for the end-of-sequence and end-of-stream NAL units has an RBSP with a stop bit.
+		switch nalu.Type() {
+		case 10, 11:
+		default:
+			if len(nalu.Data) < 2 {
+				addProblem("truncated: no payload")
+				continue
+			}
+		}
+
+		switch nalu.Type() {
+		case 1, 5: // Slices
+			if nalu.Type() == 5 {
+				report.IDR = true
+				if nalu.Data[0]&0x60 == 0 {
+					addProblem("IDR slice with nal_ref_idc 0")
+				}
+			}
+			r := &bitReader{data: RemoveEmulationPrevention(nalu.Data[1:])}
+			_, err := r.readUE() // first_mb_in_slice
+			if err != nil {
+				addProblem("truncated slice header: %v", err)
+				continue
+			}
+			sliceType, err := r.readUE()
+			if err != nil {
+				addProblem("truncated slice header: %v", err)
+				continue
+			}
+			if sliceType > 9 {
+				addProblem("invalid slice_type %d", sliceType)
+				continue
+			}
+			report.SliceTypes = append(report.SliceTypes, int(sliceType%5))
+			if nalu.Type() == 5 && sliceType%5 != SliceTypeI && sliceType%5 != SliceTypeSI {
+				addProblem("IDR slice with slice_type %d", sliceType)
+			}
+			ppsID, err := r.readUE()
+			if err != nil {
+				addProblem("truncated slice header: %v", err)
+				continue
+			}
+			if !v.ppsIDs[ppsID] {
+				addProblem("slice refers to PPS %d, which has not been seen", ppsID)
+			}
+		case 7: // SPS
+			sps, err := ParseSPS(nalu.Data)
+			if err != nil {
+				addProblem("%v", err)
+				continue
+			}
+			v.spsIDs[sps.ID] = true
+			report.HasSPS = true
+		case 8: // PPS
+			pps, err := ParsePPS(nalu.Data)
+			if err != nil {
+				addProblem("%v", err)
+				continue
+			}
+			if !v.spsIDs[pps.SPSID] {
+				addProblem("PPS %d refers to SPS %d, which has not been seen", pps.ID, pps.SPSID)
+			}
+			v.ppsIDs[pps.ID] = true
+			report.HasPPS = true
+		}
+
+		switch nalu.Type() {
+		case 10, 11:
+		default:
+			// The last byte has to hold the RBSP stop bit.  Annex B data has already had its trailing
+			// zeros trimmed, so this catches length-prefixed NAL units whose length is wrong.
+			if nalu.Data[len(nalu.Data)-1] == 0 {
+				addProblem("truncated: no RBSP stop bit")
+			}
+		}
+	}
+
+	return report
+}
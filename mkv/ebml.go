@@ -0,0 +1,163 @@
+package mkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// These are the EBML and Matroska element IDs that we use.
+//
+// See https://www.matroska.org/technical/elements.html for the details.
+const (
+	IDEBML               uint32 = 0x1A45DFA3
+	IDEBMLVersion        uint32 = 0x4286
+	IDEBMLReadVersion    uint32 = 0x42F7
+	IDEBMLMaxIDLength    uint32 = 0x42F2
+	IDEBMLMaxSizeLength  uint32 = 0x42F3
+	IDDocType            uint32 = 0x4282
+	IDDocTypeVersion     uint32 = 0x4287
+	IDDocTypeReadVersion uint32 = 0x4285
+
+	IDSegment      uint32 = 0x18538067
+	IDSeekHead     uint32 = 0x114D9B74
+	IDSeek         uint32 = 0x4DBB
+	IDSeekID       uint32 = 0x53AB
+	IDSeekPosition uint32 = 0x53AC
+
+	IDInfo           uint32 = 0x1549A966
+	IDTimestampScale uint32 = 0x2AD7B1
+	IDDuration       uint32 = 0x4489
+	IDMuxingApp      uint32 = 0x4D80
+	IDWritingApp     uint32 = 0x5741
+	IDDateUTC        uint32 = 0x4461
+	IDTitle          uint32 = 0x7BA9
+
+	IDTracks            uint32 = 0x1654AE6B
+	IDTrackEntry        uint32 = 0xAE
+	IDTrackNumber       uint32 = 0xD7
+	IDTrackUID          uint32 = 0x73C5
+	IDTrackType         uint32 = 0x83
+	IDFlagDefault       uint32 = 0x88
+	IDFlagLacing        uint32 = 0x9C
+	IDDefaultDuration   uint32 = 0x23E383
+	IDName              uint32 = 0x536E
+	IDLanguage          uint32 = 0x22B59C
+	IDCodecID           uint32 = 0x86
+	IDCodecPrivate      uint32 = 0x63A2
+	IDCodecDelay        uint32 = 0x56AA
+	IDSeekPreRoll       uint32 = 0x56BB
+	IDVideo             uint32 = 0xE0
+	IDPixelWidth        uint32 = 0xB0
+	IDPixelHeight       uint32 = 0xBA
+	IDAudio             uint32 = 0xE1
+	IDSamplingFrequency uint32 = 0xB5
+	IDChannels          uint32 = 0x9F
+	IDBitDepth          uint32 = 0x6264
+
+	IDCluster       uint32 = 0x1F43B675
+	IDTimestamp     uint32 = 0xE7
+	IDSimpleBlock   uint32 = 0xA3
+	IDBlockGroup    uint32 = 0xA0
+	IDBlock         uint32 = 0xA1
+	IDBlockDuration uint32 = 0x9B
+
+	IDCues                uint32 = 0x1C53BB6B
+	IDCuePoint            uint32 = 0xBB
+	IDCueTime             uint32 = 0xB3
+	IDCueTrackPositions   uint32 = 0xB7
+	IDCueTrack            uint32 = 0xF7
+	IDCueClusterPosition  uint32 = 0xF1
+	IDCueRelativePosition uint32 = 0xF0
+)
+
+// encodeID encodes an element ID.
+//
+// Element IDs already include their length marker, so this just strips the leading zero bytes.
+func encodeID(id uint32) []byte {
+	switch {
+	case id >= 0x1000000:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x10000:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 0x100:
+		return []byte{byte(id >> 8), byte(id)}
+	default:
+		return []byte{byte(id)}
+	}
+}
+
+// encodeSize encodes an element data size as a variable-length integer.
+func encodeSize(size uint64) []byte {
+	length := 1
+	for length < 8 && size >= (uint64(1)<<(7*length))-1 {
+		length++
+	}
+	return encodeSizeWithLength(size, length)
+}
+
+// encodeSizeWithLength encodes an element data size as a variable-length integer of a fixed length.
+func encodeSizeWithLength(size uint64, length int) []byte {
+	buffer := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		buffer[i] = byte(size)
+		size >>= 8
+	}
+	buffer[0] |= 0x80 >> uint(length-1)
+	return buffer
+}
+
+// element creates a master or binary element.
+func element(id uint32, payloads ...[]byte) []byte {
+	size := 0
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+	buffer := new(bytes.Buffer)
+	buffer.Write(encodeID(id))
+	buffer.Write(encodeSize(uint64(size)))
+	for _, payload := range payloads {
+		buffer.Write(payload)
+	}
+	return buffer.Bytes()
+}
+
+// uintElement creates an unsigned integer element.
+func uintElement(id uint32, value uint64) []byte {
+	length := 1
+	for length < 8 && value >= uint64(1)<<(8*length) {
+		length++
+	}
+	return fixedUintElement(id, value, length)
+}
+
+// fixedUintElement creates an unsigned integer element with a fixed length.
+//
+// This is useful when the element must be written before its value is known.
+func fixedUintElement(id uint32, value uint64, length int) []byte {
+	buffer := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		buffer[i] = byte(value)
+		value >>= 8
+	}
+	return element(id, buffer)
+}
+
+// intElement creates a signed integer element.
+func intElement(id uint32, value int64) []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, value)
+	return element(id, buffer.Bytes())
+}
+
+// floatElement creates a floating-point element.
+func floatElement(id uint32, value float64) []byte {
+	buffer := make([]byte, 8)
+	binary.BigEndian.PutUint64(buffer, math.Float64bits(value))
+	return element(id, buffer)
+}
+
+// stringElement creates a string element.
+func stringElement(id uint32, value string) []byte {
+	return element(id, []byte(value))
+}
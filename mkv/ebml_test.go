@@ -0,0 +1,111 @@
+package mkv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// fromHex decodes hex test data, ignoring spaces.
+func fromHex(t *testing.T, value string) []byte {
+	data, err := hex.DecodeString(strings.ReplaceAll(value, " ", ""))
+	if err != nil {
+		t.Fatalf("Invalid test data %q: %v", value, err)
+	}
+	return data
+}
+
+func TestEncodeSize(t *testing.T) {
+	tests := []struct {
+		size     uint64
+		expected string
+	}{
+		{0, "80"},
+		{1, "81"},
+		{126, "fe"},
+		{127, "40 7f"}, // 0xff is reserved for an unknown size.
+		{16382, "7f fe"},
+		{16383, "20 3f ff"},
+		{1<<21 - 2, "3f ff fe"},
+		{1<<21 - 1, "10 1f ff ff"},
+		{1<<56 - 2, "01 ff ff ff ff ff ff fe"},
+	}
+	for _, test := range tests {
+		actual := encodeSize(test.size)
+		if !bytes.Equal(actual, fromHex(t, test.expected)) {
+			t.Errorf("encodeSize(%d): expected %s; got % x", test.size, test.expected, actual)
+		}
+	}
+
+	actual := encodeSizeWithLength(5, 8)
+	expected := fromHex(t, "01 00 00 00 00 00 00 05")
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("encodeSizeWithLength(5, 8): expected % x; got % x", expected, actual)
+	}
+}
+
+func TestEncodeID(t *testing.T) {
+	tests := []struct {
+		id       uint32
+		expected string
+	}{
+		{IDSimpleBlock, "a3"},
+		{IDEBMLVersion, "42 86"},
+		{IDTimestampScale, "2a d7 b1"},
+		{IDEBML, "1a 45 df a3"},
+	}
+	for _, test := range tests {
+		actual := encodeID(test.id)
+		if !bytes.Equal(actual, fromHex(t, test.expected)) {
+			t.Errorf("encodeID(0x%X): expected %s; got % x", test.id, test.expected, actual)
+		}
+	}
+}
+
+func TestElements(t *testing.T) {
+	tests := []struct {
+		name     string
+		actual   []byte
+		expected string
+	}{
+		{"uint zero", uintElement(IDTrackNumber, 0), "d7 81 00"},
+		{"uint", uintElement(IDTrackNumber, 1), "d7 81 01"},
+		{"uint 256", uintElement(IDTrackNumber, 256), "d7 82 01 00"},
+		{"uint timestamp scale", uintElement(IDTimestampScale, 1000000), "2a d7 b1 83 0f 42 40"},
+		{"fixed uint", fixedUintElement(IDSeekPosition, 1, 8), "53 ac 88 00 00 00 00 00 00 00 01"},
+		{"int", intElement(IDTimestamp, -1), "e7 88 ff ff ff ff ff ff ff ff"},
+		{"float", floatElement(IDDuration, 1000), "44 89 88 40 8f 40 00 00 00 00 00"},
+		{"string", stringElement(IDDocType, "matroska"), "42 82 88 6d 61 74 72 6f 73 6b 61"},
+		{"master", element(IDEBML, uintElement(IDEBMLVersion, 1)), "1a 45 df a3 84 42 86 81 01"},
+		{"master with several children", element(IDVideo, uintElement(IDPixelWidth, 640), uintElement(IDPixelHeight, 480)), "e0 88 b0 82 02 80 ba 82 01 e0"},
+		{"empty", element(IDCues), "1c 53 bb 6b 80"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := fromHex(t, test.expected)
+			if !bytes.Equal(test.actual, expected) {
+				t.Errorf("Expected % x; got % x", expected, test.actual)
+			}
+		})
+	}
+}
+
+func TestElementSizeBoundary(t *testing.T) {
+	// A 127-byte payload needs a 2-byte size.
+	actual := element(IDSimpleBlock, make([]byte, 127))
+	if len(actual) != 3+127 {
+		t.Fatalf("Expected %d bytes; got %d", 3+127, len(actual))
+	}
+	expected := fromHex(t, "a3 40 7f")
+	if !bytes.Equal(actual[0:3], expected) {
+		t.Errorf("Expected % x; got % x", expected, actual[0:3])
+	}
+
+	// A 126-byte payload still fits in a 1-byte size.
+	actual = element(IDSimpleBlock, make([]byte, 126))
+	expected = fromHex(t, "a3 fe")
+	if !bytes.Equal(actual[0:2], expected) {
+		t.Errorf("Expected % x; got % x", expected, actual[0:2])
+	}
+}
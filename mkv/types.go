@@ -0,0 +1,62 @@
+package mkv
+
+import "time"
+
+// These are the Matroska track types.
+const (
+	TrackTypeVideo    = 1
+	TrackTypeAudio    = 2
+	TrackTypeSubtitle = 0x11
+)
+
+// These are the codec IDs that we use.
+const (
+	CodecH264     = "V_MPEG4/ISO/AVC"
+	CodecOpus     = "A_OPUS"
+	CodecPCM      = "A_PCM/INT/LIT"
+	CodecTextUTF8 = "S_TEXT/UTF8"
+)
+
+// TimestampScale is the duration of one timestamp tick (1 ms).
+const TimestampScale = time.Millisecond
+
+// File is a Matroska file.
+type File struct {
+	Title        string
+	DateUTC      time.Time
+	MuxingApp    string
+	WritingApp   string
+	Tracks       []*Track
+	CueTrackType int // Cues are written for the keyframes of tracks of this type; defaults to video.
+}
+
+// Track is a single track in the file.
+type Track struct {
+	Type         int // One of the "TrackType" constants.
+	CodecID      string
+	CodecPrivate []byte
+	CodecDelay   time.Duration
+	SeekPreRoll  time.Duration
+	Name         string
+	Language     string
+	IsDefault    bool
+
+	// Used for video tracks.
+	PixelWidth  int
+	PixelHeight int
+
+	// Used for audio tracks.
+	SamplingFrequency float64
+	Channels          int
+	BitDepth          int
+
+	Blocks []Block
+}
+
+// Block is a single frame in a track.
+type Block struct {
+	Timestamp  time.Duration // Presentation time, relative to the start of the segment.
+	Duration   time.Duration // Only written if non-zero (for example, for subtitles).
+	IsKeyframe bool
+	Data       []byte
+}
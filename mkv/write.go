@@ -0,0 +1,268 @@
+package mkv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maximumClusterDuration is the longest that a cluster can be before we start a new one.
+//
+// Block timestamps are stored as signed 16-bit offsets from the cluster timestamp, so this must stay
+// well under 32767 ticks.
+const maximumClusterDuration = 5 * time.Second
+
+// matroskaEpoch is the reference time for the "DateUTC" element.
+var matroskaEpoch = time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// cuePoint is a position that a player can seek to.
+type cuePoint struct {
+	Time            uint64 // In timestamp ticks.
+	TrackNumber     int
+	ClusterPosition uint64 // Relative to the start of the segment data.
+}
+
+// Write writes a Matroska file.
+func Write(writer io.Writer, file *File) error {
+	ebmlHeader := element(IDEBML,
+		uintElement(IDEBMLVersion, 1),
+		uintElement(IDEBMLReadVersion, 1),
+		uintElement(IDEBMLMaxIDLength, 4),
+		uintElement(IDEBMLMaxSizeLength, 8),
+		stringElement(IDDocType, "matroska"),
+		uintElement(IDDocTypeVersion, 4),
+		uintElement(IDDocTypeReadVersion, 2),
+	)
+
+	var duration time.Duration
+	for _, track := range file.Tracks {
+		for _, block := range track.Blocks {
+			if block.Timestamp+block.Duration > duration {
+				duration = block.Timestamp + block.Duration
+			}
+		}
+	}
+
+	infoElements := [][]byte{
+		uintElement(IDTimestampScale, uint64(TimestampScale)),
+		floatElement(IDDuration, float64(duration)/float64(TimestampScale)),
+		stringElement(IDMuxingApp, file.MuxingApp),
+		stringElement(IDWritingApp, file.WritingApp),
+	}
+	if !file.DateUTC.IsZero() {
+		infoElements = append(infoElements, intElement(IDDateUTC, file.DateUTC.Sub(matroskaEpoch).Nanoseconds()))
+	}
+	if file.Title != "" {
+		infoElements = append(infoElements, stringElement(IDTitle, file.Title))
+	}
+	info := element(IDInfo, infoElements...)
+
+	trackEntries := [][]byte{}
+	for trackIndex, track := range file.Tracks {
+		trackEntries = append(trackEntries, makeTrackEntry(track, trackIndex+1))
+	}
+	tracks := element(IDTracks, trackEntries...)
+
+	// The seek head has a fixed size (we use 8-byte positions), so build it with
+	// placeholders first to figure out where everything else goes.
+	seekHead := makeSeekHead(0, 0, 0)
+
+	clusters, cuePoints, err := makeClusters(file, uint64(len(seekHead)+len(info)+len(tracks)))
+	if err != nil {
+		return err
+	}
+
+	cuePointElements := [][]byte{}
+	for _, point := range cuePoints {
+		cuePointElements = append(cuePointElements, element(IDCuePoint,
+			uintElement(IDCueTime, point.Time),
+			element(IDCueTrackPositions,
+				uintElement(IDCueTrack, uint64(point.TrackNumber)),
+				uintElement(IDCueClusterPosition, point.ClusterPosition),
+			),
+		))
+	}
+	cues := element(IDCues, cuePointElements...)
+
+	infoPosition := uint64(len(seekHead))
+	tracksPosition := infoPosition + uint64(len(info))
+	cuesPosition := tracksPosition + uint64(len(tracks)) + uint64(clusters.Len())
+	seekHead = makeSeekHead(infoPosition, tracksPosition, cuesPosition)
+
+	segmentSize := uint64(len(seekHead)+len(info)+len(tracks)+clusters.Len()) + uint64(len(cues))
+
+	for _, part := range [][]byte{
+		ebmlHeader,
+		encodeID(IDSegment),
+		encodeSizeWithLength(segmentSize, 8),
+		seekHead,
+		info,
+		tracks,
+		clusters.Bytes(),
+		cues,
+	} {
+		_, err = writer.Write(part)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func makeSeekHead(infoPosition uint64, tracksPosition uint64, cuesPosition uint64) []byte {
+	makeSeek := func(id uint32, position uint64) []byte {
+		return element(IDSeek,
+			element(IDSeekID, encodeID(id)),
+			fixedUintElement(IDSeekPosition, position, 8),
+		)
+	}
+	return element(IDSeekHead,
+		makeSeek(IDInfo, infoPosition),
+		makeSeek(IDTracks, tracksPosition),
+		makeSeek(IDCues, cuesPosition),
+	)
+}
+
+func makeTrackEntry(track *Track, trackNumber int) []byte {
+	elements := [][]byte{
+		uintElement(IDTrackNumber, uint64(trackNumber)),
+		uintElement(IDTrackUID, uint64(trackNumber)),
+		uintElement(IDTrackType, uint64(track.Type)),
+		uintElement(IDFlagLacing, 0),
+		stringElement(IDCodecID, track.CodecID),
+	}
+	if track.IsDefault {
+		elements = append(elements, uintElement(IDFlagDefault, 1))
+	} else {
+		elements = append(elements, uintElement(IDFlagDefault, 0))
+	}
+	if track.Name != "" {
+		elements = append(elements, stringElement(IDName, track.Name))
+	}
+	if track.Language != "" {
+		elements = append(elements, stringElement(IDLanguage, track.Language))
+	}
+	if len(track.CodecPrivate) > 0 {
+		elements = append(elements, element(IDCodecPrivate, track.CodecPrivate))
+	}
+	if track.CodecDelay > 0 {
+		elements = append(elements, uintElement(IDCodecDelay, uint64(track.CodecDelay)))
+	}
+	if track.SeekPreRoll > 0 {
+		elements = append(elements, uintElement(IDSeekPreRoll, uint64(track.SeekPreRoll)))
+	}
+	switch track.Type {
+	case TrackTypeVideo:
+		elements = append(elements, element(IDVideo,
+			uintElement(IDPixelWidth, uint64(track.PixelWidth)),
+			uintElement(IDPixelHeight, uint64(track.PixelHeight)),
+		))
+	case TrackTypeAudio:
+		audioElements := [][]byte{
+			floatElement(IDSamplingFrequency, track.SamplingFrequency),
+			uintElement(IDChannels, uint64(track.Channels)),
+		}
+		if track.BitDepth > 0 {
+			audioElements = append(audioElements, uintElement(IDBitDepth, uint64(track.BitDepth)))
+		}
+		elements = append(elements, element(IDAudio, audioElements...))
+	}
+	return element(IDTrackEntry, elements...)
+}
+
+// makeClusters interleaves the blocks from all of the tracks into clusters.
+//
+// Each track's blocks are kept in their original (decode) order; between tracks, the block
+// with the earliest timestamp goes first.
+//
+// Segment offset is the position of the first cluster relative to the start of the segment data.
+func makeClusters(file *File, segmentOffset uint64) (*bytes.Buffer, []cuePoint, error) {
+	cueTrackType := file.CueTrackType
+	if cueTrackType == 0 {
+		cueTrackType = TrackTypeVideo
+	}
+	// New clusters are started on the keyframes of the first cue track.
+	clusterTrackIndex := -1
+	for trackIndex, track := range file.Tracks {
+		if track.Type == cueTrackType {
+			clusterTrackIndex = trackIndex
+			break
+		}
+	}
+
+	clusters := new(bytes.Buffer)
+	cuePoints := []cuePoint{}
+
+	clusterContents := new(bytes.Buffer)
+	var clusterTimestamp uint64
+	clusterStarted := false
+	flushCluster := func() {
+		if !clusterStarted {
+			return
+		}
+		clusters.Write(element(IDCluster, uintElement(IDTimestamp, clusterTimestamp), clusterContents.Bytes()))
+		clusterContents.Reset()
+		clusterStarted = false
+	}
+
+	nextBlockIndexes := make([]int, len(file.Tracks))
+	for {
+		trackIndex := -1
+		for i, track := range file.Tracks {
+			if nextBlockIndexes[i] >= len(track.Blocks) {
+				continue
+			}
+			if trackIndex == -1 || track.Blocks[nextBlockIndexes[i]].Timestamp < file.Tracks[trackIndex].Blocks[nextBlockIndexes[trackIndex]].Timestamp {
+				trackIndex = i
+			}
+		}
+		if trackIndex == -1 {
+			break
+		}
+		track := file.Tracks[trackIndex]
+		block := track.Blocks[nextBlockIndexes[trackIndex]]
+		nextBlockIndexes[trackIndex]++
+
+		blockTime := uint64(block.Timestamp / TimestampScale)
+		if clusterStarted {
+			if trackIndex == clusterTrackIndex && block.IsKeyframe {
+				flushCluster()
+			} else if time.Duration(blockTime-clusterTimestamp)*TimestampScale >= maximumClusterDuration && blockTime >= clusterTimestamp {
+				flushCluster()
+			}
+		}
+		if !clusterStarted {
+			clusterStarted = true
+			clusterTimestamp = blockTime
+		}
+		if track.Type == cueTrackType && block.IsKeyframe {
+			cuePoints = append(cuePoints, cuePoint{
+				Time:            blockTime,
+				TrackNumber:     trackIndex + 1,
+				ClusterPosition: segmentOffset + uint64(clusters.Len()),
+			})
+		}
+
+		relativeTimestamp := int64(blockTime) - int64(clusterTimestamp)
+		if relativeTimestamp < -32768 || relativeTimestamp > 32767 {
+			return nil, nil, fmt.Errorf("block timestamp %v is too far from its cluster timestamp %d", block.Timestamp, clusterTimestamp)
+		}
+		blockHeader := []byte{0x80 | byte(trackIndex+1), byte(uint16(relativeTimestamp) >> 8), byte(uint16(relativeTimestamp))}
+		if block.Duration > 0 {
+			clusterContents.Write(element(IDBlockGroup,
+				element(IDBlock, blockHeader, []byte{0}, block.Data),
+				uintElement(IDBlockDuration, uint64(block.Duration/TimestampScale)),
+			))
+		} else {
+			var flags byte
+			if block.IsKeyframe {
+				flags |= 0x80
+			}
+			clusterContents.Write(element(IDSimpleBlock, blockHeader, []byte{flags}, block.Data))
+		}
+	}
+	flushCluster()
+
+	return clusters, cuePoints, nil
+}
@@ -0,0 +1,164 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The sample flags for the "trun" box; see ISO/IEC 14496-12, section 8.8.3.1.
+const (
+	sampleFlagsSync    = 0x02000000 // Doesn't depend on other samples.
+	sampleFlagsNonSync = 0x01010000 // Depends on other samples, and is not a sync sample.
+)
+
+// Fragment is a movie fragment: some of the samples of each track, for a fragmented MP4 file.
+//
+// A fragmented MP4 file is an initialization segment (see `WriteInitialization`) followed by fragments.
+type Fragment struct {
+	SequenceNumber uint32 // This starts at 1 and goes up by one for each fragment.
+	Tracks         []FragmentTrack
+}
+
+// FragmentTrack is the part of a track in a movie fragment.
+type FragmentTrack struct {
+	TrackIndex     int    // The index of the track in the file.
+	BaseDecodeTime uint64 // The decode time of the first sample, in the track's timescale.
+	Samples        []Sample
+}
+
+// WriteInitialization writes the initialization segment of a fragmented MP4 file.
+//
+// This describes the tracks, but has none of their samples; those go in the fragments.
+func WriteInitialization(writer io.Writer, file *File) error {
+	emptyFile := &File{
+		CreationTime: file.CreationTime,
+	}
+	extendsBoxes := [][]byte{}
+	for trackIndex, track := range file.Tracks {
+		if track.Timescale == 0 {
+			return fmt.Errorf("track %d has no timescale", trackIndex)
+		}
+		if len(track.SampleEntry) == 0 {
+			return fmt.Errorf("track %d has no sample entry", trackIndex)
+		}
+		// The start of each track comes from the decode time of its first fragment instead.
+		emptyTrack := *track
+		emptyTrack.StartOffset = 0
+		emptyTrack.Samples = nil
+		emptyFile.Tracks = append(emptyFile.Tracks, &emptyTrack)
+
+		trackExtends := new(bytes.Buffer)
+		binary.Write(trackExtends, binary.BigEndian, uint32(trackIndex+1)) // Track ID.
+		binary.Write(trackExtends, binary.BigEndian, uint32(1))            // Default sample description index.
+		binary.Write(trackExtends, binary.BigEndian, uint32(0))            // Default sample duration.
+		binary.Write(trackExtends, binary.BigEndian, uint32(0))            // Default sample size.
+		binary.Write(trackExtends, binary.BigEndian, uint32(0))            // Default sample flags.
+		extendsBoxes = append(extendsBoxes, makeFullBox("trex", 0, 0, trackExtends.Bytes()))
+	}
+
+	chunkOffsets := make([][]uint64, len(emptyFile.Tracks))
+	movieBox := makeMovieBox(emptyFile, chunkOffsets, makeBox("mvex", extendsBoxes...))
+
+	_, err := writer.Write(makeFragmentedFileTypeBox())
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(movieBox)
+	return err
+}
+
+// WriteFragment writes a movie fragment ("moof" and "mdat" boxes).
+func WriteFragment(writer io.Writer, fragment *Fragment) error {
+	tracks := []FragmentTrack{}
+	var mediaSize uint64
+	for _, track := range fragment.Tracks {
+		if len(track.Samples) == 0 {
+			continue
+		}
+		tracks = append(tracks, track)
+		for _, sample := range track.Samples {
+			mediaSize += uint64(len(sample.Data))
+		}
+	}
+	if mediaSize+8 > 0xffffffff {
+		return fmt.Errorf("the fragment is too big (%d bytes)", mediaSize)
+	}
+
+	// The size of the "moof" box doesn't depend on the data offsets, so build it once to get the size.
+	dataOffsets := make([]uint32, len(tracks))
+	movieFragmentBox := makeMovieFragmentBox(fragment.SequenceNumber, tracks, dataOffsets)
+	offset := uint32(len(movieFragmentBox)) + 8
+	for i, track := range tracks {
+		dataOffsets[i] = offset
+		for _, sample := range track.Samples {
+			offset += uint32(len(sample.Data))
+		}
+	}
+	movieFragmentBox = makeMovieFragmentBox(fragment.SequenceNumber, tracks, dataOffsets)
+
+	_, err := writer.Write(movieFragmentBox)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(writer, binary.BigEndian, uint32(mediaSize+8))
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write([]byte("mdat"))
+	if err != nil {
+		return err
+	}
+	for _, track := range tracks {
+		for _, sample := range track.Samples {
+			_, err = writer.Write(sample.Data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func makeFragmentedFileTypeBox() []byte {
+	buffer := new(bytes.Buffer)
+	buffer.WriteString("iso6")                        // Major brand.
+	binary.Write(buffer, binary.BigEndian, uint32(0)) // Minor version.
+	buffer.WriteString("iso6")
+	buffer.WriteString("iso5")
+	buffer.WriteString("mp41")
+	buffer.WriteString("avc1")
+	return makeBox("ftyp", buffer.Bytes())
+}
+
+// makeMovieFragmentBox creates the "moof" box; the data offsets are relative to the start of the box.
+func makeMovieFragmentBox(sequenceNumber uint32, tracks []FragmentTrack, dataOffsets []uint32) []byte {
+	boxes := [][]byte{makeFullBox("mfhd", 0, 0, uint32Bytes(sequenceNumber))}
+	for i, track := range tracks {
+		decodeTime := new(bytes.Buffer)
+		binary.Write(decodeTime, binary.BigEndian, track.BaseDecodeTime)
+
+		run := new(bytes.Buffer)
+		binary.Write(run, binary.BigEndian, uint32(len(track.Samples))) // Sample count.
+		binary.Write(run, binary.BigEndian, int32(dataOffsets[i]))      // Data offset.
+		for _, sample := range track.Samples {
+			flags := uint32(sampleFlagsNonSync)
+			if sample.IsSync {
+				flags = sampleFlagsSync
+			}
+			binary.Write(run, binary.BigEndian, sample.Duration)
+			binary.Write(run, binary.BigEndian, uint32(len(sample.Data)))
+			binary.Write(run, binary.BigEndian, flags)
+			binary.Write(run, binary.BigEndian, sample.CompositionOffset)
+		}
+
+		boxes = append(boxes, makeBox("traf",
+			makeFullBox("tfhd", 0, 0x020000, uint32Bytes(uint32(track.TrackIndex+1))), // The data offsets are relative to the "moof" box.
+			makeFullBox("tfdt", 1, 0, decodeTime.Bytes()),
+			// The data offset, and the duration, size, flags, and (signed) composition offset of every sample.
+			makeFullBox("trun", 1, 0x000f01, run.Bytes()),
+		))
+	}
+	return makeBox("moof", boxes...)
+}
@@ -0,0 +1,96 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// These are the track handler types.
+const (
+	HandlerVideo = "vide"
+	HandlerAudio = "soun"
+	HandlerText  = "text"
+)
+
+// DefaultLanguage is the ISO 639-2 language code for "undetermined".
+const DefaultLanguage = "und"
+
+// File is an ISO base media (MP4) file.
+type File struct {
+	CreationTime time.Time
+	Tracks       []*Track
+}
+
+// Track is a single track in the file.
+type Track struct {
+	Handler     string // One of the "Handler" constants.
+	Name        string
+	Language    string
+	Timescale   uint32 // Units per second for the sample durations.
+	Width       int    // Used for video tracks.
+	Height      int    // Used for video tracks.
+	StartOffset uint64 // Empty time (in the track's timescale) before the first sample.
+	SampleEntry []byte // The sample entry box for the "stsd" box; see `AVCSampleEntry` and `OpusSampleEntry`.
+	Samples     []Sample
+}
+
+// Sample is a single sample (frame) in a track.
+type Sample struct {
+	Data              []byte
+	Duration          uint32 // In the track's timescale.
+	CompositionOffset int32  // Presentation time minus decode time, in the track's timescale.
+	IsSync            bool
+}
+
+// Duration returns the total duration of the track, in the track's timescale.
+func (t *Track) Duration() uint64 {
+	var duration uint64
+	for _, sample := range t.Samples {
+		duration += uint64(sample.Duration)
+	}
+	return duration
+}
+
+// AVCSampleEntry returns an "avc1" sample entry for the given AVC decoder configuration record.
+func AVCSampleEntry(width int, height int, decoderConfigurationRecord []byte) []byte {
+	buffer := new(bytes.Buffer)
+	buffer.Write(make([]byte, 6))                              // Reserved.
+	binary.Write(buffer, binary.BigEndian, uint16(1))          // Data reference index.
+	buffer.Write(make([]byte, 16))                             // Pre-defined and reserved.
+	binary.Write(buffer, binary.BigEndian, uint16(width))      // Width.
+	binary.Write(buffer, binary.BigEndian, uint16(height))     // Height.
+	binary.Write(buffer, binary.BigEndian, uint32(0x00480000)) // Horizontal resolution (72 dpi).
+	binary.Write(buffer, binary.BigEndian, uint32(0x00480000)) // Vertical resolution (72 dpi).
+	binary.Write(buffer, binary.BigEndian, uint32(0))          // Reserved.
+	binary.Write(buffer, binary.BigEndian, uint16(1))          // Frame count.
+	buffer.Write(make([]byte, 32))                             // Compressor name.
+	binary.Write(buffer, binary.BigEndian, uint16(0x0018))     // Depth.
+	binary.Write(buffer, binary.BigEndian, int16(-1))          // Pre-defined.
+	buffer.Write(makeBox("avcC", decoderConfigurationRecord))  // AVC configuration.
+	return makeBox("avc1", buffer.Bytes())
+}
+
+// OpusSampleEntry returns an "Opus" sample entry; see "Encapsulation of Opus in ISO Base Media File Format".
+func OpusSampleEntry(channelCount int, preSkip int, inputSampleRate int) []byte {
+	buffer := new(bytes.Buffer)
+	buffer.Write(make([]byte, 6))                     // Reserved.
+	binary.Write(buffer, binary.BigEndian, uint16(1)) // Data reference index.
+	buffer.Write(make([]byte, 8))                     // Reserved.
+	binary.Write(buffer, binary.BigEndian, uint16(channelCount))
+	binary.Write(buffer, binary.BigEndian, uint16(16))        // Sample size.
+	binary.Write(buffer, binary.BigEndian, uint16(0))         // Pre-defined.
+	binary.Write(buffer, binary.BigEndian, uint16(0))         // Reserved.
+	binary.Write(buffer, binary.BigEndian, uint32(48000<<16)) // Sample rate (16.16).
+
+	opusSpecific := new(bytes.Buffer)
+	opusSpecific.WriteByte(0) // Version.
+	opusSpecific.WriteByte(byte(channelCount))
+	binary.Write(opusSpecific, binary.BigEndian, uint16(preSkip))
+	binary.Write(opusSpecific, binary.BigEndian, uint32(inputSampleRate))
+	binary.Write(opusSpecific, binary.BigEndian, int16(0)) // Output gain.
+	opusSpecific.WriteByte(0)                              // Channel mapping family.
+	buffer.Write(makeBox("dOps", opusSpecific.Bytes()))
+
+	return makeBox("Opus", buffer.Bytes())
+}
@@ -0,0 +1,379 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// movieTimescale is the timescale for the movie header (milliseconds).
+const movieTimescale = 1000
+
+// epochOffset is the number of seconds between 1904-01-01 (the MP4 epoch) and 1970-01-01.
+const epochOffset = 2082844800
+
+// interleavedSample is a reference to a sample in a track, along with its decode time.
+type interleavedSample struct {
+	TrackIndex  int
+	SampleIndex int
+	DecodeTime  uint64 // In the track's timescale.
+	Timescale   uint32
+}
+
+// Write writes an MP4 file.
+//
+// The "moov" box is written before the "mdat" box so that the file can be played
+// while it is still being downloaded.
+func Write(writer io.Writer, file *File) error {
+	for trackIndex, track := range file.Tracks {
+		if track.Timescale == 0 {
+			return fmt.Errorf("track %d has no timescale", trackIndex)
+		}
+		if len(track.SampleEntry) == 0 {
+			return fmt.Errorf("track %d has no sample entry", trackIndex)
+		}
+	}
+
+	// Interleave the samples by their decode time.
+	interleavedSamples := []interleavedSample{}
+	for trackIndex, track := range file.Tracks {
+		decodeTime := track.StartOffset
+		for sampleIndex, sample := range track.Samples {
+			interleavedSamples = append(interleavedSamples, interleavedSample{
+				TrackIndex:  trackIndex,
+				SampleIndex: sampleIndex,
+				DecodeTime:  decodeTime,
+				Timescale:   track.Timescale,
+			})
+			decodeTime += uint64(sample.Duration)
+		}
+	}
+	sort.SliceStable(interleavedSamples, func(i, j int) bool {
+		a := interleavedSamples[i]
+		b := interleavedSamples[j]
+		return a.DecodeTime*uint64(b.Timescale) < b.DecodeTime*uint64(a.Timescale)
+	})
+
+	var mediaSize uint64
+	for _, sample := range interleavedSamples {
+		mediaSize += uint64(len(file.Tracks[sample.TrackIndex].Samples[sample.SampleIndex].Data))
+	}
+	mediaHeaderSize := uint64(8)
+	if mediaSize+8 > 0xffffffff {
+		mediaHeaderSize = 16
+	}
+
+	fileTypeBox := makeFileTypeBox()
+
+	// Figure out the chunk offsets.  The size of the "moov" box doesn't depend on the
+	// offset values (we always use 64-bit offsets), so build it once to get the size.
+	chunkOffsets := make([][]uint64, len(file.Tracks))
+	for trackIndex, track := range file.Tracks {
+		chunkOffsets[trackIndex] = make([]uint64, len(track.Samples))
+	}
+	movieBox := makeMovieBox(file, chunkOffsets)
+
+	offset := uint64(len(fileTypeBox)) + uint64(len(movieBox)) + mediaHeaderSize
+	for _, sample := range interleavedSamples {
+		chunkOffsets[sample.TrackIndex][sample.SampleIndex] = offset
+		offset += uint64(len(file.Tracks[sample.TrackIndex].Samples[sample.SampleIndex].Data))
+	}
+	movieBox = makeMovieBox(file, chunkOffsets)
+
+	_, err := writer.Write(fileTypeBox)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(movieBox)
+	if err != nil {
+		return err
+	}
+
+	if mediaHeaderSize == 16 {
+		err = binary.Write(writer, binary.BigEndian, uint32(1))
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte("mdat"))
+		if err != nil {
+			return err
+		}
+		err = binary.Write(writer, binary.BigEndian, mediaSize+mediaHeaderSize)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = binary.Write(writer, binary.BigEndian, uint32(mediaSize+mediaHeaderSize))
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write([]byte("mdat"))
+		if err != nil {
+			return err
+		}
+	}
+	for _, sample := range interleavedSamples {
+		_, err = writer.Write(file.Tracks[sample.TrackIndex].Samples[sample.SampleIndex].Data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// makeBox creates a box.
+//
+// A box looks like: Size Type Data
+func makeBox(boxType string, payloads ...[]byte) []byte {
+	size := 8
+	for _, payload := range payloads {
+		size += len(payload)
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, size))
+	binary.Write(buffer, binary.BigEndian, uint32(size))
+	buffer.WriteString(boxType)
+	for _, payload := range payloads {
+		buffer.Write(payload)
+	}
+	return buffer.Bytes()
+}
+
+// makeFullBox creates a "full" box, which has a version and flags.
+func makeFullBox(boxType string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return makeBox(boxType, append([][]byte{header}, payloads...)...)
+}
+
+func makeFileTypeBox() []byte {
+	buffer := new(bytes.Buffer)
+	buffer.WriteString("isom")                          // Major brand.
+	binary.Write(buffer, binary.BigEndian, uint32(512)) // Minor version.
+	buffer.WriteString("isom")
+	buffer.WriteString("iso2")
+	buffer.WriteString("avc1")
+	buffer.WriteString("mp41")
+	return makeBox("ftyp", buffer.Bytes())
+}
+
+// unityMatrix is the identity transformation matrix.
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// toMovieTime converts a track time into the movie timescale.
+func toMovieTime(value uint64, timescale uint32) uint64 {
+	return value * movieTimescale / uint64(timescale)
+}
+
+// makeMovieBox creates the "moov" box; the extra boxes (such as "mvex") are added at the end.
+func makeMovieBox(file *File, chunkOffsets [][]uint64, extraBoxes ...[]byte) []byte {
+	creationTime := uint64(0)
+	if !file.CreationTime.IsZero() {
+		creationTime = uint64(file.CreationTime.Unix() + epochOffset)
+	}
+
+	var movieDuration uint64
+	for _, track := range file.Tracks {
+		duration := toMovieTime(track.StartOffset+track.Duration(), track.Timescale)
+		if duration > movieDuration {
+			movieDuration = duration
+		}
+	}
+
+	movieHeader := new(bytes.Buffer)
+	binary.Write(movieHeader, binary.BigEndian, creationTime)               // Creation time.
+	binary.Write(movieHeader, binary.BigEndian, creationTime)               // Modification time.
+	binary.Write(movieHeader, binary.BigEndian, uint32(movieTimescale))     // Timescale.
+	binary.Write(movieHeader, binary.BigEndian, movieDuration)              // Duration.
+	binary.Write(movieHeader, binary.BigEndian, uint32(0x00010000))         // Rate.
+	binary.Write(movieHeader, binary.BigEndian, uint16(0x0100))             // Volume.
+	movieHeader.Write(make([]byte, 10))                                     // Reserved.
+	binary.Write(movieHeader, binary.BigEndian, unityMatrix)                // Matrix.
+	movieHeader.Write(make([]byte, 24))                                     // Pre-defined.
+	binary.Write(movieHeader, binary.BigEndian, uint32(len(file.Tracks)+1)) // Next track ID.
+
+	boxes := [][]byte{makeFullBox("mvhd", 1, 0, movieHeader.Bytes())}
+	for trackIndex, track := range file.Tracks {
+		boxes = append(boxes, makeTrackBox(track, trackIndex+1, creationTime, chunkOffsets[trackIndex]))
+	}
+	boxes = append(boxes, extraBoxes...)
+	return makeBox("moov", boxes...)
+}
+
+func makeTrackBox(track *Track, trackID int, creationTime uint64, chunkOffsets []uint64) []byte {
+	mediaDuration := track.Duration()
+
+	trackHeader := new(bytes.Buffer)
+	binary.Write(trackHeader, binary.BigEndian, creationTime)                                                  // Creation time.
+	binary.Write(trackHeader, binary.BigEndian, creationTime)                                                  // Modification time.
+	binary.Write(trackHeader, binary.BigEndian, uint32(trackID))                                               // Track ID.
+	binary.Write(trackHeader, binary.BigEndian, uint32(0))                                                     // Reserved.
+	binary.Write(trackHeader, binary.BigEndian, toMovieTime(track.StartOffset+mediaDuration, track.Timescale)) // Duration.
+	trackHeader.Write(make([]byte, 8))                                                                         // Reserved.
+	binary.Write(trackHeader, binary.BigEndian, int16(0))                                                      // Layer.
+	binary.Write(trackHeader, binary.BigEndian, int16(0))                                                      // Alternate group.
+	if track.Handler == HandlerAudio {
+		binary.Write(trackHeader, binary.BigEndian, uint16(0x0100)) // Volume.
+	} else {
+		binary.Write(trackHeader, binary.BigEndian, uint16(0)) // Volume.
+	}
+	binary.Write(trackHeader, binary.BigEndian, uint16(0))                // Reserved.
+	binary.Write(trackHeader, binary.BigEndian, unityMatrix)              // Matrix.
+	binary.Write(trackHeader, binary.BigEndian, uint32(track.Width<<16))  // Width.
+	binary.Write(trackHeader, binary.BigEndian, uint32(track.Height<<16)) // Height.
+
+	boxes := [][]byte{makeFullBox("tkhd", 1, 0x000003, trackHeader.Bytes())} // Enabled and in the movie.
+
+	if track.StartOffset > 0 {
+		editList := new(bytes.Buffer)
+		binary.Write(editList, binary.BigEndian, uint32(2))                                       // Entry count.
+		binary.Write(editList, binary.BigEndian, toMovieTime(track.StartOffset, track.Timescale)) // Segment duration.
+		binary.Write(editList, binary.BigEndian, int64(-1))                                       // Media time (empty edit).
+		binary.Write(editList, binary.BigEndian, uint32(0x00010000))                              // Rate.
+		binary.Write(editList, binary.BigEndian, toMovieTime(mediaDuration, track.Timescale))     // Segment duration.
+		binary.Write(editList, binary.BigEndian, int64(0))                                        // Media time.
+		binary.Write(editList, binary.BigEndian, uint32(0x00010000))                              // Rate.
+		boxes = append(boxes, makeBox("edts", makeFullBox("elst", 1, 0, editList.Bytes())))
+	}
+
+	language := track.Language
+	if len(language) != 3 {
+		language = DefaultLanguage
+	}
+	packedLanguage := uint16(language[0]-0x60)<<10 | uint16(language[1]-0x60)<<5 | uint16(language[2]-0x60)
+
+	mediaHeader := new(bytes.Buffer)
+	binary.Write(mediaHeader, binary.BigEndian, creationTime)    // Creation time.
+	binary.Write(mediaHeader, binary.BigEndian, creationTime)    // Modification time.
+	binary.Write(mediaHeader, binary.BigEndian, track.Timescale) // Timescale.
+	binary.Write(mediaHeader, binary.BigEndian, mediaDuration)   // Duration.
+	binary.Write(mediaHeader, binary.BigEndian, packedLanguage)  // Language.
+	binary.Write(mediaHeader, binary.BigEndian, uint16(0))       // Pre-defined.
+
+	handler := new(bytes.Buffer)
+	binary.Write(handler, binary.BigEndian, uint32(0)) // Pre-defined.
+	handler.WriteString(track.Handler)
+	handler.Write(make([]byte, 12)) // Reserved.
+	handler.WriteString(track.Name)
+	handler.WriteByte(0)
+
+	var mediaInformationHeader []byte
+	switch track.Handler {
+	case HandlerVideo:
+		mediaInformationHeader = makeFullBox("vmhd", 0, 1, make([]byte, 8))
+	case HandlerAudio:
+		mediaInformationHeader = makeFullBox("smhd", 0, 0, make([]byte, 4))
+	default:
+		mediaInformationHeader = makeFullBox("nmhd", 0, 0)
+	}
+
+	dataReference := makeFullBox("dref", 0, 0, []byte{0, 0, 0, 1}, makeFullBox("url ", 0, 1))
+
+	mediaInformation := makeBox("minf",
+		mediaInformationHeader,
+		makeBox("dinf", dataReference),
+		makeSampleTableBox(track, chunkOffsets),
+	)
+	media := makeBox("mdia",
+		makeFullBox("mdhd", 1, 0, mediaHeader.Bytes()),
+		makeFullBox("hdlr", 0, 0, handler.Bytes()),
+		mediaInformation,
+	)
+	boxes = append(boxes, media)
+
+	return makeBox("trak", boxes...)
+}
+
+func makeSampleTableBox(track *Track, chunkOffsets []uint64) []byte {
+	boxes := [][]byte{}
+
+	// Sample descriptions.
+	boxes = append(boxes, makeFullBox("stsd", 0, 0, []byte{0, 0, 0, 1}, track.SampleEntry))
+
+	// Decode times.
+	{
+		entries := new(bytes.Buffer)
+		entryCount := 0
+		for i := 0; i < len(track.Samples); {
+			j := i
+			for j < len(track.Samples) && track.Samples[j].Duration == track.Samples[i].Duration {
+				j++
+			}
+			binary.Write(entries, binary.BigEndian, uint32(j-i))
+			binary.Write(entries, binary.BigEndian, track.Samples[i].Duration)
+			entryCount++
+			i = j
+		}
+		boxes = append(boxes, makeFullBox("stts", 0, 0, uint32Bytes(uint32(entryCount)), entries.Bytes()))
+	}
+
+	// Composition offsets; only needed if the presentation order differs from the decode order.
+	{
+		needed := false
+		version := uint8(0)
+		for _, sample := range track.Samples {
+			if sample.CompositionOffset != 0 {
+				needed = true
+			}
+			if sample.CompositionOffset < 0 {
+				version = 1
+			}
+		}
+		if needed {
+			entries := new(bytes.Buffer)
+			entryCount := 0
+			for i := 0; i < len(track.Samples); {
+				j := i
+				for j < len(track.Samples) && track.Samples[j].CompositionOffset == track.Samples[i].CompositionOffset {
+					j++
+				}
+				binary.Write(entries, binary.BigEndian, uint32(j-i))
+				binary.Write(entries, binary.BigEndian, track.Samples[i].CompositionOffset)
+				entryCount++
+				i = j
+			}
+			boxes = append(boxes, makeFullBox("ctts", version, 0, uint32Bytes(uint32(entryCount)), entries.Bytes()))
+		}
+	}
+
+	// Sync samples; if every sample is a sync sample, then this box is omitted.
+	{
+		syncSamples := []uint32{}
+		for i, sample := range track.Samples {
+			if sample.IsSync {
+				syncSamples = append(syncSamples, uint32(i+1))
+			}
+		}
+		if len(syncSamples) != len(track.Samples) {
+			entries := new(bytes.Buffer)
+			binary.Write(entries, binary.BigEndian, syncSamples)
+			boxes = append(boxes, makeFullBox("stss", 0, 0, uint32Bytes(uint32(len(syncSamples))), entries.Bytes()))
+		}
+	}
+
+	// Sample-to-chunk; every sample is its own chunk.
+	boxes = append(boxes, makeFullBox("stsc", 0, 0, uint32Bytes(1), uint32Bytes(1), uint32Bytes(1), uint32Bytes(1)))
+
+	// Sample sizes.
+	{
+		entries := new(bytes.Buffer)
+		for _, sample := range track.Samples {
+			binary.Write(entries, binary.BigEndian, uint32(len(sample.Data)))
+		}
+		boxes = append(boxes, makeFullBox("stsz", 0, 0, uint32Bytes(0), uint32Bytes(uint32(len(track.Samples))), entries.Bytes()))
+	}
+
+	// Chunk offsets.
+	{
+		entries := new(bytes.Buffer)
+		binary.Write(entries, binary.BigEndian, chunkOffsets)
+		boxes = append(boxes, makeFullBox("co64", 0, 0, uint32Bytes(uint32(len(chunkOffsets))), entries.Bytes()))
+	}
+
+	return makeBox("stbl", boxes...)
+}
+
+func uint32Bytes(value uint32) []byte {
+	return []byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}
+}
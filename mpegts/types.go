@@ -0,0 +1,77 @@
+package mpegts
+
+// These are the stream types that we use in the program map table.
+const (
+	StreamTypeH264    byte = 0x1B
+	StreamTypePrivate byte = 0x06 // Used for Opus.
+)
+
+// These are the PES stream IDs that we use.
+const (
+	StreamIDVideo   byte = 0xE0
+	StreamIDPrivate byte = 0xBD // "private_stream_1"; used for Opus.
+)
+
+// These are the fixed PIDs that we use.
+const (
+	PIDProgramAssociationTable uint16 = 0x0000
+	PIDProgramMapTable         uint16 = 0x1000
+	PIDFirstElementaryStream   uint16 = 0x0100
+)
+
+// ClockRate is the rate of the PTS/DTS clock.
+const ClockRate = 90000
+
+// InitialTimestamp is the timestamp that the first packet should use.
+//
+// Starting a bit after zero gives the decoder some room between the PCR and the DTS.
+const InitialTimestamp = 126000
+
+// PCRDelay is how far ahead of the PCR each decode timestamp is.
+const PCRDelay = 63000
+
+// File is an MPEG transport stream.
+type File struct {
+	Streams []Stream
+	Packets []Packet // In the order that they should be written.
+}
+
+// Stream is a single elementary stream in the program.
+type Stream struct {
+	PID         uint16
+	StreamType  byte   // One of the "StreamType" constants.
+	StreamID    byte   // One of the "StreamID" constants.
+	Descriptors []byte // The elementary stream descriptors for the program map table.
+	IsPCR       bool   // If true, then this stream carries the program clock reference.
+}
+
+// Packet is a single access unit (frame) for a stream.
+type Packet struct {
+	StreamIndex int
+	PTS         uint64 // In 90 kHz units.
+	DTS         uint64 // In 90 kHz units; only written if it differs from the PTS.
+	IsKeyframe  bool
+	Data        []byte
+}
+
+// OpusDescriptors returns the elementary stream descriptors for an Opus stream.
+//
+// See ETSI TS 102 366 / "Opus in MPEG-2 TS".
+func OpusDescriptors(channelCount int) []byte {
+	return []byte{
+		0x05, 0x04, 'O', 'p', 'u', 's', // Registration descriptor.
+		0x7f, 0x02, 0x80, byte(channelCount), // Extension descriptor with the channel configuration.
+	}
+}
+
+// OpusAccessUnit wraps an Opus packet in the control header that is required in a transport stream.
+func OpusAccessUnit(packet []byte) []byte {
+	data := []byte{0x7f, 0xe0}
+	size := len(packet)
+	for size >= 255 {
+		data = append(data, 0xff)
+		size -= 255
+	}
+	data = append(data, byte(size))
+	return append(data, packet...)
+}
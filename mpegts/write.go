@@ -0,0 +1,301 @@
+package mpegts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// PacketSize is the size of a transport stream packet.
+const PacketSize = 188
+
+// syncByte starts every transport stream packet.
+const syncByte = 0x47
+
+// Write writes an MPEG transport stream.
+//
+// The program tables are written at the start of the stream and again before every keyframe
+// on the PCR stream so that a player can join at any keyframe.
+func Write(writer io.Writer, file *File) error {
+	tsWriter := NewWriter(writer, file.Streams)
+	err := tsWriter.WriteTables()
+	if err != nil {
+		return err
+	}
+	for packetIndex, packet := range file.Packets {
+		if packetIndex > 0 && packet.IsKeyframe && file.Streams[packet.StreamIndex].IsPCR {
+			err = tsWriter.WriteTables()
+			if err != nil {
+				return err
+			}
+		}
+		err = tsWriter.WritePacket(packet)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writer writes transport stream packets, keeping track of the continuity counters.
+type Writer struct {
+	writer             io.Writer
+	streams            []Stream
+	continuityCounters map[uint16]byte
+}
+
+// NewWriter creates a new `Writer` for the given streams.
+func NewWriter(writer io.Writer, streams []Stream) *Writer {
+	return &Writer{
+		writer:             writer,
+		streams:            streams,
+		continuityCounters: map[uint16]byte{},
+	}
+}
+
+// SetOutput changes the underlying writer while keeping the continuity counters.
+//
+// This is useful for splitting a single stream into multiple segments.
+func (w *Writer) SetOutput(writer io.Writer) {
+	w.writer = writer
+}
+
+// WriteTables writes the program association table and the program map table.
+func (w *Writer) WriteTables() error {
+	// Program association table.
+	{
+		section := new(bytes.Buffer)
+		binary.Write(section, binary.BigEndian, uint16(1))                         // Transport stream ID.
+		section.WriteByte(0xc1)                                                    // Version 0, current.
+		section.WriteByte(0)                                                       // Section number.
+		section.WriteByte(0)                                                       // Last section number.
+		binary.Write(section, binary.BigEndian, uint16(1))                         // Program number.
+		binary.Write(section, binary.BigEndian, uint16(0xe000|PIDProgramMapTable)) // Program map PID.
+		err := w.writeSection(PIDProgramAssociationTable, 0x00, section.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	// Program map table.
+	{
+		pcrPID := uint16(0x1fff)
+		for _, stream := range w.streams {
+			if stream.IsPCR {
+				pcrPID = stream.PID
+			}
+		}
+
+		section := new(bytes.Buffer)
+		binary.Write(section, binary.BigEndian, uint16(1))             // Program number.
+		section.WriteByte(0xc1)                                        // Version 0, current.
+		section.WriteByte(0)                                           // Section number.
+		section.WriteByte(0)                                           // Last section number.
+		binary.Write(section, binary.BigEndian, uint16(0xe000|pcrPID)) // PCR PID.
+		binary.Write(section, binary.BigEndian, uint16(0xf000))        // Program info length.
+		for _, stream := range w.streams {
+			section.WriteByte(stream.StreamType)
+			binary.Write(section, binary.BigEndian, uint16(0xe000|stream.PID))
+			binary.Write(section, binary.BigEndian, uint16(0xf000|len(stream.Descriptors)))
+			section.Write(stream.Descriptors)
+		}
+		err := w.writeSection(PIDProgramMapTable, 0x02, section.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSection writes a single PSI section in its own transport stream packet.
+func (w *Writer) writeSection(pid uint16, tableID byte, body []byte) error {
+	section := new(bytes.Buffer)
+	section.WriteByte(tableID)
+	binary.Write(section, binary.BigEndian, uint16(0xb000|(len(body)+4))) // Section syntax indicator, and the length (including the CRC).
+	section.Write(body)
+	binary.Write(section, binary.BigEndian, crc32MPEG2(section.Bytes()))
+
+	payload := append([]byte{0}, section.Bytes()...) // Pointer field.
+	if len(payload) > PacketSize-4 {
+		return fmt.Errorf("table 0x%02x is too large: %d bytes", tableID, len(payload))
+	}
+
+	packet := make([]byte, PacketSize)
+	packet[0] = syncByte
+	packet[1] = 0x40 | byte(pid>>8) // Payload unit start indicator.
+	packet[2] = byte(pid)
+	packet[3] = 0x10 | w.nextContinuityCounter(pid) // Payload only.
+	copy(packet[4:], payload)
+	for i := 4 + len(payload); i < PacketSize; i++ {
+		packet[i] = 0xff
+	}
+	_, err := w.writer.Write(packet)
+	return err
+}
+
+// WritePacket writes a single access unit as a PES packet.
+func (w *Writer) WritePacket(packet Packet) error {
+	if packet.StreamIndex < 0 || packet.StreamIndex >= len(w.streams) {
+		return fmt.Errorf("invalid stream index: %d", packet.StreamIndex)
+	}
+	stream := w.streams[packet.StreamIndex]
+
+	pes := makePES(stream.StreamID, packet)
+
+	var pcr *uint64
+	if stream.IsPCR {
+		value := uint64(0)
+		if packet.DTS > PCRDelay {
+			value = packet.DTS - PCRDelay
+		}
+		pcr = &value
+	}
+
+	for first := true; first || len(pes) > 0; first = false {
+		adaptationField := []byte{}
+		hasAdaptationField := false
+		if first && (pcr != nil || packet.IsKeyframe) {
+			hasAdaptationField = true
+			var flags byte
+			if packet.IsKeyframe {
+				flags |= 0x40 // Random access indicator.
+			}
+			if pcr != nil {
+				flags |= 0x10 // PCR flag.
+			}
+			adaptationField = append(adaptationField, flags)
+			if pcr != nil {
+				base := *pcr
+				adaptationField = append(adaptationField,
+					byte(base>>25),
+					byte(base>>17),
+					byte(base>>9),
+					byte(base>>1),
+					byte(base&1)<<7|0x7e, // The extension is always 0.
+					0,
+				)
+			}
+		}
+
+		space := PacketSize - 4
+		if hasAdaptationField {
+			space -= 1 + len(adaptationField)
+		}
+		if len(pes) < space {
+			// Fill the rest of the packet with stuffing bytes in the adaptation field.
+			if !hasAdaptationField {
+				hasAdaptationField = true
+				stuffing := PacketSize - 4 - len(pes) - 1
+				if stuffing > 0 {
+					adaptationField = append(adaptationField, 0)
+					stuffing--
+				}
+				for ; stuffing > 0; stuffing-- {
+					adaptationField = append(adaptationField, 0xff)
+				}
+			} else {
+				for stuffing := space - len(pes); stuffing > 0; stuffing-- {
+					adaptationField = append(adaptationField, 0xff)
+				}
+			}
+			space = len(pes)
+		}
+
+		header := make([]byte, 4, PacketSize)
+		header[0] = syncByte
+		header[1] = byte(stream.PID >> 8)
+		if first {
+			header[1] |= 0x40 // Payload unit start indicator.
+		}
+		header[2] = byte(stream.PID)
+		if hasAdaptationField {
+			header[3] = 0x30 // Adaptation field and payload.
+		} else {
+			header[3] = 0x10 // Payload only.
+		}
+		header[3] |= w.nextContinuityCounter(stream.PID)
+
+		tsPacket := header
+		if hasAdaptationField {
+			tsPacket = append(tsPacket, byte(len(adaptationField)))
+			tsPacket = append(tsPacket, adaptationField...)
+		}
+		tsPacket = append(tsPacket, pes[0:space]...)
+		pes = pes[space:]
+
+		if len(tsPacket) != PacketSize {
+			return fmt.Errorf("internal error: transport stream packet is %d bytes", len(tsPacket))
+		}
+		_, err := w.writer.Write(tsPacket)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) nextContinuityCounter(pid uint16) byte {
+	value := w.continuityCounters[pid]
+	w.continuityCounters[pid] = (value + 1) & 0x0f
+	return value
+}
+
+// makePES creates a PES packet for the given access unit.
+func makePES(streamID byte, packet Packet) []byte {
+	hasDTS := packet.DTS != packet.PTS
+
+	header := new(bytes.Buffer)
+	header.Write([]byte{0, 0, 1, streamID})
+
+	optionalHeader := new(bytes.Buffer)
+	optionalHeader.WriteByte(0x84) // Marker bits and the data alignment indicator.
+	if hasDTS {
+		optionalHeader.WriteByte(0xc0)
+		optionalHeader.WriteByte(10)
+		optionalHeader.Write(encodeTimestamp(0x3, packet.PTS))
+		optionalHeader.Write(encodeTimestamp(0x1, packet.DTS))
+	} else {
+		optionalHeader.WriteByte(0x80)
+		optionalHeader.WriteByte(5)
+		optionalHeader.Write(encodeTimestamp(0x2, packet.PTS))
+	}
+
+	length := optionalHeader.Len() + len(packet.Data)
+	if length > 0xffff {
+		// This is only allowed for video streams.
+		length = 0
+	}
+	binary.Write(header, binary.BigEndian, uint16(length))
+	header.Write(optionalHeader.Bytes())
+	header.Write(packet.Data)
+	return header.Bytes()
+}
+
+// encodeTimestamp encodes a 33-bit PTS or DTS value.
+func encodeTimestamp(prefix byte, value uint64) []byte {
+	return []byte{
+		prefix<<4 | byte(value>>29)&0x0e | 1,
+		byte(value >> 22),
+		byte(value>>14)&0xfe | 1,
+		byte(value >> 7),
+		byte(value<<1)&0xfe | 1,
+	}
+}
+
+// crc32MPEG2 computes the CRC used by the MPEG-2 program tables.
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,194 @@
+package mpegts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// fromHex decodes hex test data, ignoring spaces.
+func fromHex(t *testing.T, value string) []byte {
+	data, err := hex.DecodeString(strings.ReplaceAll(value, " ", ""))
+	if err != nil {
+		t.Fatalf("Invalid test data %q: %v", value, err)
+	}
+	return data
+}
+
+func TestEncodeTimestamp(t *testing.T) {
+	tests := []struct {
+		prefix   byte
+		value    uint64
+		expected string
+	}{
+		{0x2, 0, "21 00 01 00 01"},
+		{0x2, InitialTimestamp, "21 00 07 d8 61"},
+		{0x3, 132000, "31 00 09 07 41"},
+		{0x1, InitialTimestamp, "11 00 07 d8 61"},
+		{0x2, 1<<33 - 1, "2f ff ff ff ff"}, // The largest 33-bit value.
+	}
+	for _, test := range tests {
+		actual := encodeTimestamp(test.prefix, test.value)
+		if !bytes.Equal(actual, fromHex(t, test.expected)) {
+			t.Errorf("encodeTimestamp(%d, %d): expected %s; got % x", test.prefix, test.value, test.expected, actual)
+		}
+	}
+}
+
+func TestMakePES(t *testing.T) {
+	// Without a DTS.
+	actual := makePES(StreamIDVideo, Packet{PTS: 126000, DTS: 126000, Data: []byte{1, 2, 3}})
+	expected := fromHex(t, "00 00 01 e0 00 0b 84 80 05 21 00 07 d8 61 01 02 03")
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("Expected % x; got % x", expected, actual)
+	}
+
+	// With a DTS.
+	actual = makePES(StreamIDVideo, Packet{PTS: 132000, DTS: 126000, Data: []byte{1, 2, 3}})
+	expected = fromHex(t, "00 00 01 e0 00 10 84 c0 0a 31 00 09 07 41 11 00 07 d8 61 01 02 03")
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("Expected % x; got % x", expected, actual)
+	}
+
+	// Video packets that are too big for the length field have a length of zero.
+	actual = makePES(StreamIDVideo, Packet{PTS: 126000, DTS: 126000, Data: make([]byte, 0x10000)})
+	if actual[4] != 0 || actual[5] != 0 {
+		t.Errorf("Expected a length of zero; got % x", actual[4:6])
+	}
+}
+
+func TestWriteTables(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer := NewWriter(buffer, []Stream{
+		{PID: PIDFirstElementaryStream, StreamType: StreamTypeH264, StreamID: StreamIDVideo, IsPCR: true},
+	})
+	err := writer.WriteTables()
+	if err != nil {
+		t.Fatalf("Could not write the tables: %v", err)
+	}
+	if buffer.Len() != 2*PacketSize {
+		t.Fatalf("Expected %d bytes; got %d", 2*PacketSize, buffer.Len())
+	}
+
+	// This is the same program association table that FFmpeg writes.
+	expected := fromHex(t, "47 40 00 10 00 00 b0 0d 00 01 c1 00 00 00 01 f0 00 2a b1 04 b2")
+	pat := buffer.Bytes()[0:PacketSize]
+	if !bytes.Equal(pat[0:len(expected)], expected) {
+		t.Errorf("Expected the PAT to start with % x; got % x", expected, pat[0:len(expected)])
+	}
+	for i := len(expected); i < PacketSize; i++ {
+		if pat[i] != 0xff {
+			t.Fatalf("Expected stuffing at byte %d; got 0x%02x", i, pat[i])
+		}
+	}
+
+	// The program map table, with the H.264 stream on PID 0x100 carrying the PCR.
+	pmtSection := fromHex(t, "02 b0 12 00 01 c1 00 00 e1 00 f0 00 1b e1 00 f0 00")
+	expected = append(fromHex(t, "47 50 00 10 00"), pmtSection...)
+	expected = append(expected, uint32Bytes(crc32MPEG2(pmtSection))...)
+	pmt := buffer.Bytes()[PacketSize : 2*PacketSize]
+	if !bytes.Equal(pmt[0:len(expected)], expected) {
+		t.Errorf("Expected the PMT to start with % x; got % x", expected, pmt[0:len(expected)])
+	}
+
+	// The continuity counters go up with each table.
+	buffer.Reset()
+	err = writer.WriteTables()
+	if err != nil {
+		t.Fatalf("Could not write the tables: %v", err)
+	}
+	if buffer.Bytes()[3] != 0x11 || buffer.Bytes()[PacketSize+3] != 0x11 {
+		t.Errorf("Expected a continuity counter of 1; got 0x%02x and 0x%02x", buffer.Bytes()[3], buffer.Bytes()[PacketSize+3])
+	}
+}
+
+func TestWritePacketPCR(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer := NewWriter(buffer, []Stream{
+		{PID: PIDFirstElementaryStream, StreamType: StreamTypeH264, StreamID: StreamIDVideo, IsPCR: true},
+	})
+	data := bytes.Repeat([]byte{0xab}, 10)
+	err := writer.WritePacket(Packet{PTS: InitialTimestamp, DTS: InitialTimestamp, IsKeyframe: true, Data: data})
+	if err != nil {
+		t.Fatalf("Could not write the packet: %v", err)
+	}
+
+	// The adaptation field has the random access indicator and a PCR of 126000 - 63000, and is
+	// padded out so that the PES packet fills the rest of the transport stream packet.
+	expected := fromHex(t, "47 41 00 30 9f 50 00 00 7b 0c 7e 00")
+	expected = append(expected, bytes.Repeat([]byte{0xff}, 152)...)
+	expected = append(expected, fromHex(t, "00 00 01 e0 00 12 84 80 05 21 00 07 d8 61")...)
+	expected = append(expected, data...)
+	if !bytes.Equal(buffer.Bytes(), expected) {
+		t.Errorf("Expected:\n% x\nGot:\n% x", expected, buffer.Bytes())
+	}
+}
+
+func TestWritePacketStuffing(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer := NewWriter(buffer, []Stream{
+		{PID: PIDFirstElementaryStream, StreamType: StreamTypeH264, StreamID: StreamIDVideo, IsPCR: true},
+		{PID: PIDFirstElementaryStream + 1, StreamType: StreamTypePrivate, StreamID: StreamIDPrivate},
+	})
+	data := []byte{1, 2, 3, 4, 5}
+	err := writer.WritePacket(Packet{StreamIndex: 1, PTS: InitialTimestamp, DTS: InitialTimestamp, Data: data})
+	if err != nil {
+		t.Fatalf("Could not write the packet: %v", err)
+	}
+
+	// Without a PCR or a keyframe, the adaptation field is only there for the stuffing.
+	expected := fromHex(t, "47 41 01 30 a4 00")
+	expected = append(expected, bytes.Repeat([]byte{0xff}, 163)...)
+	expected = append(expected, fromHex(t, "00 00 01 bd 00 0d 84 80 05 21 00 07 d8 61")...)
+	expected = append(expected, data...)
+	if !bytes.Equal(buffer.Bytes(), expected) {
+		t.Errorf("Expected:\n% x\nGot:\n% x", expected, buffer.Bytes())
+	}
+}
+
+func TestWritePacketSplit(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer := NewWriter(buffer, []Stream{
+		{PID: PIDFirstElementaryStream, StreamType: StreamTypeH264, StreamID: StreamIDVideo, IsPCR: true},
+	})
+	packet := Packet{PTS: 132000, DTS: 126000, Data: make([]byte, 500)}
+	for i := range packet.Data {
+		packet.Data[i] = byte(i)
+	}
+	err := writer.WritePacket(packet)
+	if err != nil {
+		t.Fatalf("Could not write the packet: %v", err)
+	}
+	if buffer.Len()%PacketSize != 0 {
+		t.Fatalf("Expected a whole number of packets; got %d bytes", buffer.Len())
+	}
+
+	// Put the PES packet back together from the payloads.
+	pes := []byte{}
+	for i := 0; i < buffer.Len()/PacketSize; i++ {
+		tsPacket := buffer.Bytes()[i*PacketSize : (i+1)*PacketSize]
+		if tsPacket[0] != syncByte {
+			t.Fatalf("Packet %d: expected a sync byte; got 0x%02x", i, tsPacket[0])
+		}
+		if start := tsPacket[1]&0x40 != 0; start != (i == 0) {
+			t.Errorf("Packet %d: unexpected payload unit start indicator: %t", i, start)
+		}
+		if counter := tsPacket[3] & 0x0f; counter != byte(i) {
+			t.Errorf("Packet %d: expected continuity counter %d; got %d", i, i, counter)
+		}
+		payload := tsPacket[4:]
+		if tsPacket[3]&0x20 != 0 {
+			payload = payload[1+int(payload[0]):]
+		}
+		pes = append(pes, payload...)
+	}
+	if expected := makePES(StreamIDVideo, packet); !bytes.Equal(pes, expected) {
+		t.Errorf("The payloads don't match the PES packet")
+	}
+}
+
+// uint32Bytes encodes a big-endian uint32.
+func uint32Bytes(value uint32) []byte {
+	return []byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}
+}
@@ -0,0 +1,50 @@
+package riff
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// BroadcastExtension is the Broadcast Wave Format (BWF) "bext" chunk.
+//
+// See EBU Tech 3285 for the details.
+type BroadcastExtension struct {
+	Description          [256]byte
+	Originator           [32]byte
+	OriginatorReference  [32]byte
+	OriginationDate      [10]byte // "yyyy-mm-dd"
+	OriginationTime      [8]byte  // "hh:mm:ss"
+	TimeReference        uint64   // Samples since midnight.
+	Version              int16
+	UMID                 [64]byte
+	LoudnessValue        int16
+	LoudnessRange        int16
+	MaxTruePeakLevel     int16
+	MaxMomentaryLoudness int16
+	MaxShortTermLoudness int16
+	Reserved             [180]byte
+}
+
+// BroadcastExtensionChunk is a "bext" chunk along with its (variable-length) coding history.
+type BroadcastExtensionChunk struct {
+	BroadcastExtension
+	CodingHistory string
+}
+
+// Bytes returns the encoded version of the chunk.
+func (h *BroadcastExtensionChunk) Bytes() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, h.BroadcastExtension)
+	buffer.WriteString(h.CodingHistory)
+	return buffer.Bytes()
+}
+
+// SetString copies a string into a fixed-length, null-padded field.
+//
+// The string is truncated if it does not fit.
+func SetString(field []byte, value string) {
+	for i := range field {
+		field[i] = 0
+	}
+	copy(field, value)
+}
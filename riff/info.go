@@ -0,0 +1,65 @@
+package riff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// These are the "LIST INFO" chunk IDs that we know about.
+const (
+	InfoTitle        = "INAM" // The title of the file.
+	InfoCreationDate = "ICRD" // The date that the subject was created, as "YYYY-MM-DD".
+	InfoSoftware     = "ISFT" // The software that created the file.
+	InfoComment      = "ICMT" // Free-form comments.
+)
+
+// DateFormat is the format of the "IDIT" date chunk (the same as `ctime`).
+const DateFormat = "Mon Jan 02 15:04:05 2006"
+
+// InfoEntry is a single entry in a "LIST INFO" block.
+type InfoEntry struct {
+	ID    string
+	Value string
+}
+
+// infoListBytes returns the data for a "LIST INFO" block (without the "INFO" list type).
+//
+// The values are written as null-terminated strings; empty values are skipped.
+func infoListBytes(entries []InfoEntry) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	for _, entry := range entries {
+		if len(entry.ID) != 4 {
+			return nil, fmt.Errorf("invalid info ID: %q", entry.ID)
+		}
+		if entry.Value == "" {
+			continue
+		}
+		data := append([]byte(entry.Value), 0)
+		err := writeChunk(buffer, entry.ID, data)
+		if err != nil {
+			return nil, err
+		}
+		// Chunks are padded to an even size.
+		if len(data)%2 != 0 {
+			buffer.WriteByte(0)
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+// dateBytes returns the data for an "IDIT" chunk.
+//
+// This is always 26 bytes long, so it never needs padding.
+func dateBytes(date time.Time) []byte {
+	return append([]byte(date.Format(DateFormat)+"\n"), 0)
+}
+
+// chunkString returns the string value of a chunk, without the trailing null and newline.
+func chunkString(data []byte) string {
+	if index := bytes.IndexByte(data, 0); index >= 0 {
+		data = data[:index]
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
@@ -0,0 +1,89 @@
+package riff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// OpenDMLThreshold is the largest that we make a single RIFF segment.
+//
+// This is our own limit (other writers may use segments up to the 4 GB RIFF limit), chosen because many
+// players can't read plain AVI files over 1 GB.  If a plain AVI file would be larger than this, then it is written with the OpenDML (AVI 2.0)
+// extensions instead: the data is split across a "RIFF AVI " segment and as many "RIFF AVIX"
+// segments as necessary, each segment has a standard index ("ix##") for every stream, and every
+// stream has a super index ("indx") that points to all of its standard indexes.
+const OpenDMLThreshold = 1 << 30
+
+// maxSuperIndexEntries is the number of super index entries that we reserve room for in each stream
+// header; with 1 GB segments, this allows for files of up to 256 GB.
+const maxSuperIndexEntries = 256
+
+// extendedHeaderListSize is the size of the "odml" list (including its header).
+const extendedHeaderListSize = 8 + 4 + 8 + 248
+
+// chunkSize returns the size of a chunk with the given data length, including its header and padding.
+func chunkSize(dataLength int) int64 {
+	size := 8 + int64(dataLength)
+	if dataLength%2 != 0 {
+		size++
+	}
+	return size
+}
+
+// standardIndexSize returns the size of a standard index chunk with the given number of entries.
+func standardIndexSize(entryCount int) int64 {
+	return 8 + 24 + 8*int64(entryCount)
+}
+
+// superIndexSize returns the size of a super index chunk with room for `maxSuperIndexEntries` entries.
+func superIndexSize() int64 {
+	return 8 + 24 + 16*maxSuperIndexEntries
+}
+
+// defaultChunkID returns the chunk ID that a stream would normally use (for example, "00dc").
+func defaultChunkID(streamIndex int, stream Stream) string {
+	switch fmt.Sprintf("%s", stream.Header.Type) {
+	case "auds":
+		return fmt.Sprintf("%02dwb", streamIndex)
+	default:
+		return fmt.Sprintf("%02ddc", streamIndex)
+	}
+}
+
+// makeSuperIndex creates the data for an OpenDML super index ("indx") chunk.
+//
+// The chunk always has room for `maxSuperIndexEntries` entries; the unused ones are zero.
+func makeSuperIndex(chunkID string, entries []AVISuperIndexEntry) []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, uint16(4)) // Longs per entry.
+	buffer.WriteByte(0)                                  // Index sub-type.
+	buffer.WriteByte(AVIIndexOfIndexes)
+	binary.Write(buffer, binary.LittleEndian, uint32(len(entries)))
+	buffer.WriteString(chunkID)
+	buffer.Write(make([]byte, 12)) // Reserved.
+	for i := 0; i < maxSuperIndexEntries; i++ {
+		entry := AVISuperIndexEntry{}
+		if i < len(entries) {
+			entry = entries[i]
+		}
+		binary.Write(buffer, binary.LittleEndian, entry)
+	}
+	return buffer.Bytes()
+}
+
+// makeStandardIndex creates the data for an OpenDML standard index ("ix##") chunk.
+func makeStandardIndex(chunkID string, baseOffset int64, entries []AVIStandardIndexEntry) []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, uint16(2)) // Longs per entry.
+	buffer.WriteByte(0)                                  // Index sub-type.
+	buffer.WriteByte(AVIIndexOfChunks)
+	binary.Write(buffer, binary.LittleEndian, uint32(len(entries)))
+	buffer.WriteString(chunkID)
+	binary.Write(buffer, binary.LittleEndian, uint64(baseOffset))
+	buffer.Write(make([]byte, 4)) // Reserved.
+	for _, entry := range entries {
+		binary.Write(buffer, binary.LittleEndian, entry)
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,366 @@
+package riff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Node is a single chunk (or list) in a RIFF file.
+type Node struct {
+	ID       string // The chunk ID; lists use "RIFF" or "LIST".
+	ListType string // The form or list type (only for "RIFF" and "LIST").
+	Offset   int64  // The offset of the chunk header in the file.
+	Size     uint32 // The size of the chunk data (for lists, this includes the list type).
+	Data     []byte // The chunk data; this is not loaded for the media chunks in a "movi" list.
+	Children []*Node
+}
+
+// IsList returns true if the node is a "RIFF" or "LIST" node.
+func (n *Node) IsList() bool {
+	return n.ID == "RIFF" || n.ID == "LIST"
+}
+
+// DataOffset returns the offset of the chunk data in the file.
+func (n *Node) DataOffset() int64 {
+	return n.Offset + 8
+}
+
+// Find returns the first child with the given ID (or list type, for lists).
+func (n *Node) Find(id string) *Node {
+	for _, child := range n.Children {
+		if child.ID == id || (child.IsList() && child.ListType == id) {
+			return child
+		}
+	}
+	return nil
+}
+
+// MovieChunk is a single media chunk from a "movi" list.
+type MovieChunk struct {
+	ID          string
+	StreamIndex int   // The stream number from the chunk ID (or -1).
+	Segment     int   // The index of the RIFF segment that the chunk is in.
+	Offset      int64 // The offset of the chunk header in the file.
+	Size        uint32
+}
+
+// StandardIndex is an OpenDML standard index ("ix##") chunk.
+type StandardIndex struct {
+	Offset     int64  // The offset of the chunk header in the file.
+	Size       uint32 // The size of the chunk data.
+	ChunkID    string
+	BaseOffset int64
+	Entries    []AVIStandardIndexEntry
+}
+
+// AVIContents is everything that `Read` found in an AVI file.
+type AVIContents struct {
+	Nodes           []*Node // The top-level "RIFF" nodes.
+	Header          AVIHeader
+	Streams         []Stream               // The stream headers and formats; the chunks are in `MovieChunks`.
+	SuperIndexes    [][]AVISuperIndexEntry // The super index of each stream (nil if there isn't one).
+	ExtendedHeader  *AVIExtendedHeader     // The OpenDML extended header (nil if there isn't one).
+	MovieLists      []*Node                // The "movi" list of each segment.
+	MovieChunks     []MovieChunk
+	Index           []AVIChunkIndex  // The "idx1" entries (nil if there isn't one).
+	StandardIndexes []*StandardIndex // All of the "ix##" chunks.
+	Info            []InfoEntry      // The "LIST INFO" entries.
+	Date            string           // The "IDIT" date (empty if there isn't one).
+}
+
+// ReadNodes reads the chunk tree of a RIFF file.
+//
+// Every top-level chunk must be a "RIFF" chunk.
+func ReadNodes(reader io.ReadSeeker) ([]*Node, error) {
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("could not seek: %v", err)
+	}
+
+	nodes := []*Node{}
+	var offset int64
+	for offset+8 <= size {
+		node, err := readNode(reader, offset, size, false)
+		if err != nil {
+			return nil, err
+		}
+		if node.ID != "RIFF" {
+			return nil, fmt.Errorf("expected a RIFF chunk at offset %d; found: %q", offset, node.ID)
+		}
+		nodes = append(nodes, node)
+		offset = node.Offset + chunkSize(int(node.Size))
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("not a RIFF file")
+	}
+	return nodes, nil
+}
+
+// readNode reads the node at the given offset (and all of its children).
+//
+// End is the offset of the end of the parent.  If `inMovie` is true, then the node is in a "movi"
+// list and its data is not loaded (unless it is an index).
+func readNode(reader io.ReadSeeker, offset int64, end int64, inMovie bool) (*Node, error) {
+	header := make([]byte, 12)
+	_, err := reader.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("could not seek: %v", err)
+	}
+	_, err = io.ReadFull(reader, header[0:8])
+	if err != nil {
+		return nil, fmt.Errorf("could not read chunk header at offset %d: %v", offset, err)
+	}
+
+	node := &Node{
+		ID:     string(header[0:4]),
+		Offset: offset,
+		Size:   binary.LittleEndian.Uint32(header[4:8]),
+	}
+	if node.DataOffset()+int64(node.Size) > end {
+		return nil, fmt.Errorf("chunk %q at offset %d has size %d, which runs past the end of its parent (%d)", node.ID, offset, node.Size, end)
+	}
+
+	if node.IsList() {
+		if node.Size < 4 {
+			return nil, fmt.Errorf("list at offset %d is too small: %d", offset, node.Size)
+		}
+		_, err = io.ReadFull(reader, header[8:12])
+		if err != nil {
+			return nil, fmt.Errorf("could not read list type at offset %d: %v", offset, err)
+		}
+		node.ListType = string(header[8:12])
+
+		childEnd := node.DataOffset() + int64(node.Size)
+		childOffset := node.DataOffset() + 4
+		for childOffset+8 <= childEnd {
+			child, err := readNode(reader, childOffset, childEnd, inMovie || node.ListType == "movi")
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+			childOffset += chunkSize(int(child.Size))
+		}
+		return node, nil
+	}
+
+	if inMovie && !strings.HasPrefix(node.ID, "ix") {
+		return node, nil
+	}
+	node.Data = make([]byte, node.Size)
+	_, err = io.ReadFull(reader, node.Data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read chunk %q at offset %d: %v", node.ID, offset, err)
+	}
+	return node, nil
+}
+
+// Read reads an AVI file, including any OpenDML extensions.
+//
+// The media data itself is not loaded; only the locations of the media chunks are recorded.
+func Read(reader io.ReadSeeker) (*AVIContents, error) {
+	nodes, err := ReadNodes(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := &AVIContents{
+		Nodes: nodes,
+	}
+	if nodes[0].ListType != "AVI " {
+		return nil, fmt.Errorf("not an AVI file: %q", nodes[0].ListType)
+	}
+
+	headerList := nodes[0].Find("hdrl")
+	if headerList == nil || !headerList.IsList() {
+		return nil, fmt.Errorf("missing the header list")
+	}
+	mainHeader := headerList.Find("avih")
+	if mainHeader == nil {
+		return nil, fmt.Errorf("missing the main header")
+	}
+	err = decode(mainHeader.Data, &contents.Header)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the main header: %v", err)
+	}
+
+	for _, child := range headerList.Children {
+		switch {
+		case child.IsList() && child.ListType == "strl":
+			stream := Stream{}
+			var superIndex []AVISuperIndexEntry
+			streamHeader := child.Find("strh")
+			if streamHeader == nil {
+				return nil, fmt.Errorf("stream %d: missing the stream header", len(contents.Streams))
+			}
+			err = decode(streamHeader.Data, &stream.Header)
+			if err != nil {
+				return nil, fmt.Errorf("stream %d: could not read the stream header: %v", len(contents.Streams), err)
+			}
+			if streamFormat := child.Find("strf"); streamFormat != nil {
+				switch fmt.Sprintf("%s", stream.Header.Type) {
+				case "auds":
+					err = decode(streamFormat.Data, &stream.AudioFormat)
+				case "vids":
+					err = decode(streamFormat.Data, &stream.VideoFormat)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("stream %d: could not read the stream format: %v", len(contents.Streams), err)
+				}
+			}
+			if indexNode := child.Find("indx"); indexNode != nil {
+				superIndex, err = readSuperIndex(indexNode.Data)
+				if err != nil {
+					return nil, fmt.Errorf("stream %d: could not read the super index: %v", len(contents.Streams), err)
+				}
+			}
+			contents.Streams = append(contents.Streams, stream)
+			contents.SuperIndexes = append(contents.SuperIndexes, superIndex)
+		case child.ID == "IDIT":
+			contents.Date = chunkString(child.Data)
+		case child.IsList() && child.ListType == "odml":
+			if extendedHeader := child.Find("dmlh"); extendedHeader != nil {
+				contents.ExtendedHeader = &AVIExtendedHeader{}
+				err = decode(extendedHeader.Data, contents.ExtendedHeader)
+				if err != nil {
+					return nil, fmt.Errorf("could not read the extended header: %v", err)
+				}
+			}
+		}
+	}
+
+	if infoList := nodes[0].Find("INFO"); infoList != nil && infoList.IsList() {
+		for _, child := range infoList.Children {
+			contents.Info = append(contents.Info, InfoEntry{ID: child.ID, Value: chunkString(child.Data)})
+		}
+	}
+
+	for segmentIndex, node := range nodes {
+		movieList := node.Find("movi")
+		if movieList == nil || !movieList.IsList() {
+			return nil, fmt.Errorf("segment %d: missing the movie list", segmentIndex)
+		}
+		contents.MovieLists = append(contents.MovieLists, movieList)
+
+		var walk func(list *Node) error
+		walk = func(list *Node) error {
+			for _, child := range list.Children {
+				switch {
+				case child.IsList():
+					// "rec " lists group chunks together.
+					err := walk(child)
+					if err != nil {
+						return err
+					}
+				case strings.HasPrefix(child.ID, "ix"):
+					standardIndex, err := readStandardIndex(child)
+					if err != nil {
+						return fmt.Errorf("segment %d: could not read the standard index at offset %d: %v", segmentIndex, child.Offset, err)
+					}
+					contents.StandardIndexes = append(contents.StandardIndexes, standardIndex)
+				case child.ID == "JUNK":
+				default:
+					contents.MovieChunks = append(contents.MovieChunks, MovieChunk{
+						ID:          child.ID,
+						StreamIndex: ChunkStreamIndex(child.ID),
+						Segment:     segmentIndex,
+						Offset:      child.Offset,
+						Size:        child.Size,
+					})
+				}
+			}
+			return nil
+		}
+		err = walk(movieList)
+		if err != nil {
+			return nil, err
+		}
+
+		if segmentIndex == 0 {
+			if indexNode := node.Find("idx1"); indexNode != nil {
+				contents.Index = []AVIChunkIndex{}
+				for i := 0; i+16 <= len(indexNode.Data); i += 16 {
+					contents.Index = append(contents.Index, AVIChunkIndex{
+						ID:          string(indexNode.Data[i : i+4]),
+						Flags:       int32(binary.LittleEndian.Uint32(indexNode.Data[i+4 : i+8])),
+						ChunkOffset: binary.LittleEndian.Uint32(indexNode.Data[i+8 : i+12]),
+						ChunkLength: binary.LittleEndian.Uint32(indexNode.Data[i+12 : i+16]),
+					})
+				}
+			}
+		}
+	}
+
+	return contents, nil
+}
+
+// ChunkStreamIndex returns the stream number from a chunk ID (for example, 1 for "01wb").
+//
+// This returns -1 if the chunk ID does not start with a stream number.
+func ChunkStreamIndex(id string) int {
+	if len(id) != 4 || id[0] < '0' || id[0] > '9' || id[1] < '0' || id[1] > '9' {
+		return -1
+	}
+	return int(id[0]-'0')*10 + int(id[1]-'0')
+}
+
+// decode decodes the data into the given structure.
+//
+// Chunks that are shorter than the structure are allowed (the rest is left as zero), since many
+// writers leave off the trailing fields.
+func decode(data []byte, value interface{}) error {
+	size := binary.Size(value)
+	if len(data) < size {
+		data = append(append([]byte{}, data...), make([]byte, size-len(data))...)
+	}
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, value)
+}
+
+// readSuperIndex reads the entries from an "indx" chunk.
+func readSuperIndex(data []byte) ([]AVISuperIndexEntry, error) {
+	if len(data) < 24 {
+		return nil, fmt.Errorf("chunk is too small: %d", len(data))
+	}
+	if data[3] != AVIIndexOfIndexes {
+		return nil, fmt.Errorf("unsupported index type: %d", data[3])
+	}
+	entryCount := int(binary.LittleEndian.Uint32(data[4:8]))
+	if 24+16*entryCount > len(data) {
+		return nil, fmt.Errorf("chunk is too small for %d entries: %d", entryCount, len(data))
+	}
+	entries := make([]AVISuperIndexEntry, entryCount)
+	err := binary.Read(bytes.NewReader(data[24:24+16*entryCount]), binary.LittleEndian, entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// readStandardIndex reads an "ix##" chunk.
+func readStandardIndex(node *Node) (*StandardIndex, error) {
+	data := node.Data
+	if len(data) < 24 {
+		return nil, fmt.Errorf("chunk is too small: %d", len(data))
+	}
+	if data[3] != AVIIndexOfChunks {
+		return nil, fmt.Errorf("unsupported index type: %d", data[3])
+	}
+	entryCount := int(binary.LittleEndian.Uint32(data[4:8]))
+	if 24+8*entryCount > len(data) {
+		return nil, fmt.Errorf("chunk is too small for %d entries: %d", entryCount, len(data))
+	}
+	index := &StandardIndex{
+		Offset:     node.Offset,
+		Size:       node.Size,
+		ChunkID:    string(data[8:12]),
+		BaseOffset: int64(binary.LittleEndian.Uint64(data[12:20])),
+		Entries:    make([]AVIStandardIndexEntry, entryCount),
+	}
+	err := binary.Read(bytes.NewReader(data[24:24+8*entryCount]), binary.LittleEndian, index.Entries)
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
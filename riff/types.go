@@ -3,12 +3,15 @@ package riff
 import (
 	"bytes"
 	"encoding/binary"
+	"time"
 )
 
 // AVIFile is a RIFF AVI file.
 type AVIFile struct {
 	Header  AVIHeader
 	Streams []Stream
+	Info    []InfoEntry // The "LIST INFO" entries (optional).
+	Date    time.Time   // The "IDIT" creation date (optional).
 }
 
 // AVIHeader is the AVI header.
@@ -69,8 +72,10 @@ type AVIStreamHeader struct {
 	SuggestedBufferSize int32
 	Quality             int32
 	SampleSize          int32
-	Width               int16
-	Height              int16
+	FrameLeft           int16
+	FrameTop            int16
+	FrameRight          int16 // For video, this is normally the width.
+	FrameBottom         int16 // For video, this is normally the height.
 }
 
 // Bytes returns the encoded version of the header.
@@ -132,8 +137,8 @@ type Chunk struct {
 type AVIChunkIndex struct {
 	ID          string
 	Flags       int32
-	ChunkOffset int32
-	ChunkLength int32
+	ChunkOffset uint32
+	ChunkLength uint32
 }
 
 // These are the AVI chunk index flags.
@@ -142,3 +147,38 @@ const (
 	AVIChunkIndexKeyframe       = 0x00000010
 	AVIChunkIndexNoTime         = 0x00000100
 )
+
+// AVIExtendedHeader is the OpenDML extended AVI header ("dmlh").
+type AVIExtendedHeader struct {
+	TotalFrames uint32 // The number of frames in the whole file (the "avih" only counts the first segment).
+	Reserved    [244]byte
+}
+
+// Bytes returns the encoded version of the header.
+func (h *AVIExtendedHeader) Bytes() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, *h)
+	return buffer.Bytes()
+}
+
+// These are the OpenDML index types.
+const (
+	AVIIndexOfIndexes byte = 0x00 // A super index ("indx").
+	AVIIndexOfChunks  byte = 0x01 // A standard index ("ix##").
+)
+
+// AVISuperIndexEntry is a single entry in an OpenDML super index.
+type AVISuperIndexEntry struct {
+	Offset   uint64 // The offset of the standard index chunk in the file.
+	Size     uint32 // The size of the standard index chunk (including its header).
+	Duration uint32 // The duration covered by the standard index, in stream ticks.
+}
+
+// AVIStandardIndexEntry is a single entry in an OpenDML standard index.
+type AVIStandardIndexEntry struct {
+	Offset uint32 // The offset of the chunk's data, relative to the base offset of the index.
+	Size   uint32 // The size of the chunk's data; the high bit is set if the chunk is not a keyframe.
+}
+
+// AVIStandardIndexDeltaFrame is set in the size of a standard index entry when the chunk is not a keyframe.
+const AVIStandardIndexDeltaFrame uint32 = 0x80000000
@@ -0,0 +1,274 @@
+package riff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Validate checks the contents of an AVI file against the AVI and OpenDML specifications.
+//
+// This returns a list of the problems that were found; if it is empty, then the file looks good.
+func (c *AVIContents) Validate() []string {
+	problems := []string{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// Count up the chunks for each stream.
+	chunksByOffset := map[int64]MovieChunk{}
+	chunkCounts := make([]int, len(c.Streams))
+	firstSegmentChunkCounts := make([]int, len(c.Streams))
+	chunkBytes := make([]int64, len(c.Streams))
+	firstChunkOffsets := make([]int64, len(c.Streams))
+	for streamIndex := range firstChunkOffsets {
+		firstChunkOffsets[streamIndex] = -1
+	}
+	for _, chunk := range c.MovieChunks {
+		chunksByOffset[chunk.Offset] = chunk
+		if chunk.StreamIndex < 0 || chunk.StreamIndex >= len(c.Streams) {
+			problem("Chunk %q at offset %d does not belong to any stream.", chunk.ID, chunk.Offset)
+			continue
+		}
+		if firstChunkOffsets[chunk.StreamIndex] < 0 {
+			firstChunkOffsets[chunk.StreamIndex] = chunk.Offset
+		}
+		chunkCounts[chunk.StreamIndex]++
+		if chunk.Segment == 0 {
+			firstSegmentChunkCounts[chunk.StreamIndex]++
+		}
+		chunkBytes[chunk.StreamIndex] += int64(chunk.Size)
+
+		switch fmt.Sprintf("%s", c.Streams[chunk.StreamIndex].Header.Type) {
+		case "vids":
+			if !strings.HasSuffix(chunk.ID, "dc") && !strings.HasSuffix(chunk.ID, "db") {
+				problem("Chunk %q at offset %d belongs to a video stream but is not a video chunk.", chunk.ID, chunk.Offset)
+			}
+		case "auds":
+			if !strings.HasSuffix(chunk.ID, "wb") {
+				problem("Chunk %q at offset %d belongs to an audio stream but is not an audio chunk.", chunk.ID, chunk.Offset)
+			}
+		}
+	}
+
+	// Segments.
+	for segmentIndex, node := range c.Nodes {
+		if segmentIndex > 0 && node.ListType != "AVIX" {
+			problem("Segment %d has form type %q; expected \"AVIX\".", segmentIndex, node.ListType)
+		}
+	}
+	if len(c.Nodes) > 1 && c.ExtendedHeader == nil {
+		problem("The file has %d segments but no OpenDML extended header.", len(c.Nodes))
+	}
+
+	// Main header.
+	videoStreamIndex := -1
+	for streamIndex, stream := range c.Streams {
+		if fmt.Sprintf("%s", stream.Header.Type) == "vids" {
+			videoStreamIndex = streamIndex
+			break
+		}
+	}
+	if int(c.Header.Streams) != len(c.Streams) {
+		problem("The main header says that there are %d streams, but there are %d.", c.Header.Streams, len(c.Streams))
+	}
+	if videoStreamIndex >= 0 {
+		videoStream := c.Streams[videoStreamIndex]
+		if c.ExtendedHeader != nil {
+			if int(c.Header.TotalFrames) != firstSegmentChunkCounts[videoStreamIndex] {
+				problem("The main header has %d total frames, but the first segment has %d.", c.Header.TotalFrames, firstSegmentChunkCounts[videoStreamIndex])
+			}
+			if int(c.ExtendedHeader.TotalFrames) != chunkCounts[videoStreamIndex] {
+				problem("The extended header has %d total frames, but there are %d.", c.ExtendedHeader.TotalFrames, chunkCounts[videoStreamIndex])
+			}
+		} else if int(c.Header.TotalFrames) != chunkCounts[videoStreamIndex] {
+			problem("The main header has %d total frames, but there are %d.", c.Header.TotalFrames, chunkCounts[videoStreamIndex])
+		}
+		// With several video streams, the main header has to be big enough for all of them.
+		for streamIndex, stream := range c.Streams {
+			if fmt.Sprintf("%s", stream.Header.Type) != "vids" {
+				continue
+			}
+			if c.Header.Width < stream.VideoFormat.Width || c.Header.Height < stream.VideoFormat.Height {
+				problem("The main header is %dx%d, but video stream %d is %dx%d.", c.Header.Width, c.Header.Height, streamIndex, stream.VideoFormat.Width, stream.VideoFormat.Height)
+			}
+		}
+		if videoStream.Header.Rate > 0 && videoStream.Header.Scale > 0 {
+			expected := 1000000.0 * float64(videoStream.Header.Scale) / float64(videoStream.Header.Rate)
+			difference := float64(c.Header.MicroSecPerFrame) - expected
+			if difference < -0.01*expected || difference > 0.01*expected {
+				problem("The main header has %d microseconds per frame, but the video stream rate implies %.0f.", c.Header.MicroSecPerFrame, expected)
+			}
+		}
+	}
+
+	// Streams.
+	for streamIndex, stream := range c.Streams {
+		if stream.Header.Scale <= 0 || stream.Header.Rate <= 0 {
+			problem("Stream %d: invalid rate: %d / %d.", streamIndex, stream.Header.Rate, stream.Header.Scale)
+		}
+		switch fmt.Sprintf("%s", stream.Header.Type) {
+		case "vids":
+			format := stream.VideoFormat
+			if format.Size < 40 {
+				problem("Stream %d: the video format size is %d; expected at least 40.", streamIndex, format.Size)
+			}
+			if format.Width <= 0 || format.Height <= 0 {
+				problem("Stream %d: invalid video dimensions: %dx%d.", streamIndex, format.Width, format.Height)
+			}
+			if int32(stream.Header.FrameRight-stream.Header.FrameLeft) != format.Width || int32(stream.Header.FrameBottom-stream.Header.FrameTop) != format.Height {
+				problem("Stream %d: the frame rectangle (%d, %d, %d, %d) does not match the video dimensions (%dx%d).", streamIndex, stream.Header.FrameLeft, stream.Header.FrameTop, stream.Header.FrameRight, stream.Header.FrameBottom, format.Width, format.Height)
+			}
+			if int(stream.Header.Length) != chunkCounts[streamIndex] {
+				problem("Stream %d: the length is %d, but there are %d frames.", streamIndex, stream.Header.Length, chunkCounts[streamIndex])
+			}
+		case "auds":
+			format := stream.AudioFormat
+			if format.Channels <= 0 {
+				problem("Stream %d: invalid channel count: %d.", streamIndex, format.Channels)
+			}
+			switch format.FormatTag {
+			case 0x0001, 0x0003, 0x0006, 0x0007: // PCM, IEEE float, A-law, mu-law
+				blockAlign := int32(format.Channels) * int32(format.BitsPerSample) / 8
+				if int32(format.BlockAlign) != blockAlign {
+					problem("Stream %d: the block alignment is %d; expected %d for %d channels of %d bits.", streamIndex, format.BlockAlign, blockAlign, format.Channels, format.BitsPerSample)
+				}
+				if format.AvgBytesPerSec != format.SamplesPerSec*blockAlign {
+					problem("Stream %d: the average bytes per second is %d; expected %d.", streamIndex, format.AvgBytesPerSec, format.SamplesPerSec*blockAlign)
+				}
+				if stream.Header.SampleSize != int32(format.BlockAlign) {
+					problem("Stream %d: the sample size is %d; expected the block alignment (%d).", streamIndex, stream.Header.SampleSize, format.BlockAlign)
+				}
+				if stream.Header.Scale > 0 && stream.Header.Rate/stream.Header.Scale != format.SamplesPerSec {
+					problem("Stream %d: the rate is %d / %d, but the sample rate is %d.", streamIndex, stream.Header.Rate, stream.Header.Scale, format.SamplesPerSec)
+				}
+			}
+			if stream.Header.SampleSize > 0 {
+				length := chunkBytes[streamIndex] / int64(stream.Header.SampleSize)
+				if int64(stream.Header.Length) != length {
+					problem("Stream %d: the length is %d, but there are %d samples.", streamIndex, stream.Header.Length, length)
+				}
+			} else if int(stream.Header.Length) != chunkCounts[streamIndex] {
+				problem("Stream %d: the length is %d, but there are %d chunks.", streamIndex, stream.Header.Length, chunkCounts[streamIndex])
+			}
+		}
+	}
+
+	// The keyframe flag for each chunk (by offset), according to each index.
+	legacyKeyframes := map[int64]bool{}
+	standardKeyframes := map[int64]bool{}
+
+	// Legacy index.
+	if c.Header.Flags&AVIFlagHasIndex == AVIFlagHasIndex && c.Index == nil {
+		problem("The main header says that there is an index, but there is no \"idx1\" chunk.")
+	}
+	if c.Index != nil {
+		firstSegmentChunks := 0
+		for _, chunk := range c.MovieChunks {
+			if chunk.Segment == 0 {
+				firstSegmentChunks++
+			}
+		}
+		if len(c.Index) != firstSegmentChunks {
+			problem("The \"idx1\" chunk has %d entries, but the first segment has %d chunks.", len(c.Index), firstSegmentChunks)
+		}
+		// The offsets are relative to the "movi" list type.
+		base := c.MovieLists[0].DataOffset()
+		for entryIndex, entry := range c.Index {
+			offset := base + int64(entry.ChunkOffset)
+			chunk, okay := chunksByOffset[offset]
+			if !okay {
+				problem("Index entry %d (%q) points to offset %d, which is not a chunk.", entryIndex, entry.ID, offset)
+				continue
+			}
+			if chunk.ID != entry.ID || chunk.Size != entry.ChunkLength {
+				problem("Index entry %d is %q with %d bytes, but the chunk at offset %d is %q with %d bytes.", entryIndex, entry.ID, entry.ChunkLength, offset, chunk.ID, chunk.Size)
+			}
+			legacyKeyframes[offset] = entry.Flags&AVIChunkIndexKeyframe == AVIChunkIndexKeyframe
+		}
+	}
+
+	// OpenDML indexes.
+	standardIndexesByOffset := map[int64]*StandardIndex{}
+	for _, standardIndex := range c.StandardIndexes {
+		standardIndexesByOffset[standardIndex.Offset] = standardIndex
+	}
+	for streamIndex, superIndex := range c.SuperIndexes {
+		if superIndex == nil {
+			if c.ExtendedHeader != nil {
+				problem("Stream %d: the file uses OpenDML, but the stream has no super index.", streamIndex)
+			}
+			continue
+		}
+		stream := c.Streams[streamIndex]
+		indexedChunks := 0
+		for entryIndex, entry := range superIndex {
+			standardIndex := standardIndexesByOffset[int64(entry.Offset)]
+			if standardIndex == nil {
+				problem("Stream %d: super index entry %d points to offset %d, which is not a standard index.", streamIndex, entryIndex, entry.Offset)
+				continue
+			}
+			if int64(entry.Size) != int64(standardIndex.Size)+8 {
+				problem("Stream %d: super index entry %d has size %d, but the standard index is %d bytes.", streamIndex, entryIndex, entry.Size, int64(standardIndex.Size)+8)
+			}
+			if ChunkStreamIndex(standardIndex.ChunkID) != streamIndex {
+				problem("Stream %d: super index entry %d points to a standard index for %q.", streamIndex, entryIndex, standardIndex.ChunkID)
+			}
+			var duration int64
+			for standardEntryIndex, standardEntry := range standardIndex.Entries {
+				offset := standardIndex.BaseOffset + int64(standardEntry.Offset) - 8
+				size := standardEntry.Size &^ AVIStandardIndexDeltaFrame
+				chunk, okay := chunksByOffset[offset]
+				if !okay {
+					problem("Stream %d: standard index entry %d at offset %d points to offset %d, which is not a chunk.", streamIndex, standardEntryIndex, standardIndex.Offset, offset)
+					continue
+				}
+				if chunk.ID != standardIndex.ChunkID || chunk.Size != size {
+					problem("Stream %d: standard index entry %d is %q with %d bytes, but the chunk at offset %d is %q with %d bytes.", streamIndex, standardEntryIndex, standardIndex.ChunkID, size, offset, chunk.ID, chunk.Size)
+				}
+				standardKeyframes[offset] = standardEntry.Size&AVIStandardIndexDeltaFrame == 0
+				if stream.Header.SampleSize > 0 {
+					duration += int64(size) / int64(stream.Header.SampleSize)
+				} else {
+					duration++
+				}
+			}
+			if duration != int64(entry.Duration) {
+				problem("Stream %d: super index entry %d has duration %d, but its standard index covers %d.", streamIndex, entryIndex, entry.Duration, duration)
+			}
+			indexedChunks += len(standardIndex.Entries)
+		}
+		if indexedChunks != chunkCounts[streamIndex] {
+			problem("Stream %d: the standard indexes cover %d chunks, but there are %d.", streamIndex, indexedChunks, chunkCounts[streamIndex])
+		}
+	}
+
+	// Keyframes.
+	if c.Index == nil && len(c.StandardIndexes) == 0 {
+		problem("The file has no index.")
+	}
+	for offset, isKeyframe := range legacyKeyframes {
+		if standardIsKeyframe, okay := standardKeyframes[offset]; okay && standardIsKeyframe != isKeyframe {
+			problem("The chunk at offset %d is a keyframe according to one index but not the other.", offset)
+		}
+	}
+	for streamIndex, stream := range c.Streams {
+		offset := firstChunkOffsets[streamIndex]
+		if offset < 0 {
+			continue
+		}
+		isKeyframe, okay := standardKeyframes[offset]
+		if !okay {
+			isKeyframe, okay = legacyKeyframes[offset]
+		}
+		if okay && !isKeyframe {
+			switch fmt.Sprintf("%s", stream.Header.Type) {
+			case "vids":
+				problem("Stream %d: the first frame is not a keyframe.", streamIndex)
+			case "auds":
+				problem("Stream %d: the first chunk is not flagged as a keyframe (every audio chunk should be).", streamIndex)
+			}
+		}
+	}
+
+	return problems
+}
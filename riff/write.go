@@ -1,136 +1,80 @@
 package riff
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"sort"
 )
 
-// Write writes a RIFF file.
-func Write(writer io.Writer, file *AVIFile) error {
-	var err error
-	buffer := new(bytes.Buffer)
-	_, err = buffer.Write([]byte{'A', 'V', 'I', ' '})
+// Write writes an AVI file.
+//
+// The chunks from all of the streams are interleaved by their timestamps and written with an `AVIWriter`.
+func Write(writer io.WriteSeeker, file *AVIFile) error {
+	aviWriter, err := NewAVIWriter(writer, file)
 	if err != nil {
 		return err
 	}
-	// "hdrl" list
-	{
-		headerListBuffer := new(bytes.Buffer)
-		{
-			err = writeChunk(headerListBuffer, "avih", file.Header.Bytes())
-			if err != nil {
-				return err
-			}
-		}
-		for _, stream := range file.Streams {
-			streamListChunks := new(bytes.Buffer)
-			err = writeChunk(streamListChunks, "strh", stream.Header.Bytes())
-			if err != nil {
-				return err
-			}
-			switch fmt.Sprintf("%s", stream.Header.Type) {
-			case "auds":
-				err = writeChunk(streamListChunks, "strf", stream.AudioFormat.Bytes())
-				if err != nil {
-					return err
-				}
-			case "vids":
-				err = writeChunk(streamListChunks, "strf", stream.VideoFormat.Bytes())
-				if err != nil {
-					return err
-				}
-			}
 
-			err = writeList(headerListBuffer, "strl", streamListChunks.Bytes())
-			if err != nil {
-				return err
-			}
+	// Interleave the chunks using the sneaky timestamp field that we added to the
+	// chunk information.
+	type streamChunk struct {
+		Chunk
+		StreamIndex int
+	}
+	interleavedChunks := []streamChunk{}
+	for streamIndex, stream := range file.Streams {
+		for _, chunk := range stream.Chunks {
+			interleavedChunks = append(interleavedChunks, streamChunk{Chunk: chunk, StreamIndex: streamIndex})
 		}
-		err = writeList(buffer, "hdrl", headerListBuffer.Bytes())
+	}
+	sort.SliceStable(interleavedChunks, func(i, j int) bool {
+		return interleavedChunks[i].Timestamp < interleavedChunks[j].Timestamp
+	})
+
+	for _, chunk := range interleavedChunks {
+		err = aviWriter.AddChunk(chunk.StreamIndex, chunk.Chunk)
 		if err != nil {
 			return err
 		}
 	}
-	// "movi" list
-	{
-		movieListBuffer := new(bytes.Buffer)
-		indexes := []AVIChunkIndex{}
-
-		// Interleave the chunks using the sneaky timestamp field that we added to the
-		// chunk information.
-		interleavedChunks := []Chunk{}
-		for _, stream := range file.Streams {
-			interleavedChunks = append(interleavedChunks, stream.Chunks...)
-		}
-		sort.Slice(interleavedChunks, func(i, j int) bool {
-			return interleavedChunks[i].Timestamp < interleavedChunks[j].Timestamp
-		})
-
-		for _, chunk := range interleavedChunks {
-			chunkIndex := AVIChunkIndex{
-				ID:          chunk.ID,
-				Flags:       0,
-				ChunkOffset: int32(4 + movieListBuffer.Len()),
-				ChunkLength: int32(len(chunk.Data)),
-			}
-
-			err = writeChunk(movieListBuffer, chunk.ID, chunk.Data)
-			if err != nil {
-				return err
-			}
-			// Line up on a 16-bit boundary.
-			if len(chunk.Data)%2 != 0 {
-				_, err = movieListBuffer.Write([]byte{0})
-				if err != nil {
-					return err
-				}
-			}
+	return aviWriter.Close()
+}
 
-			if chunk.IsKeyframe {
-				chunkIndex.Flags = AVIChunkIndexKeyframe
-			}
-			indexes = append(indexes, chunkIndex)
-		}
-		err = writeList(buffer, "movi", movieListBuffer.Bytes())
+// AppendChunks appends chunks to the end of an existing RIFF file and updates the RIFF size.
+//
+// This is useful for adding extra chunks (such as "bext") to a file that some other
+// encoder has already written.
+func AppendChunks(writer io.WriteSeeker, chunks ...Chunk) error {
+	end, err := writer.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		err = writeChunk(writer, chunk.ID, chunk.Data)
 		if err != nil {
 			return err
 		}
-		if file.Header.Flags&AVIFlagHasIndex == AVIFlagHasIndex {
-			indexChunks := new(bytes.Buffer)
-			for _, index := range indexes {
-				codeType := []byte(index.ID)
-				if len(codeType) != 4 {
-					return fmt.Errorf("Incorrect chunk identifier: %q", index.ID)
-				}
-				codeType = codeType[0:4]
-				err = binary.Write(indexChunks, binary.LittleEndian, codeType)
-				if err != nil {
-					return err
-				}
-				err = binary.Write(indexChunks, binary.LittleEndian, index.Flags)
-				if err != nil {
-					return err
-				}
-				err = binary.Write(indexChunks, binary.LittleEndian, index.ChunkOffset)
-				if err != nil {
-					return err
-				}
-				err = binary.Write(indexChunks, binary.LittleEndian, index.ChunkLength)
-				if err != nil {
-					return err
-				}
-			}
-			err = writeChunk(buffer, "idx1", indexChunks.Bytes())
+		end += 8 + int64(len(chunk.Data))
+		// Line up on a 16-bit boundary.
+		if len(chunk.Data)%2 != 0 {
+			_, err = writer.Write([]byte{0})
 			if err != nil {
 				return err
 			}
+			end++
 		}
 	}
 
-	err = writeChunk(writer, "RIFF", buffer.Bytes())
+	_, err = writer.Seek(4, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(writer, binary.LittleEndian, uint32(end-8))
+	if err != nil {
+		return err
+	}
+	_, err = writer.Seek(0, io.SeekEnd)
 	if err != nil {
 		return err
 	}
@@ -153,7 +97,7 @@ func writeChunk(writer io.Writer, chunkType string, data []byte) error {
 	if err != nil {
 		return err
 	}
-	err = binary.Write(writer, binary.LittleEndian, int32(len(data)))
+	err = binary.Write(writer, binary.LittleEndian, uint32(len(data)))
 	if err != nil {
 		return err
 	}
@@ -177,7 +121,7 @@ func writeList(writer io.Writer, listType string, data []byte) error {
 	if err != nil {
 		return err
 	}
-	err = binary.Write(writer, binary.LittleEndian, int32(len(typeCode)+len(data)))
+	err = binary.Write(writer, binary.LittleEndian, uint32(len(typeCode)+len(data)))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,443 @@
+package riff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// AVIWriter writes an AVI file one chunk at a time.
+//
+// The headers are written up front and patched when the writer is closed, so none of the chunk
+// data needs to be held in memory.  Once the file would pass `OpenDMLThreshold`, the writer
+// switches to OpenDML and starts a new "AVIX" segment.
+//
+// The headers have room reserved for the OpenDML super indexes and extended header; if the file
+// never needs them, then that space is left as "JUNK" chunks.
+type AVIWriter struct {
+	writer  io.WriteSeeker
+	header  AVIHeader
+	streams []Stream
+
+	headerOffset         int64   // The offset of the "avih" data.
+	streamHeaderOffsets  []int64 // The offset of each "strh" data.
+	superIndexOffsets    []int64 // The offset of each reserved "indx" chunk.
+	extendedHeaderOffset int64   // The offset of the reserved "odml" list.
+
+	offset        int64 // The current offset in the file.
+	segmentOffset int64 // The offset of the current "RIFF" header.
+	movieOffset   int64 // The offset of the current "movi" list type.
+	segmentIndex  int
+	segmentLimit  int64 // The largest that a segment may be; this is `OpenDMLThreshold`.
+	segmentChunks int   // The number of chunks in the current segment.
+	openDML       bool
+	closed        bool
+
+	videoStreamIndex   int // The index of the first video stream (or -1).
+	firstSegmentFrames int // The number of frames of the first video stream in the first segment.
+
+	chunkIDs         []string // The chunk ID of each stream.
+	chunkCounts      []int    // The number of chunks in each stream.
+	lengths          []int64  // The length of each stream, in stream ticks.
+	legacyIndex      []AVIChunkIndex
+	standardIndexes  [][]AVIStandardIndexEntry // For the current segment.
+	segmentDurations []uint32                  // For the current segment.
+	superIndexes     [][]AVISuperIndexEntry
+}
+
+// NewAVIWriter creates a new `AVIWriter` and writes the headers.
+//
+// Only the headers, the stream formats, and the info and date of the file are used; any
+// chunks in the streams are ignored.
+// If the total frame count in the header or the length of a stream is zero, then it is filled
+// in from the chunks when the writer is closed (in samples if the stream has a sample size, and
+// in chunks otherwise).
+func NewAVIWriter(writer io.WriteSeeker, file *AVIFile) (*AVIWriter, error) {
+	header := file.Header
+	streams := file.Streams
+	w := &AVIWriter{
+		writer:           writer,
+		header:           header,
+		streams:          streams,
+		segmentLimit:     OpenDMLThreshold,
+		videoStreamIndex: -1,
+		chunkIDs:         make([]string, len(streams)),
+		chunkCounts:      make([]int, len(streams)),
+		lengths:          make([]int64, len(streams)),
+		standardIndexes:  make([][]AVIStandardIndexEntry, len(streams)),
+		segmentDurations: make([]uint32, len(streams)),
+		superIndexes:     make([][]AVISuperIndexEntry, len(streams)),
+	}
+	for streamIndex, stream := range streams {
+		w.chunkIDs[streamIndex] = defaultChunkID(streamIndex, stream)
+		if w.videoStreamIndex < 0 && fmt.Sprintf("%s", stream.Header.Type) == "vids" {
+			w.videoStreamIndex = streamIndex
+		}
+	}
+
+	// The "hdrl" list data starts after "RIFF" size "AVI " "LIST" size "hdrl".
+	const headerListDataOffset = 24
+
+	headerListBuffer := new(bytes.Buffer)
+	w.headerOffset = headerListDataOffset + 8
+	err := writeChunk(headerListBuffer, "avih", header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range streams {
+		streamListChunks := new(bytes.Buffer)
+		err = writeChunk(streamListChunks, "strh", stream.Header.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		switch fmt.Sprintf("%s", stream.Header.Type) {
+		case "auds":
+			err = writeChunk(streamListChunks, "strf", stream.AudioFormat.Bytes())
+			if err != nil {
+				return nil, err
+			}
+		case "vids":
+			err = writeChunk(streamListChunks, "strf", stream.VideoFormat.Bytes())
+			if err != nil {
+				return nil, err
+			}
+		}
+		superIndexOffset := int64(streamListChunks.Len())
+		err = writeChunk(streamListChunks, "JUNK", make([]byte, superIndexSize()-8))
+		if err != nil {
+			return nil, err
+		}
+
+		streamListOffset := headerListDataOffset + int64(headerListBuffer.Len()) + 12
+		w.streamHeaderOffsets = append(w.streamHeaderOffsets, streamListOffset+8)
+		w.superIndexOffsets = append(w.superIndexOffsets, streamListOffset+superIndexOffset)
+		err = writeList(headerListBuffer, "strl", streamListChunks.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.extendedHeaderOffset = headerListDataOffset + int64(headerListBuffer.Len())
+	err = writeChunk(headerListBuffer, "JUNK", make([]byte, extendedHeaderListSize-8))
+	if err != nil {
+		return nil, err
+	}
+	if !file.Date.IsZero() {
+		err = writeChunk(headerListBuffer, "IDIT", dateBytes(file.Date))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	buffer.WriteString("RIFF")
+	binary.Write(buffer, binary.LittleEndian, uint32(0)) // This is patched at the end of the segment.
+	buffer.WriteString("AVI ")
+	err = writeList(buffer, "hdrl", headerListBuffer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Info) > 0 {
+		infoList, err := infoListBytes(file.Info)
+		if err != nil {
+			return nil, err
+		}
+		err = writeList(buffer, "INFO", infoList)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = w.write(buffer.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	err = w.startMovieList()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// AddChunk writes a chunk for the given stream.
+//
+// The chunks must be added in the order that they should appear in the file.
+func (w *AVIWriter) AddChunk(streamIndex int, chunk Chunk) error {
+	if w.closed {
+		return fmt.Errorf("writer is closed")
+	}
+	if streamIndex < 0 || streamIndex >= len(w.streams) {
+		return fmt.Errorf("invalid stream index: %d", streamIndex)
+	}
+	if len(chunk.ID) != 4 {
+		return fmt.Errorf("Incorrect chunk identifier: %q", chunk.ID)
+	}
+
+	// If this chunk (and its index entries) would push the segment past the limit, then start a new one.
+	if w.segmentChunks > 0 {
+		size := w.offset - w.segmentOffset + chunkSize(len(chunk.Data))
+		for i, entries := range w.standardIndexes {
+			entryCount := len(entries)
+			if i == streamIndex {
+				entryCount++
+			}
+			size += standardIndexSize(entryCount)
+		}
+		if w.segmentIndex == 0 {
+			size += 8 + 16*int64(len(w.legacyIndex)+1)
+		}
+		if size > w.segmentLimit {
+			w.openDML = true
+			err := w.finishSegment()
+			if err != nil {
+				return err
+			}
+			err = w.startSegment()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	chunkOffset := w.offset
+	err := writeChunk(w.writer, chunk.ID, chunk.Data)
+	if err != nil {
+		return err
+	}
+	// Line up on a 16-bit boundary.
+	if len(chunk.Data)%2 != 0 {
+		_, err = w.writer.Write([]byte{0})
+		if err != nil {
+			return err
+		}
+	}
+	w.offset += chunkSize(len(chunk.Data))
+	w.segmentChunks++
+
+	isVideo := fmt.Sprintf("%s", w.streams[streamIndex].Header.Type) == "vids"
+	if w.chunkCounts[streamIndex] == 0 {
+		w.chunkIDs[streamIndex] = chunk.ID
+	}
+	w.chunkCounts[streamIndex]++
+	if w.segmentIndex == 0 && streamIndex == w.videoStreamIndex {
+		w.firstSegmentFrames++
+	}
+
+	if w.segmentIndex == 0 {
+		index := AVIChunkIndex{
+			ID:          chunk.ID,
+			Flags:       0,
+			ChunkOffset: uint32(chunkOffset - w.movieOffset),
+			ChunkLength: uint32(len(chunk.Data)),
+		}
+		if chunk.IsKeyframe {
+			index.Flags = AVIChunkIndexKeyframe
+		}
+		w.legacyIndex = append(w.legacyIndex, index)
+	}
+
+	indexEntry := AVIStandardIndexEntry{
+		Offset: uint32(chunkOffset + 8 - w.segmentOffset),
+		Size:   uint32(len(chunk.Data)),
+	}
+	if isVideo && !chunk.IsKeyframe {
+		indexEntry.Size |= AVIStandardIndexDeltaFrame
+	}
+	w.standardIndexes[streamIndex] = append(w.standardIndexes[streamIndex], indexEntry)
+
+	duration := 1
+	if sampleSize := w.streams[streamIndex].Header.SampleSize; sampleSize > 0 {
+		duration = len(chunk.Data) / int(sampleSize)
+	}
+	w.segmentDurations[streamIndex] += uint32(duration)
+	w.lengths[streamIndex] += int64(duration)
+
+	return nil
+}
+
+// Close finishes the file and patches all of the headers.
+//
+// This does not close the underlying writer.
+func (w *AVIWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.finishSegment()
+	if err != nil {
+		return err
+	}
+	end := w.offset
+
+	header := w.header
+	if header.TotalFrames == 0 && w.videoStreamIndex >= 0 {
+		header.TotalFrames = int32(w.chunkCounts[w.videoStreamIndex])
+	}
+	if w.openDML {
+		extendedHeader := &AVIExtendedHeader{
+			TotalFrames: uint32(header.TotalFrames),
+		}
+		// The main header only counts the frames in the first segment.
+		header.TotalFrames = int32(w.firstSegmentFrames)
+
+		buffer := new(bytes.Buffer)
+		err = writeChunk(buffer, "dmlh", extendedHeader.Bytes())
+		if err != nil {
+			return err
+		}
+		list := new(bytes.Buffer)
+		err = writeList(list, "odml", buffer.Bytes())
+		if err != nil {
+			return err
+		}
+		err = w.patch(w.extendedHeaderOffset, list.Bytes())
+		if err != nil {
+			return err
+		}
+
+		for streamIndex := range w.streams {
+			buffer := new(bytes.Buffer)
+			err = writeChunk(buffer, "indx", makeSuperIndex(w.chunkIDs[streamIndex], w.superIndexes[streamIndex]))
+			if err != nil {
+				return err
+			}
+			err = w.patch(w.superIndexOffsets[streamIndex], buffer.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = w.patch(w.headerOffset, header.Bytes())
+	if err != nil {
+		return err
+	}
+
+	for streamIndex, stream := range w.streams {
+		streamHeader := stream.Header
+		if streamHeader.Length == 0 {
+			streamHeader.Length = int32(w.lengths[streamIndex])
+		}
+		err = w.patch(w.streamHeaderOffsets[streamIndex], streamHeader.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.writer.Seek(end, io.SeekStart)
+	return err
+}
+
+// write writes the data at the current offset.
+func (w *AVIWriter) write(data []byte) error {
+	_, err := w.writer.Write(data)
+	if err != nil {
+		return err
+	}
+	w.offset += int64(len(data))
+	return nil
+}
+
+// patch overwrites the data at the given offset and then returns to the current offset.
+func (w *AVIWriter) patch(offset int64, data []byte) error {
+	_, err := w.writer.Seek(offset, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("could not seek: %v", err)
+	}
+	_, err = w.writer.Write(data)
+	if err != nil {
+		return err
+	}
+	_, err = w.writer.Seek(w.offset, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("could not seek: %v", err)
+	}
+	return nil
+}
+
+// startMovieList starts the "movi" list of the current segment.
+func (w *AVIWriter) startMovieList() error {
+	err := w.write([]byte("LIST\x00\x00\x00\x00")) // The size is patched at the end of the segment.
+	if err != nil {
+		return err
+	}
+	w.movieOffset = w.offset
+	return w.write([]byte("movi"))
+}
+
+// startSegment starts a new "AVIX" segment.
+func (w *AVIWriter) startSegment() error {
+	if w.segmentIndex+1 >= maxSuperIndexEntries {
+		return fmt.Errorf("file is too large: more than %d segments", maxSuperIndexEntries)
+	}
+	w.segmentIndex++
+	w.segmentOffset = w.offset
+	w.segmentChunks = 0
+	err := w.write([]byte("RIFF\x00\x00\x00\x00AVIX")) // The size is patched at the end of the segment.
+	if err != nil {
+		return err
+	}
+	return w.startMovieList()
+}
+
+// finishSegment writes the indexes for the current segment and patches its sizes.
+func (w *AVIWriter) finishSegment() error {
+	var err error
+	if w.openDML {
+		for streamIndex := range w.streams {
+			indexOffset := w.offset
+			buffer := new(bytes.Buffer)
+			err = writeChunk(buffer, fmt.Sprintf("ix%02d", streamIndex), makeStandardIndex(w.chunkIDs[streamIndex], w.segmentOffset, w.standardIndexes[streamIndex]))
+			if err != nil {
+				return err
+			}
+			err = w.write(buffer.Bytes())
+			if err != nil {
+				return err
+			}
+			w.superIndexes[streamIndex] = append(w.superIndexes[streamIndex], AVISuperIndexEntry{
+				Offset:   uint64(indexOffset),
+				Size:     uint32(buffer.Len()),
+				Duration: w.segmentDurations[streamIndex],
+			})
+			w.standardIndexes[streamIndex] = nil
+			w.segmentDurations[streamIndex] = 0
+		}
+	} else {
+		for streamIndex := range w.streams {
+			w.standardIndexes[streamIndex] = nil
+			w.segmentDurations[streamIndex] = 0
+		}
+	}
+
+	movieSize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(movieSize, uint32(w.offset-w.movieOffset))
+	err = w.patch(w.movieOffset-4, movieSize)
+	if err != nil {
+		return err
+	}
+
+	// "idx1" chunk (this only covers the first segment)
+	if w.segmentIndex == 0 && w.header.Flags&AVIFlagHasIndex == AVIFlagHasIndex {
+		indexChunks := new(bytes.Buffer)
+		for _, index := range w.legacyIndex {
+			indexChunks.WriteString(index.ID)
+			binary.Write(indexChunks, binary.LittleEndian, index.Flags)
+			binary.Write(indexChunks, binary.LittleEndian, index.ChunkOffset)
+			binary.Write(indexChunks, binary.LittleEndian, index.ChunkLength)
+		}
+		buffer := new(bytes.Buffer)
+		err = writeChunk(buffer, "idx1", indexChunks.Bytes())
+		if err != nil {
+			return err
+		}
+		err = w.write(buffer.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	w.legacyIndex = nil
+
+	segmentSize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(segmentSize, uint32(w.offset-w.segmentOffset-8))
+	return w.patch(w.segmentOffset+4, segmentSize)
+}
@@ -0,0 +1,256 @@
+package riff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// testAVIFile returns an AVI file with a 30 fps video stream and an 8 kHz 8-bit audio stream.
+func testAVIFile() *AVIFile {
+	return &AVIFile{
+		Header: AVIHeader{
+			MicroSecPerFrame: 33333,
+			Flags:            AVIFlagHasIndex | AVIFlagIsInterleaved,
+			Streams:          2,
+			Width:            64,
+			Height:           48,
+		},
+		Streams: []Stream{
+			{
+				Header: AVIStreamHeader{
+					Type:        [4]byte{'v', 'i', 'd', 's'},
+					Handler:     [4]byte{'H', '2', '6', '4'},
+					Scale:       1,
+					Rate:        30,
+					FrameRight:  64,
+					FrameBottom: 48,
+				},
+				VideoFormat: AVIStreamVideoFormat{
+					Size:        40,
+					Width:       64,
+					Height:      48,
+					Planes:      1,
+					BitCount:    24,
+					Compression: [4]byte{'H', '2', '6', '4'},
+				},
+			},
+			{
+				Header: AVIStreamHeader{
+					Type:       [4]byte{'a', 'u', 'd', 's'},
+					Handler:    [4]byte{' ', ' ', ' ', ' '},
+					Scale:      1,
+					Rate:       8000,
+					SampleSize: 1,
+				},
+				AudioFormat: AVIStreamAudioFormat{
+					FormatTag:      0x0001,
+					Channels:       1,
+					SamplesPerSec:  8000,
+					AvgBytesPerSec: 8000,
+					BlockAlign:     1,
+					BitsPerSample:  8,
+				},
+			},
+		},
+	}
+}
+
+// writeTestAVI writes `frameCount` video frames (with a keyframe every 10 frames), each followed by
+// an audio chunk, and returns the contents of the file along with the data of every chunk.
+func writeTestAVI(t *testing.T, frameCount int, segmentLimit int64) ([]byte, [][]byte) {
+	out := &memoryWriteSeeker{}
+	writer, err := NewAVIWriter(out, testAVIFile())
+	if err != nil {
+		t.Fatalf("Could not create the writer: %v", err)
+	}
+	if segmentLimit > 0 {
+		writer.segmentLimit = segmentLimit
+	}
+	chunkData := [][]byte{}
+	for i := 0; i < frameCount; i++ {
+		video := bytes.Repeat([]byte{byte(i)}, 1000+i%7)
+		err = writer.AddChunk(0, Chunk{ID: "00dc", Data: video, IsKeyframe: i%10 == 0})
+		if err != nil {
+			t.Fatalf("Could not add video chunk %d: %v", i, err)
+		}
+		audio := bytes.Repeat([]byte{0x80 + byte(i%3)}, 267)
+		err = writer.AddChunk(1, Chunk{ID: "01wb", Data: audio, IsKeyframe: true})
+		if err != nil {
+			t.Fatalf("Could not add audio chunk %d: %v", i, err)
+		}
+		chunkData = append(chunkData, video, audio)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("Could not close the writer: %v", err)
+	}
+	return out.data, chunkData
+}
+
+// checkTestAVI reads the file back, validates it, and makes sure that every chunk is there.
+func checkTestAVI(t *testing.T, contents []byte, chunkData [][]byte) *AVIContents {
+	avi, err := Read(bytes.NewReader(contents))
+	if err != nil {
+		t.Fatalf("Could not read the file: %v", err)
+	}
+	for _, problem := range avi.Validate() {
+		t.Errorf("Problem: %s", problem)
+	}
+
+	if len(avi.MovieChunks) != len(chunkData) {
+		t.Fatalf("Expected %d chunks; got %d", len(chunkData), len(avi.MovieChunks))
+	}
+	for i, chunk := range avi.MovieChunks {
+		expectedID := "00dc"
+		if i%2 == 1 {
+			expectedID = "01wb"
+		}
+		if chunk.ID != expectedID {
+			t.Errorf("Chunk %d: expected ID %q; got %q", i, expectedID, chunk.ID)
+		}
+		data := contents[chunk.Offset+8 : chunk.Offset+8+int64(chunk.Size)]
+		if !bytes.Equal(data, chunkData[i]) {
+			t.Errorf("Chunk %d: the data doesn't match", i)
+		}
+	}
+	if avi.Streams[0].Header.Length != int32(len(chunkData)/2) {
+		t.Errorf("Expected a video length of %d; got %d", len(chunkData)/2, avi.Streams[0].Header.Length)
+	}
+	if avi.Streams[1].Header.Length != int32(267*len(chunkData)/2) {
+		t.Errorf("Expected an audio length of %d; got %d", 267*len(chunkData)/2, avi.Streams[1].Header.Length)
+	}
+	return avi
+}
+
+func TestAVIWriterRoundTrip(t *testing.T) {
+	contents, chunkData := writeTestAVI(t, 50, 0)
+	avi := checkTestAVI(t, contents, chunkData)
+
+	if len(avi.Nodes) != 1 {
+		t.Errorf("Expected 1 segment; got %d", len(avi.Nodes))
+	}
+	if avi.ExtendedHeader != nil {
+		t.Errorf("Expected no OpenDML extended header")
+	}
+	if len(avi.Index) != len(chunkData) {
+		t.Errorf("Expected %d index entries; got %d", len(chunkData), len(avi.Index))
+	}
+	if avi.Header.TotalFrames != 50 {
+		t.Errorf("Expected 50 total frames; got %d", avi.Header.TotalFrames)
+	}
+}
+
+func TestAVIWriterOpenDML(t *testing.T) {
+	// With a 64 KB limit, 200 frames of about 1.3 KB each need several segments.
+	const segmentLimit = 64 * 1024
+	contents, chunkData := writeTestAVI(t, 200, segmentLimit)
+	avi := checkTestAVI(t, contents, chunkData)
+
+	if len(avi.Nodes) < 4 {
+		t.Fatalf("Expected at least 4 segments; got %d", len(avi.Nodes))
+	}
+	for segmentIndex, node := range avi.Nodes {
+		expectedType := "AVIX"
+		if segmentIndex == 0 {
+			expectedType = "AVI "
+		}
+		if node.ListType != expectedType {
+			t.Errorf("Segment %d: expected %q; got %q", segmentIndex, expectedType, node.ListType)
+		}
+		if int64(node.Size)+8 > segmentLimit {
+			t.Errorf("Segment %d: expected at most %d bytes; got %d", segmentIndex, segmentLimit, int64(node.Size)+8)
+		}
+	}
+	if avi.ExtendedHeader == nil {
+		t.Fatalf("Expected an OpenDML extended header")
+	}
+	if avi.ExtendedHeader.TotalFrames != 200 {
+		t.Errorf("Expected 200 total frames in the extended header; got %d", avi.ExtendedHeader.TotalFrames)
+	}
+	for streamIndex, superIndex := range avi.SuperIndexes {
+		if len(superIndex) != len(avi.Nodes) {
+			t.Errorf("Stream %d: expected %d super index entries; got %d", streamIndex, len(avi.Nodes), len(superIndex))
+		}
+	}
+
+	// The standard indexes should mark the keyframes.
+	keyframes := 0
+	for _, standardIndex := range avi.StandardIndexes {
+		if standardIndex.ChunkID != "00dc" {
+			continue
+		}
+		for _, entry := range standardIndex.Entries {
+			if entry.Size&AVIStandardIndexDeltaFrame == 0 {
+				keyframes++
+			}
+		}
+	}
+	if keyframes != 20 {
+		t.Errorf("Expected 20 keyframes; got %d", keyframes)
+	}
+}
+
+func TestValidateFindsProblems(t *testing.T) {
+	contents, _ := writeTestAVI(t, 20, 0)
+	avi, err := Read(bytes.NewReader(contents))
+	if err != nil {
+		t.Fatalf("Could not read the file: %v", err)
+	}
+	avi.Index[3].ChunkLength++
+	avi.Streams[0].Header.Length--
+	problems := avi.Validate()
+	if len(problems) != 2 {
+		t.Errorf("Expected 2 problems; got %d: %v", len(problems), problems)
+	}
+}
+
+func TestAVIWriterClosed(t *testing.T) {
+	writer, err := NewAVIWriter(&memoryWriteSeeker{}, testAVIFile())
+	if err != nil {
+		t.Fatalf("Could not create the writer: %v", err)
+	}
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("Could not close the writer: %v", err)
+	}
+	err = writer.AddChunk(0, Chunk{ID: "00dc", Data: []byte{1}})
+	if err == nil {
+		t.Errorf("Expected an error when adding a chunk to a closed writer")
+	}
+}
+
+// memoryWriteSeeker is an in-memory `io.WriteSeeker`.
+type memoryWriteSeeker struct {
+	data   []byte
+	offset int64
+}
+
+// Write implements `io.Writer`.
+func (m *memoryWriteSeeker) Write(p []byte) (int, error) {
+	if end := m.offset + int64(len(p)); end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+	copy(m.data[m.offset:], p)
+	m.offset += int64(len(p))
+	return len(p), nil
+}
+
+// Seek implements `io.Seeker`.
+func (m *memoryWriteSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += m.offset
+	case io.SeekEnd:
+		offset += int64(len(m.data))
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset: %d", offset)
+	}
+	m.offset = offset
+	return offset, nil
+}
@@ -0,0 +1,89 @@
+package rosco
+
+import "sort"
+
+// GapFactor is how many times longer than the median frame interval a pause
+// in the video needs to be before we call it a gap.
+const GapFactor = 2
+
+// Gap is a stretch of time with no video frames.
+type Gap struct {
+	Camera string // The camera (the first character of the stream ID).
+	Start  uint64 // The timestamp of the last frame before the gap.
+	End    uint64 // The timestamp of the first frame after the gap.
+}
+
+// Duration returns the length of the gap, in microseconds.
+func (g Gap) Duration() uint64 {
+	return g.End - g.Start
+}
+
+// MedianInterval returns the median interval between the given timestamps.
+//
+// The timestamps must be sorted.  If there are fewer than two timestamps, this returns 0.
+func MedianInterval(timestamps []uint64) uint64 {
+	if len(timestamps) < 2 {
+		return 0
+	}
+	intervals := make([]uint64, 0, len(timestamps)-1)
+	for i := 1; i < len(timestamps); i++ {
+		intervals = append(intervals, timestamps[i]-timestamps[i-1])
+	}
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i] < intervals[j]
+	})
+	return intervals[len(intervals)/2]
+}
+
+// VideoTimestamps returns the sorted video timestamps for the given camera.
+//
+// This combines the keyframe and delta-frame substreams.
+func (f *FileInfo) VideoTimestamps(camera string) []uint64 {
+	timestamps := []uint64{}
+	for _, chunk := range f.Chunks {
+		if chunk.Video == nil || len(chunk.ID) == 0 || chunk.ID[:1] != camera {
+			continue
+		}
+		timestamps = append(timestamps, chunk.Video.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+	return timestamps
+}
+
+// VideoGaps returns the gaps in the video for every camera.
+//
+// A gap is any interval between two frames that is more than `GapFactor` times the
+// median frame interval for that camera.
+func (f *FileInfo) VideoGaps() []Gap {
+	cameras := []string{}
+	cameraMap := map[string]bool{}
+	for _, chunk := range f.Chunks {
+		if chunk.Video == nil || len(chunk.ID) == 0 || cameraMap[chunk.ID[:1]] {
+			continue
+		}
+		cameraMap[chunk.ID[:1]] = true
+		cameras = append(cameras, chunk.ID[:1])
+	}
+	sort.Strings(cameras)
+
+	gaps := []Gap{}
+	for _, camera := range cameras {
+		timestamps := f.VideoTimestamps(camera)
+		median := MedianInterval(timestamps)
+		if median == 0 {
+			continue
+		}
+		for i := 1; i < len(timestamps); i++ {
+			if timestamps[i]-timestamps[i-1] > GapFactor*median {
+				gaps = append(gaps, Gap{
+					Camera: camera,
+					Start:  timestamps[i-1],
+					End:    timestamps[i],
+				})
+			}
+		}
+	}
+	return gaps
+}
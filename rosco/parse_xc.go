@@ -87,8 +87,13 @@ type XCEndPacket struct {
 }
 
 // ParseReaderXC parses a DVXC ASD file using a `bufio.Reader` instance.
-func ParseReaderXC(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
+//
+// The chunk offsets are relative to the current position of the reader.
+func ParseReaderXC(bufferedReader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
+	reader := newPositionReader(bufferedReader)
+
 	fileInfo := &FileInfo{
+		Format:   FormatXC,
 		Filename: "",
 		Metadata: &Metadata{
 			Entries: []MetadataEntry{
@@ -110,7 +115,7 @@ func ParseReaderXC(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 		return nil, fmt.Errorf("could not find the header packet")
 	}
 
-	headerPacket, err := parseXCHeaderPacket(reader)
+	headerPacket, err := parseXCHeaderPacket(reader.Reader)
 	if err != nil {
 		return nil, err
 	}
@@ -120,6 +125,7 @@ func ParseReaderXC(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 	fileInfo.Filename = fmt.Sprintf("rec-%s-%s-%s.asd", headerPacket.StartTime.Format("20060102"), headerPacket.StartTime.Format("150405"), headerPacket.EndTime.Format("150405"))
 
 	fileInfo.Metadata.Entries = append(fileInfo.Metadata.Entries, MetadataEntry{Type: MetadataTypeInt64, Name: "_duration", Value: headerPacket.EndTime.Unix() - headerPacket.StartTime.Unix()})
+	fileInfo.Metadata.Entries = append(fileInfo.Metadata.Entries, MetadataEntry{Type: MetadataTypeInt64, Name: MetadataKeyStartTime, Value: headerPacket.StartTime.UnixMicro()})
 
 	if headerOnly {
 		return fileInfo, nil
@@ -129,6 +135,7 @@ func ParseReaderXC(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 
 	done := false
 	for !done {
+		packetOffset := reader.Position()
 		packetType, err := reader.ReadByte()
 		if err == io.EOF {
 			break
@@ -140,28 +147,29 @@ func ParseReaderXC(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 		case XCHeaderPacketType:
 			return nil, fmt.Errorf("unexpected second file header")
 		case XCUnknown00PacketType:
-			packet, err := parseXCUnknown00Packet(reader)
+			packet, err := parseXCUnknown00Packet(reader.Reader)
 			if err != nil {
 				return nil, fmt.Errorf("could not parse XCUnknown00Packet: %v", err)
 			}
 			//spew.Dump(packet)
 			logrus.Debugf("Unknown00 packet: %v, %v", packet.SequenceNumber, packet.Timestamp)
 		case XCUnknown01PacketType:
-			packet, err := parseXCUnknown01Packet(reader)
+			packet, err := parseXCUnknown01Packet(reader.Reader)
 			if err != nil {
 				return nil, fmt.Errorf("could not parse XCUnknown01Packet: %v", err)
 			}
 			//spew.Dump(packet)
 			logrus.Debugf("Unknown01 packet: %v", packet.SequenceNumber)
 		case XCGPSPacketType:
-			packet, err := parseXCGPSPacket(reader)
+			packet, err := parseXCGPSPacket(reader.Reader)
 			if err != nil {
 				return nil, fmt.Errorf("could not parse XCGPSPacket: %v", err)
 			}
 			//spew.Dump(packet)
 			logrus.Debugf("GPS packet: (%f %c, %f %c) -> %d mph @ %v / %04d-%02d-%02d %02d:%02d:%02d", packet.Latitude, packet.LatitudeDirection, packet.Longitude, packet.LongitudeDirection, packet.Speed, packet.Timestamp, packet.Year, packet.Month, packet.Day, packet.Hour, packet.Minute, packet.Second)
+			fileInfo.GPSPackets = append(fileInfo.GPSPackets, packet)
 		case XCAudioPacketType:
-			packet, err := parseXCAudioPacket(reader)
+			packet, err := parseXCAudioPacket(reader.Reader)
 			if err != nil {
 				return nil, fmt.Errorf("could not parse XCAudioPacket: %v", err)
 			}
@@ -169,8 +177,9 @@ func ParseReaderXC(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 			logrus.Debugf("Audio packet: %d bytes (%v)", packet.PayloadSize, packet.Timestamp)
 
 			chunk := &Chunk{
-				ID:   "17",
-				Type: "wb",
+				ID:     "17",
+				Type:   "wb",
+				Offset: packetOffset,
 				Audio: &AudioChunk{
 					Timestamp: 0, // We'll set this later.
 					Media:     packet.Payload,
@@ -179,7 +188,7 @@ func ParseReaderXC(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 			fileInfo.Chunks = append(fileInfo.Chunks, chunk)
 			chunkTimestamps = append(chunkTimestamps, packet.Timestamp.UnixNano())
 		case XCVideoPacketType:
-			packet, err := parseXCVideoPacket(reader)
+			packet, err := parseXCVideoPacket(reader.Reader)
 			if err != nil {
 				return nil, fmt.Errorf("could not parse XCVideoPacket: %v", err)
 			}
@@ -187,17 +196,19 @@ func ParseReaderXC(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 			logrus.Debugf("Video packet: %d / %d: %d bytes (%v)", packet.StreamNumber, packet.StreamType, packet.PayloadSize, packet.Timestamp)
 
 			chunk := &Chunk{
-				ID:   fmt.Sprintf("%d%d", packet.StreamNumber, packet.StreamType),
-				Type: "dc",
+				ID:     fmt.Sprintf("%d%d", packet.StreamNumber, packet.StreamType),
+				Type:   "dc",
+				Offset: packetOffset,
 				Video: &VideoChunk{
-					Timestamp: 0, // We'll set this later.
-					Media:     packet.Payload,
+					Timestamp:   0, // We'll set this later.
+					Media:       packet.Payload,
+					MediaOffset: reader.Position() - int64(len(packet.Payload)), // The payload is at the end of the packet.
 				},
 			}
 			fileInfo.Chunks = append(fileInfo.Chunks, chunk)
 			chunkTimestamps = append(chunkTimestamps, packet.Timestamp.UnixNano())
 		case XCEndPacketType:
-			packet, err := parseXCEndPacket(reader)
+			packet, err := parseXCEndPacket(reader.Reader)
 			if err != nil {
 				return nil, fmt.Errorf("could not parse XCEndPacket: %v", err)
 			}
@@ -216,6 +227,10 @@ func ParseReaderXC(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 		}
 	}
 
+	if len(chunkTimestamps) > 0 {
+		fileInfo.Metadata.Entries = append(fileInfo.Metadata.Entries, MetadataEntry{Type: MetadataTypeInt64, Name: MetadataKeyTimestampOrigin, Value: smallestTimestamp / 1000})
+	}
+
 	for i, chunk := range fileInfo.Chunks {
 		normalizedTimestamp := chunkTimestamps[i] - smallestTimestamp
 		if chunk.Audio != nil {
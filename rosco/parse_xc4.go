@@ -27,8 +27,19 @@ const (
 	MetadataType10      int8 = 0x10 // 32-bit integer?
 )
 
+// xc4Version1Point6 is the version at which we will assume that the audio format changed.
+//
+// Note that I do not have any proof of this other than two data points: in v1.0.0, audio is
+// encoded as separate left and right channels (and the length is wrong); in v1.6.5, audio is
+// encoded as a single mono channel (and the length is correct).
+var xc4Version1Point6 = version.Must(version.NewVersion("v1.6.0"))
+
 // ParseReaderXC4 parses a DVXC4 NVR file using an `io.Reader` instance.
-func ParseReaderXC4(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
+//
+// The chunk offsets are relative to the current position of the reader.
+func ParseReaderXC4(bufferedReader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
+	reader := newPositionReader(bufferedReader)
+
 	buffer := make([]byte, HeaderSize)
 	_, err := io.ReadFull(reader, buffer)
 	if err != nil {
@@ -44,13 +55,31 @@ func ParseReaderXC4(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 		return fileInfo, nil
 	}
 
-	// This is the version at which we will assume that the audio format changed.
-	// Note that I do not have any proof of this other than the following two
-	// data points:
-	//    v1.0.0: Audio is encoded as separate left and right channels; length is wrong.
-	//    v1.6.5: Audio is encoded as a single mono channel; length is correct.
-	Version1Point6 := version.Must(version.NewVersion("v1.6.0"))
+	fileVersion := xc4FileVersion(fileInfo)
+	logger.Infof("File version: %v", fileVersion)
+
+	for i := 0; ; i++ {
+		_, err = reader.Peek(4)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not peek the chunk info for chunk %d: %v", i, err)
+		}
+
+		chunk, err := parseXC4Chunk(reader, i, fileVersion)
+		if err != nil {
+			return nil, err
+		}
+		fileInfo.Chunks = append(fileInfo.Chunks, chunk)
+	}
 
+	return fileInfo, nil
+}
+
+// xc4FileVersion returns the version of the software that wrote the file, from the "appVersion"
+// metadata entry; this is nil if it is unknown.
+func xc4FileVersion(fileInfo *FileInfo) *version.Version {
 	var fileVersion *version.Version
 	if fileInfo.Metadata != nil {
 		versionString := ""
@@ -65,216 +94,226 @@ func ParseReaderXC4(reader *bufio.Reader, headerOnly bool) (*FileInfo, error) {
 			}
 		}
 		if versionString != "" {
+			var err error
 			fileVersion, err = version.NewVersion(versionString)
 			if err != nil {
 				logger.Warnf("Could not parse version string %q: %v", versionString, err)
 			}
 		}
 	}
-	logger.Infof("File version: %v", fileVersion)
+	return fileVersion
+}
 
-	for i := 0; ; i++ {
-		buffer, err = reader.Peek(4)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("could not peek the chunk info for chunk %d: %v", i, err)
-		}
+// parseXC4Chunk parses the chunk at the current position of the reader.
+//
+// The index is only used for logging and errors.
+func parseXC4Chunk(reader *positionReader, i int, fileVersion *version.Version) (*Chunk, error) {
+	buffer, err := reader.Peek(4)
+	if err != nil {
+		return nil, fmt.Errorf("could not peek the chunk info for chunk %d: %v", i, err)
+	}
 
-		chunk := &Chunk{
-			ID:   string(buffer[0:2]),
-			Type: string(buffer[2:4]),
-		}
-		// If this is a JFIF chunk, then create some meaningful labels.
-		// Since Rosco just dumps a raw JFIF in there, the first few bytes are binary and not at all descriptive.
-		if chunk.ID == "\xff\xd8" {
-			chunk.ID = "images"
-			chunk.Type = "jfif"
+	chunk := &Chunk{
+		ID:     string(buffer[0:2]),
+		Type:   string(buffer[2:4]),
+		Offset: reader.Position(),
+	}
+	// If this is a JFIF chunk, then create some meaningful labels.
+	// Since Rosco just dumps a raw JFIF in there, the first few bytes are binary and not at all descriptive.
+	if chunk.ID == "\xff\xd8" {
+		chunk.ID = "images"
+		chunk.Type = "jfif"
+	}
+	logger.Debugf("Chunk[%d]: %s / %s [%x]", i, chunk.ID, chunk.Type, []byte(chunk.ID+chunk.Type))
+
+	switch chunk.Type {
+	case "dc":
+		// We already peeked at these, so read them for real.
+		buffer = make([]byte, 4)
+		_, err = io.ReadFull(reader, buffer)
+		if err != nil {
+			return nil, fmt.Errorf("could not actually read the first 4 bytes of chunk %d: %v", i, err)
 		}
-		logger.Debugf("Chunk[%d]: %s / %s [%x]", i, chunk.ID, chunk.Type, []byte(chunk.ID+chunk.Type))
 
-		switch chunk.Type {
-		case "dc":
-			// We already peeked at these, so read them for real.
-			buffer = make([]byte, 4)
-			_, err = io.ReadFull(reader, buffer)
-			if err != nil {
-				return nil, fmt.Errorf("could not actually read the first 4 bytes of chunk %d: %v", i, err)
-			}
+		chunk.Video = new(VideoChunk)
 
-			chunk.Video = new(VideoChunk)
+		buffer = make([]byte, 4)
+		_, err = io.ReadFull(reader, buffer)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the codec for chunk %d: %v", i, err)
+		}
+		chunk.Video.Codec = string(buffer)
 
-			buffer = make([]byte, 4)
-			_, err = io.ReadFull(reader, buffer)
-			if err != nil {
-				return nil, fmt.Errorf("could not read the codec for chunk %d: %v", i, err)
-			}
-			chunk.Video.Codec = string(buffer)
+		logger.Debugf("Codec: %s", chunk.Video.Codec)
 
-			logger.Debugf("Codec: %s", chunk.Video.Codec)
+		var mediaLength int32
+		err = binary.Read(reader, binary.LittleEndian, &mediaLength)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the media length for chunk %d: %v", i, err)
+		}
+		if mediaLength < 0 {
+			return nil, fmt.Errorf("invalid media length for chunk %d: %d", i, mediaLength)
+		}
 
-			var mediaLength int32
-			err = binary.Read(reader, binary.LittleEndian, &mediaLength)
-			if err != nil {
-				return nil, fmt.Errorf("could not read the media length for chunk %d: %v", i, err)
-			}
+		logger.Debugf("Media length: %d", mediaLength)
 
-			logger.Debugf("Media length: %d", mediaLength)
+		var metadataLengthSmall int16
+		err = binary.Read(reader, binary.LittleEndian, &metadataLengthSmall)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the (small) metadata length for chunk %d: %v", i, err)
+		}
 
-			var metadataLengthSmall int16
-			err = binary.Read(reader, binary.LittleEndian, &metadataLengthSmall)
-			if err != nil {
-				return nil, fmt.Errorf("could not read the (small) metadata length for chunk %d: %v", i, err)
-			}
+		logger.Debugf("(Small) metadata length: %d", metadataLengthSmall)
 
-			logger.Debugf("(Small) metadata length: %d", metadataLengthSmall)
+		chunk.Video.Unknown1 = make([]byte, 2)
+		err = binary.Read(reader, binary.LittleEndian, &chunk.Video.Unknown1)
+		if err != nil {
+			return nil, fmt.Errorf("could not read unknown1 for chunk %d: %v", i, err)
+		}
 
-			chunk.Video.Unknown1 = make([]byte, 2)
-			err = binary.Read(reader, binary.LittleEndian, &chunk.Video.Unknown1)
-			if err != nil {
-				return nil, fmt.Errorf("could not read unknown1 for chunk %d: %v", i, err)
-			}
+		logger.Debugf("Unknown1: %d", chunk.Video.Unknown1)
 
-			logger.Debugf("Unknown1: %d", chunk.Video.Unknown1)
+		err = binary.Read(reader, binary.LittleEndian, &chunk.Video.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("could not read timestamp for chunk %d: %v", i, err)
+		}
 
-			err = binary.Read(reader, binary.LittleEndian, &chunk.Video.Timestamp)
-			if err != nil {
-				return nil, fmt.Errorf("could not read timestamp for chunk %d: %v", i, err)
-			}
+		logger.Debugf("Timestamp: %d", chunk.Video.Timestamp)
 
-			logger.Debugf("Timestamp: %d", chunk.Video.Timestamp)
+		var metadataLength int32
+		err = binary.Read(reader, binary.LittleEndian, &metadataLength)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the (small) metadata length for chunk %d: %v", i, err)
+		}
 
-			var metadataLength int32
-			err = binary.Read(reader, binary.LittleEndian, &metadataLength)
-			if err != nil {
-				return nil, fmt.Errorf("could not read the (small) metadata length for chunk %d: %v", i, err)
-			}
+		logger.Debugf("Metadata length: %d", metadataLength)
+		if metadataLength < 4 {
+			return nil, fmt.Errorf("invalid metadata length for chunk %d: %d", i, metadataLength)
+		}
 
-			logger.Debugf("Metadata length: %d", metadataLength)
+		metadataLength -= 4
 
-			metadataLength -= 4
+		buffer = make([]byte, metadataLength)
+		_, err = io.ReadFull(reader, buffer)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the metadata buffer: %v", err)
+		}
 
-			buffer = make([]byte, metadataLength)
-			_, err = io.ReadFull(reader, buffer)
-			if err != nil {
-				return nil, fmt.Errorf("could not read the metadata buffer: %v", err)
-			}
+		chunk.Video.Metadata, err = parseXC4Metadata(bytes.NewReader(buffer), false)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the metadata: %v", err)
+		}
 
-			chunk.Video.Metadata, err = parseXC4Metadata(bytes.NewReader(buffer), false)
-			if err != nil {
-				return nil, fmt.Errorf("could not parse the metadata: %v", err)
-			}
+		originalMediaLength := mediaLength
+		for mediaLength%8 != 0 {
+			mediaLength++
+		}
 
-			originalMediaLength := mediaLength
-			for mediaLength%8 != 0 {
-				mediaLength++
-			}
+		chunk.Video.MediaOffset = reader.Position()
+		buffer = make([]byte, mediaLength)
+		_, err = io.ReadFull(reader, buffer)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the media buffer: %v", err)
+		}
+		chunk.Video.Media = buffer[0:originalMediaLength]
+	case "wb":
+		// We already peeked at these, so read them for real.
+		buffer = make([]byte, 4)
+		_, err = io.ReadFull(reader, buffer)
+		if err != nil {
+			return nil, fmt.Errorf("could not actually read the first 4 bytes of chunk %d: %v", i, err)
+		}
 
-			buffer = make([]byte, mediaLength)
-			_, err = io.ReadFull(reader, buffer)
-			if err != nil {
-				return nil, fmt.Errorf("could not read the media buffer: %v", err)
-			}
-			chunk.Video.Media = buffer[0:originalMediaLength]
-		case "wb":
-			// We already peeked at these, so read them for real.
-			buffer = make([]byte, 4)
-			_, err = io.ReadFull(reader, buffer)
-			if err != nil {
-				return nil, fmt.Errorf("could not actually read the first 4 bytes of chunk %d: %v", i, err)
-			}
+		chunk.Audio = new(AudioChunk)
 
-			chunk.Audio = new(AudioChunk)
+		var audioChannelLength int16
+		err = binary.Read(reader, binary.LittleEndian, &audioChannelLength)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the audio channel length for chunk %d: %v", i, err)
+		}
+		logger.Debugf("Audio channel length: %d", audioChannelLength)
+		if audioChannelLength < 0 {
+			return nil, fmt.Errorf("invalid audio channel length for chunk %d: %d", i, audioChannelLength)
+		}
 
-			var audioChannelLength int16
-			err = binary.Read(reader, binary.LittleEndian, &audioChannelLength)
-			if err != nil {
-				return nil, fmt.Errorf("could not read the audio channel length for chunk %d: %v", i, err)
-			}
-			logger.Debugf("Audio channel length: %d", audioChannelLength)
+		var firstAudioChannelLength int16
+		err = binary.Read(reader, binary.LittleEndian, &firstAudioChannelLength)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the first audio channel length for chunk %d: %v", i, err)
+		}
+		logger.Debugf("First audio channel length: %d", firstAudioChannelLength)
 
-			var firstAudioChannelLength int16
-			err = binary.Read(reader, binary.LittleEndian, &firstAudioChannelLength)
-			if err != nil {
-				return nil, fmt.Errorf("could not read the first audio channel length for chunk %d: %v", i, err)
-			}
-			logger.Debugf("First audio channel length: %d", firstAudioChannelLength)
+		err = binary.Read(reader, binary.LittleEndian, &chunk.Audio.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the timestamp for chunk %d: %v", i, err)
+		}
 
-			err = binary.Read(reader, binary.LittleEndian, &chunk.Audio.Timestamp)
-			if err != nil {
-				return nil, fmt.Errorf("could not read the timestamp for chunk %d: %v", i, err)
-			}
+		logger.Debugf("Timestamp: %d", chunk.Audio.Timestamp)
 
-			logger.Debugf("Timestamp: %d", chunk.Audio.Timestamp)
+		buffer = make([]byte, audioChannelLength)
+		_, err = io.ReadFull(reader, buffer)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the media buffer: %v", err)
+		}
+		chunk.Audio.Media = buffer
 
+		if fileVersion != nil && fileVersion.LessThan(xc4Version1Point6) {
+			logger.Debugf("Reading another %d bytes (second channel)", audioChannelLength)
 			buffer = make([]byte, audioChannelLength)
 			_, err = io.ReadFull(reader, buffer)
 			if err != nil {
 				return nil, fmt.Errorf("could not read the media buffer: %v", err)
 			}
-			chunk.Audio.Media = buffer
-
-			if fileVersion != nil && fileVersion.LessThan(Version1Point6) {
-				logger.Debugf("Reading another %d bytes (second channel)", audioChannelLength)
-				buffer = make([]byte, audioChannelLength)
-				_, err = io.ReadFull(reader, buffer)
-				if err != nil {
-					return nil, fmt.Errorf("could not read the media buffer: %v", err)
-				}
-				chunk.Audio.ExtraMedia = buffer
-			}
-		case "jfif":
-			// Read the JPEG data from the stream.
-			jpegBuffer, err := ScanJPEG(reader)
+			chunk.Audio.ExtraMedia = buffer
+		}
+	case "jfif":
+		// Read the JPEG data from the stream.
+		jpegBuffer, err := ScanJPEG(reader)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan the image: %v", err)
+		}
+		// Parse the image.
+		img, err := jpeg.Decode(bytes.NewReader(jpegBuffer))
+		if err != nil {
+			return nil, fmt.Errorf("could not read the image: %v", err)
+		}
+		logger.Infof("Image bounds: %v", img.Bounds())
+		chunk.Image = img
+
+		// Read through any zero bytes.
+		for {
+			peekBytes, err := reader.Peek(1)
 			if err != nil {
-				return nil, fmt.Errorf("could not scan the image: %v", err)
+				break
 			}
-			// Parse the image.
-			img, err := jpeg.Decode(bytes.NewReader(jpegBuffer))
-			if err != nil {
-				return nil, fmt.Errorf("could not read the image: %v", err)
+			if len(peekBytes) == 0 {
+				break
 			}
-			logger.Infof("Image bounds: %v", img.Bounds())
-			chunk.Image = img
-
-			// Read through any zero bytes.
-			for {
-				peekBytes, err := reader.Peek(1)
-				if err != nil {
-					break
-				}
-				if len(peekBytes) == 0 {
-					break
-				}
-				if peekBytes[0] != 0 {
-					break
-				}
-				zeroBuffer := make([]byte, 1)
-				reader.Read(zeroBuffer)
+			if peekBytes[0] != 0 {
+				break
 			}
-		default:
-			// Attempt to read more data to provide context.
-			{
-				buffer := make([]byte, 2000)
-				readBytes, _ := io.ReadFull(reader, buffer)
-				if readBytes > 0 {
-					out := &bytes.Buffer{}
-					hexline.Write(out, bytes.NewReader(buffer), int64(readBytes), 80)
-
-					logger.Debugf("Next %d bytes:", readBytes)
-					for _, line := range strings.Split(out.String(), "\n") {
-						logger.Debugf("%s", line)
-					}
+			zeroBuffer := make([]byte, 1)
+			reader.Read(zeroBuffer)
+		}
+	default:
+		// Attempt to read more data to provide context.
+		{
+			buffer := make([]byte, 2000)
+			readBytes, _ := io.ReadFull(reader, buffer)
+			if readBytes > 0 {
+				out := &bytes.Buffer{}
+				hexline.Write(out, bytes.NewReader(buffer), int64(readBytes), 80)
+
+				logger.Debugf("Next %d bytes:", readBytes)
+				for _, line := range strings.Split(out.String(), "\n") {
+					logger.Debugf("%s", line)
 				}
 			}
-			return nil, fmt.Errorf("unknown chunk type for chunk %d: %v", i, chunk.Type)
 		}
-
-		fileInfo.Chunks = append(fileInfo.Chunks, chunk)
+		return nil, fmt.Errorf("unknown chunk type for chunk %d: %v", i, chunk.Type)
 	}
 
-	return fileInfo, nil
+	return chunk, nil
 }
 
 func parseXC4FileHeader(reader io.Reader) (*FileInfo, error) {
@@ -286,7 +325,9 @@ func parseXC4FileHeader(reader io.Reader) (*FileInfo, error) {
 
 	switch string(buffer) {
 	case "SAYS":
-		fileInfo := &FileInfo{}
+		fileInfo := &FileInfo{
+			Format: FormatXC4,
+		}
 
 		buffer = make([]byte, 32)
 		_, err = io.ReadFull(reader, buffer)
@@ -378,6 +419,9 @@ func parseXC4Metadata(reader *bytes.Reader, inFileHeader bool) (*Metadata, error
 			if err != nil {
 				return nil, fmt.Errorf("could not read the value length on entry %d: %v", i, err)
 			}
+			if length < 0 || int64(length) > int64(reader.Len()) {
+				return nil, fmt.Errorf("invalid value length on entry %d: %d", i, length)
+			}
 
 			buffer := make([]byte, length)
 			_, err = reader.Read(buffer)
@@ -398,6 +442,9 @@ func parseXC4Metadata(reader *bytes.Reader, inFileHeader bool) (*Metadata, error
 			if err != nil {
 				return nil, fmt.Errorf("could not read the value length on entry %d: %v", i, err)
 			}
+			if length < 4 || int64(length-4) > int64(reader.Len()) {
+				return nil, fmt.Errorf("invalid value length on entry %d: %d", i, length)
+			}
 
 			buffer := make([]byte, length-4)
 			_, err = reader.Read(buffer)
@@ -0,0 +1,41 @@
+package rosco
+
+import (
+	"bufio"
+	"io"
+)
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+// Read implements `io.Reader`.
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// positionReader is a buffered reader that knows its position in the underlying reader.
+//
+// The position is relative to wherever the underlying reader was when this was created.
+type positionReader struct {
+	*bufio.Reader
+	counter *countingReader
+}
+
+// newPositionReader creates a new `positionReader`.
+func newPositionReader(reader io.Reader) *positionReader {
+	counter := &countingReader{reader: reader}
+	return &positionReader{
+		Reader:  bufio.NewReader(counter),
+		counter: counter,
+	}
+}
+
+// Position returns the number of bytes consumed so far.
+func (r *positionReader) Position() int64 {
+	return r.counter.count - int64(r.Buffered())
+}
@@ -0,0 +1,288 @@
+package rosco
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// The offsets of the timestamps within the DVXC4 chunks.
+const (
+	xc4VideoTimestampOffset = 16
+	xc4AudioTimestampOffset = 8
+)
+
+// XC4Salvage is everything that could be recovered from a damaged DVXC4 (".nvr") file.
+type XC4Salvage struct {
+	Header         []byte // The raw file header.
+	Info           *FileInfo
+	DamagedRegions int   // The number of runs of bytes that could not be read as chunks.
+	DamagedBytes   int64 // The total size of those runs.
+
+	raw map[*Chunk][]byte // The raw bytes of each chunk.
+}
+
+// SalvageXC4 reads every valid chunk from a DVXC4 file, even if it is truncated or partially overwritten.
+//
+// A chunk is only kept if it can be read and it is followed by another chunk, padding, or the end of the
+// file.  Otherwise, the bytes are skipped until the start of the next chunk that can be kept.  The header
+// itself has to be intact, since that is where the filename and the software version are.
+func SalvageXC4(data []byte) (*XC4Salvage, error) {
+	if len(data) < HeaderSize {
+		return nil, fmt.Errorf("the file is too short to have a header (%d bytes)", len(data))
+	}
+	fileInfo, err := parseXC4FileHeader(bytes.NewReader(data[0:HeaderSize]))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse header: %v", err)
+	}
+	fileVersion := xc4FileVersion(fileInfo)
+	logger.Infof("File version: %v", fileVersion)
+
+	salvage := &XC4Salvage{
+		Header: data[0:HeaderSize],
+		Info:   fileInfo,
+		raw:    map[*Chunk][]byte{},
+	}
+
+	// readChunk reads the chunk at the given offset and returns it along with the offset just past it.
+	readChunk := func(offset int) (*Chunk, int, bool) {
+		if !plausibleXC4Chunk(data[offset:], true) {
+			return nil, 0, false
+		}
+		reader := newPositionReader(bytes.NewReader(data[offset:]))
+		chunk, err := parseXC4Chunk(reader, len(fileInfo.Chunks), fileVersion)
+		if err != nil {
+			logger.Debugf("Could not read a chunk at offset %d: %v", offset, err)
+			return nil, 0, false
+		}
+		chunk.Offset += int64(offset)
+		if chunk.Video != nil {
+			chunk.Video.MediaOffset += int64(offset)
+		}
+		return chunk, offset + int(reader.Position()), true
+	}
+
+	// readGoodChunk reads the chunk at the given offset, but only if it is followed by something that
+	// makes sense (the start of another chunk, even a truncated one; padding; or the end of the file).
+	// This catches chunks with damaged lengths, as well as chunk-like bytes in the middle of the media.
+	readGoodChunk := func(offset int) (*Chunk, int, bool) {
+		chunk, end, okay := readChunk(offset)
+		if !okay || (end < len(data) && data[end] != 0 && !plausibleXC4Chunk(data[end:], false)) {
+			return nil, 0, false
+		}
+		return chunk, end, true
+	}
+
+	offset := HeaderSize
+	for offset < len(data) {
+		chunk, end, okay := readGoodChunk(offset)
+		if !okay {
+			// Find the next chunk that we can read.
+			start := offset
+			for offset++; offset < len(data); offset++ {
+				chunk, end, okay = readGoodChunk(offset)
+				if okay {
+					break
+				}
+			}
+			logger.Warnf("Skipping %d damaged bytes at offset %d.", offset-start, start)
+			salvage.DamagedRegions++
+			salvage.DamagedBytes += int64(offset - start)
+			if !okay {
+				break
+			}
+		}
+
+		fileInfo.Chunks = append(fileInfo.Chunks, chunk)
+		salvage.raw[chunk] = data[offset:end]
+		offset = end
+	}
+
+	return salvage, nil
+}
+
+// plausibleXC4Chunk returns true if the data starts with something that looks like a chunk header.
+//
+// If `complete` is true, then the lengths in the header must also fit in the data.
+func plausibleXC4Chunk(data []byte, complete bool) bool {
+	if len(data) < 4 {
+		return false
+	}
+	if data[0] == 0xff && data[1] == 0xd8 && data[2] == 0xff {
+		return true // JFIF image.
+	}
+	if data[0] < '0' || data[0] > '9' || data[1] < '0' || data[1] > '9' {
+		return false
+	}
+	switch string(data[2:4]) {
+	case "dc":
+		if len(data) < 28 {
+			return !complete
+		}
+		for _, b := range data[4:8] {
+			if !(b >= '0' && b <= '9' || b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z') {
+				return false
+			}
+		}
+		mediaLength := int64(int32(binary.LittleEndian.Uint32(data[8:12])))
+		metadataLength := int64(int32(binary.LittleEndian.Uint32(data[24:28])))
+		if mediaLength < 0 || metadataLength < 4 {
+			return false
+		}
+		return !complete || 28+(metadataLength-4)+mediaLength <= int64(len(data))
+	case "wb":
+		if len(data) < 16 {
+			return !complete
+		}
+		audioChannelLength := int64(int16(binary.LittleEndian.Uint16(data[4:6])))
+		if audioChannelLength <= 0 {
+			return false
+		}
+		return !complete || 16+audioChannelLength <= int64(len(data))
+	}
+	return false
+}
+
+// DropOrphanFrames removes the delta frames from each camera that come before its first keyframe,
+// since they can't be decoded.
+//
+// This returns the number of frames that were removed.
+func (s *XC4Salvage) DropOrphanFrames() int {
+	haveKeyframe := map[string]bool{}
+	chunks := []*Chunk{}
+	dropped := 0
+	for _, chunk := range s.Info.Chunks {
+		if chunk.Video != nil {
+			camera := chunk.ID[0:1]
+			if chunk.ID[1:2] == "0" {
+				haveKeyframe[camera] = true
+			} else if !haveKeyframe[camera] {
+				logger.Debugf("Dropping orphan frame from stream %s at timestamp %d.", chunk.ID, chunk.Video.Timestamp)
+				dropped++
+				continue
+			}
+		}
+		chunks = append(chunks, chunk)
+	}
+	s.Info.Chunks = chunks
+	return dropped
+}
+
+// FixTimestamps makes the timestamps of each camera's video (and of each audio stream) increase.
+//
+// A timestamp is replaced if it is not after the one before it, or if it is a spike (after both the
+// one before it and the one after it, when those two are in order).  The new timestamp is one typical
+// interval after the one before it.
+//
+// This returns the number of timestamps that were changed.
+func (s *XC4Salvage) FixTimestamps() int {
+	timelines := map[string][]*uint64{}
+	names := []string{}
+	for _, chunk := range s.Info.Chunks {
+		var name string
+		var timestamp *uint64
+		switch {
+		case chunk.Video != nil:
+			name = "video " + chunk.ID[0:1]
+			timestamp = &chunk.Video.Timestamp
+		case chunk.Audio != nil:
+			name = "audio " + chunk.ID
+			timestamp = &chunk.Audio.Timestamp
+		default:
+			continue
+		}
+		if _, okay := timelines[name]; !okay {
+			names = append(names, name)
+		}
+		timelines[name] = append(timelines[name], timestamp)
+	}
+
+	fixed := 0
+	for _, name := range names {
+		count := fixTimeline(timelines[name])
+		if count > 0 {
+			logger.Debugf("Fixed %d timestamps in the %s timeline.", count, name)
+		}
+		fixed += count
+	}
+	return fixed
+}
+
+// fixTimeline makes the timestamps increase; see `FixTimestamps`.
+func fixTimeline(timestamps []*uint64) int {
+	// Use the median of the intervals that are in order.
+	intervals := []uint64{}
+	for i := 1; i < len(timestamps); i++ {
+		if *timestamps[i] > *timestamps[i-1] {
+			intervals = append(intervals, *timestamps[i]-*timestamps[i-1])
+		}
+	}
+	interval := uint64(1)
+	if len(intervals) > 0 {
+		sort.Slice(intervals, func(i, j int) bool {
+			return intervals[i] < intervals[j]
+		})
+		interval = intervals[len(intervals)/2]
+	}
+
+	fixed := 0
+	for i, timestamp := range timestamps {
+		var next *uint64
+		if i+1 < len(timestamps) {
+			next = timestamps[i+1]
+		}
+		if i == 0 {
+			// The first timestamp can only be a spike.
+			if next != nil && *timestamp > *next && (i+2 >= len(timestamps) || *next < *timestamps[i+2]) {
+				if *next > interval {
+					*timestamp = *next - interval
+				} else {
+					*timestamp = 0
+				}
+				fixed++
+			}
+			continue
+		}
+
+		previous := *timestamps[i-1]
+		bad := *timestamp <= previous
+		if !bad && next != nil && *timestamp > *next && *next > previous {
+			bad = true
+		}
+		if !bad {
+			continue
+		}
+		*timestamp = previous + interval
+		if next != nil && *next > previous && *timestamp >= *next {
+			*timestamp = previous + (*next-previous)/2
+		}
+		fixed++
+	}
+	return fixed
+}
+
+// Write writes the salvaged chunks as a new DVXC4 file, with the original header.
+//
+// The chunks are written as they were in the original file, except for their timestamps.
+func (s *XC4Salvage) Write(writer io.Writer) error {
+	_, err := writer.Write(s.Header)
+	if err != nil {
+		return fmt.Errorf("could not write the header: %v", err)
+	}
+	for i, chunk := range s.Info.Chunks {
+		raw := append([]byte{}, s.raw[chunk]...)
+		switch {
+		case chunk.Video != nil:
+			binary.LittleEndian.PutUint64(raw[xc4VideoTimestampOffset:], chunk.Video.Timestamp)
+		case chunk.Audio != nil:
+			binary.LittleEndian.PutUint64(raw[xc4AudioTimestampOffset:], chunk.Audio.Timestamp)
+		}
+		_, err = writer.Write(raw)
+		if err != nil {
+			return fmt.Errorf("could not write chunk %d: %v", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package rosco
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// TelemetryStreamGPS is the stream ID used for telemetry from the GPS packets in a DVXC file.
+const TelemetryStreamGPS = "gps"
+
+// Telemetry is what the camera recorded about the vehicle at a point in the recording.
+type Telemetry struct {
+	StreamID    string    // The stream of the video frame that it came from, or `TelemetryStreamGPS`.
+	Timestamp   uint64    // In microseconds, in the same units as the chunk timestamps.
+	Time        time.Time // The wall-clock time (zero if unknown).
+	Speed       float64   // In miles per hour.
+	HasSpeed    bool
+	Latitude    float64 // In degrees; negative is south.
+	Longitude   float64 // In degrees; negative is west.
+	HasPosition bool
+	Metadata    *Metadata // Everything that was recorded with it.
+}
+
+// Telemetry returns the telemetry from the recording, in timestamp order.
+//
+// For DVXC4 (NVR) files, this comes from the metadata on each video frame ("ts", "speed", and
+// "gps.lat" and "gps.lon"), so there is one entry per video frame.  For DVXC (ASD) files, this
+// comes from the GPS packets, and the metadata is built from the packet fields using the same names.
+func (f *FileInfo) Telemetry() []Telemetry {
+	telemetry := []Telemetry{}
+	for _, chunk := range f.Chunks {
+		if chunk.Video == nil || chunk.Video.Metadata == nil || len(chunk.Video.Metadata.Entries) == 0 {
+			continue
+		}
+		telemetry = append(telemetry, f.telemetryFromMetadata(chunk.ID, chunk.Video.Timestamp, chunk.Video.Metadata))
+	}
+
+	origin, haveOrigin := f.TimestampOrigin()
+	for _, packet := range f.GPSPackets {
+		var timestamp uint64
+		if haveOrigin && packet.Timestamp.After(origin) {
+			timestamp = uint64(packet.Timestamp.Sub(origin).Microseconds())
+		}
+		telemetry = append(telemetry, f.telemetryFromMetadata(TelemetryStreamGPS, timestamp, packet.Metadata()))
+	}
+
+	sort.SliceStable(telemetry, func(i, j int) bool {
+		return telemetry[i].Timestamp < telemetry[j].Timestamp
+	})
+	return telemetry
+}
+
+// telemetryFromMetadata pulls the time, speed, and position out of the metadata.
+func (f *FileInfo) telemetryFromMetadata(streamID string, timestamp uint64, metadata *Metadata) Telemetry {
+	t := Telemetry{
+		StreamID:  streamID,
+		Timestamp: timestamp,
+		Metadata:  metadata,
+	}
+	if entry := metadata.Entry("ts"); entry != nil {
+		if value, okay := MetadataInt64(entry.Value); okay {
+			t.Time = time.UnixMilli(value)
+		}
+	}
+	if t.Time.IsZero() {
+		t.Time, _ = f.Time(timestamp)
+	}
+	if entry := metadata.Entry("speed"); entry != nil {
+		t.Speed, t.HasSpeed = MetadataFloat64(entry.Value)
+	}
+	if entry := metadata.Entry("gps"); entry != nil {
+		if gps, okay := entry.Value.(*Metadata); okay {
+			latitude, haveLatitude := metadataFloat64Entry(gps, "lat", "latitude")
+			longitude, haveLongitude := metadataFloat64Entry(gps, "lon", "lng", "longitude")
+			// A position of exactly 0, 0 means that there was no fix.
+			if haveLatitude && haveLongitude && (latitude != 0 || longitude != 0) {
+				t.Latitude = latitude
+				t.Longitude = longitude
+				t.HasPosition = true
+			}
+		}
+	}
+	return t
+}
+
+// metadataFloat64Entry returns the value of the first of the named entries that is present.
+func metadataFloat64Entry(metadata *Metadata, names ...string) (float64, bool) {
+	for _, name := range names {
+		if entry := metadata.Entry(name); entry != nil {
+			return MetadataFloat64(entry.Value)
+		}
+	}
+	return 0, false
+}
+
+// MetadataFloat64 converts a numeric metadata value into a float64.
+func MetadataFloat64(value interface{}) (float64, bool) {
+	if v, okay := value.(float64); okay {
+		return v, true
+	}
+	if v, okay := MetadataInt64(value); okay {
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// Metadata returns the packet fields as metadata, using the same names as the DVXC4 frame metadata
+// ("ts", "speed", and "gps.lat" and "gps.lon").  The latitude and longitude are in signed degrees.
+func (p *XCGPSPacket) Metadata() *Metadata {
+	latitude := gpsDegrees(p.Latitude)
+	if p.LatitudeDirection == 'S' {
+		latitude = -latitude
+	}
+	longitude := gpsDegrees(p.Longitude)
+	if p.LongitudeDirection == 'W' {
+		longitude = -longitude
+	}
+	return &Metadata{
+		Entries: []MetadataEntry{
+			{Type: MetadataTypeInt64, Name: "ts", Value: p.Timestamp.UnixMilli()},
+			{Type: MetadataTypeInt64, Name: "sequenceNumber", Value: int64(p.SequenceNumber)},
+			{Type: MetadataTypeInt64, Name: "speed", Value: int64(p.Speed)},
+			{Type: MetadataType4, Name: "gps", Value: &Metadata{
+				Entries: []MetadataEntry{
+					{Type: MetadataTypeFloat64, Name: "lat", Value: latitude},
+					{Type: MetadataTypeFloat64, Name: "lon", Value: longitude},
+				},
+			}},
+		},
+	}
+}
+
+// gpsDegrees converts a GPS coordinate from the NMEA "ddmm.mmmm" form (as in the GPS packets) into degrees.
+//
+// The form can't be worked out from the value, since (for example) 0°30' is "00030.0".
+func gpsDegrees(value float64) float64 {
+	value = math.Abs(value)
+	degrees := math.Floor(value / 100)
+	return degrees + (value-degrees*100)/60
+}
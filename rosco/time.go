@@ -0,0 +1,131 @@
+package rosco
+
+import (
+	"regexp"
+	"time"
+)
+
+// Metadata keys that we synthesize while parsing.
+const (
+	// MetadataKeyStartTime is the recording start time, in microseconds since the Unix epoch.
+	MetadataKeyStartTime = "_startTime"
+	// MetadataKeyTimestampOrigin is the wall-clock time of chunk timestamp 0, in microseconds since the Unix epoch.
+	MetadataKeyTimestampOrigin = "_timestampOrigin"
+)
+
+// TimestampOrigin returns the wall-clock time that corresponds to a chunk timestamp of 0.
+//
+// For DVXC files, this comes from the packet timestamps.
+// For DVXC4 files, this comes from the "ts" metadata entry on the video chunks.
+func (f *FileInfo) TimestampOrigin() (time.Time, bool) {
+	if f.Metadata != nil {
+		if entry := f.Metadata.Entry(MetadataKeyTimestampOrigin); entry != nil {
+			if value, okay := MetadataInt64(entry.Value); okay {
+				return time.UnixMicro(value), true
+			}
+		}
+	}
+
+	for _, chunk := range f.Chunks {
+		if chunk.Video == nil || chunk.Video.Metadata == nil {
+			continue
+		}
+		entry := chunk.Video.Metadata.Entry("ts")
+		if entry == nil {
+			continue
+		}
+		value, okay := MetadataInt64(entry.Value)
+		if !okay {
+			continue
+		}
+		return time.UnixMilli(value).Add(-time.Duration(chunk.Video.Timestamp) * time.Microsecond), true
+	}
+
+	return time.Time{}, false
+}
+
+// Time returns the wall-clock time for the given chunk timestamp (in microseconds).
+func (f *FileInfo) Time(timestamp uint64) (time.Time, bool) {
+	origin, okay := f.TimestampOrigin()
+	if !okay {
+		return time.Time{}, false
+	}
+	return origin.Add(time.Duration(timestamp) * time.Microsecond), true
+}
+
+// StartTime returns the wall-clock time at which the recording started.
+func (f *FileInfo) StartTime() (time.Time, bool) {
+	if f.Metadata != nil {
+		if entry := f.Metadata.Entry(MetadataKeyStartTime); entry != nil {
+			if value, okay := MetadataInt64(entry.Value); okay {
+				return time.UnixMicro(value), true
+			}
+		}
+	}
+
+	var firstTimestamp uint64
+	found := false
+	for _, chunk := range f.Chunks {
+		var timestamp uint64
+		switch {
+		case chunk.Video != nil:
+			timestamp = chunk.Video.Timestamp
+		case chunk.Audio != nil:
+			timestamp = chunk.Audio.Timestamp
+		default:
+			continue
+		}
+		if !found || timestamp < firstTimestamp {
+			firstTimestamp = timestamp
+			found = true
+		}
+	}
+	if !found {
+		return time.Time{}, false
+	}
+	return f.Time(firstTimestamp)
+}
+
+// filenameTimePattern matches the recording time in a filename, such as "20260304-140200-CAM.nvr"
+// or "rec-20260304-140200-140500.asd" (which also has the end time).
+var filenameTimePattern = regexp.MustCompile(`(\d{8})-(\d{6})(?:-(\d{6}))?`)
+
+// FilenameTime returns the recording start and end times from a filename.
+//
+// The times are in the local time zone.  If the filename has no end time, then the end time is zero.
+func FilenameTime(filename string) (start time.Time, end time.Time, okay bool) {
+	matches := filenameTimePattern.FindStringSubmatch(filename)
+	if matches == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	start, err := time.ParseInLocation("20060102150405", matches[1]+matches[2], time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	if matches[3] != "" {
+		end, err = time.ParseInLocation("20060102150405", matches[1]+matches[3], time.Local)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		// The recording ran past midnight.
+		if end.Before(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+	}
+	return start, end, true
+}
+
+// MetadataInt64 converts a numeric metadata value into an int64.
+func MetadataInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int8:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
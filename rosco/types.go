@@ -2,12 +2,20 @@ package rosco
 
 import "image"
 
+// The file formats.
+const (
+	FormatXC  = "XC"  // DVXC (".asd") files.
+	FormatXC4 = "XC4" // DVXC4 (".nvr") files.
+)
+
 // FileInfo contains all of the information from an NVR file.
 type FileInfo struct {
-	Filename string
-	Unknown1 []byte
-	Metadata *Metadata
-	Chunks   []*Chunk
+	Format     string // One of the "Format" constants.
+	Filename   string
+	Unknown1   []byte
+	Metadata   *Metadata
+	Chunks     []*Chunk
+	GPSPackets []*XCGPSPacket // The GPS packets (DVXC files only).
 }
 
 // Metadata defines a collection of metadata entries.
@@ -24,6 +32,28 @@ func (m *Metadata) Entry(name string) *MetadataEntry {
 	return nil
 }
 
+// Flatten returns all of the metadata entries, with any sub-metadata entries expanded in place.
+//
+// The names of sub-metadata entries are prefixed with the name of their parent and a dot
+// (for example, "gps.lat").
+func (m *Metadata) Flatten() []MetadataEntry {
+	entries := []MetadataEntry{}
+	if m == nil {
+		return entries
+	}
+	for _, entry := range m.Entries {
+		if subMetadata, okay := entry.Value.(*Metadata); okay {
+			for _, subEntry := range subMetadata.Flatten() {
+				subEntry.Name = entry.Name + "." + subEntry.Name
+				entries = append(entries, subEntry)
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
 // MetadataEntry is a single metadata entry.
 type MetadataEntry struct {
 	Type  int8
@@ -33,11 +63,12 @@ type MetadataEntry struct {
 
 // Chunk is a chunk from a stream (either audio or video).
 type Chunk struct {
-	ID    string
-	Type  string
-	Audio *AudioChunk
-	Video *VideoChunk
-	Image image.Image
+	ID     string
+	Type   string
+	Offset int64 // The offset of the chunk in the file.
+	Audio  *AudioChunk
+	Video  *VideoChunk
+	Image  image.Image
 }
 
 // AudioChunk is an audio chunk.
@@ -45,13 +76,19 @@ type AudioChunk struct {
 	Timestamp  uint64
 	Media      []byte
 	ExtraMedia []byte
+
+	// Discontinuity is true if this chunk does not follow on from the previous one (for example,
+	// when it is the first chunk from the next file in a concatenation); any decoder state
+	// should be reset before decoding it.
+	Discontinuity bool
 }
 
 // VideoChunk is a video chunk.
 type VideoChunk struct {
-	Codec     string
-	Unknown1  []byte
-	Timestamp uint64
-	Metadata  *Metadata
-	Media     []byte
+	Codec       string
+	Unknown1    []byte
+	Timestamp   uint64
+	Metadata    *Metadata
+	Media       []byte
+	MediaOffset int64 // The offset of the media in the file.
 }
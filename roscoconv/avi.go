@@ -1,20 +1,374 @@
 package roscoconv
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"math"
+	"path"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/hraban/opus"
 	"github.com/nareix/joy4/codec/h264parser"
 	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/rosco-dashcam-processor/h264info"
 	"github.com/tekkamanendless/rosco-dashcam-processor/riff"
 	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
 )
 
+// aviExport is everything that we need to write an AVI file for one or more streams.
+type aviExport struct {
+	File    *riff.AVIFile // The headers; the streams have no chunks.
+	Streams []*aviExportStream
+}
+
+// aviExportStream is a single stream in an AVI export; it is either video or audio.
+type aviExportStream struct {
+	StreamIndex int    // The index of the stream in the AVI file.
+	ChunkID     string // For example, "00dc" or "01wb".
+
+	VideoFrames []aviFrame // The video frames, one per frame slot.
+
+	AudioChunks     []*rosco.Chunk // The audio chunks, in file order.
+	RawPCM          bool
+	AudioDecoder    *opus.Decoder // The Opus decoder for this stream (unless it is raw PCM).
+	AudioBitDepth   int
+	AudioSampleRate int
+	AudioBlockAlign int
+	AudioSilence    byte // The byte value of a silent sample.
+
+	startTimestamp uint64 // The timestamp that the audio is lined up with.
+	audioSamples   uint64 // The number of audio samples written so far.
+}
+
+// aviFrame is a single frame slot in the AVI file.
+//
+// If the chunk is nil, then this is a drop frame; it takes up a frame of time but has no data.
+type aviFrame struct {
+	Chunk     *rosco.Chunk
+	Timestamp uint64
+}
+
 // MakeAVI creates a `riff.AVIFile` instance based on this `rosco.FileInfo` one.
 //
-// Stream ID is the ID of the stream to export.
-func MakeAVI(info *rosco.FileInfo, streamID string) (*riff.AVIFile, error) {
+// Stream IDs are the IDs of the streams to export; each one gets its own video stream (and audio
+// stream, if it has audio) in the file.
+func MakeAVI(info *rosco.FileInfo, streamIDs []string) (*riff.AVIFile, error) {
+	export, err := prepareAVI(info, streamIDs)
+	if err != nil {
+		return nil, err
+	}
+	file := export.File
+	for _, stream := range export.Streams {
+		for i := 0; i < stream.count(); i++ {
+			streamChunks, err := stream.chunks(i)
+			if err != nil {
+				return nil, err
+			}
+			file.Streams[stream.StreamIndex].Chunks = append(file.Streams[stream.StreamIndex].Chunks, streamChunks...)
+		}
+	}
+	return file, nil
+}
+
+// WriteAVI writes an AVI file for the given streams.
+//
+// Unlike `MakeAVI`, this feeds the chunks to the writer one at a time, so the converted data
+// never needs to be held in memory.
+func WriteAVI(writer io.WriteSeeker, info *rosco.FileInfo, streamIDs []string) error {
+	export, err := prepareAVI(info, streamIDs)
+	if err != nil {
+		return err
+	}
+	aviWriter, err := riff.NewAVIWriter(writer, export.File)
+	if err != nil {
+		return err
+	}
+
+	// Interleave the streams by their timestamps.
+	positions := make([]int, len(export.Streams))
+	for {
+		next := -1
+		for i, stream := range export.Streams {
+			if positions[i] >= stream.count() {
+				continue
+			}
+			if next < 0 || stream.timestamp(positions[i]) < export.Streams[next].timestamp(positions[next]) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+
+		stream := export.Streams[next]
+		streamChunks, err := stream.chunks(positions[next])
+		if err != nil {
+			return err
+		}
+		for _, streamChunk := range streamChunks {
+			err = aviWriter.AddChunk(stream.StreamIndex, streamChunk)
+			if err != nil {
+				return err
+			}
+		}
+		positions[next]++
+	}
+
+	return aviWriter.Close()
+}
+
+// count returns the number of video frames or audio chunks in the stream.
+func (s *aviExportStream) count() int {
+	return len(s.VideoFrames) + len(s.AudioChunks)
+}
+
+// timestamp returns the timestamp of the given video frame or audio chunk.
+func (s *aviExportStream) timestamp(index int) uint64 {
+	if s.VideoFrames != nil {
+		return s.VideoFrames[index].Timestamp
+	}
+	return s.AudioChunks[index].Audio.Timestamp
+}
+
+// chunks converts the given video frame or audio chunk into AVI chunks.
+func (s *aviExportStream) chunks(index int) ([]riff.Chunk, error) {
+	if s.VideoFrames != nil {
+		return []riff.Chunk{s.videoChunk(s.VideoFrames[index])}, nil
+	}
+	return s.audioChunks(s.AudioChunks[index])
+}
+
+// videoChunk converts a video frame into an AVI chunk.
+//
+// Drop frames become empty chunks.
+func (s *aviExportStream) videoChunk(frame aviFrame) riff.Chunk {
+	if frame.Chunk == nil {
+		return riff.Chunk{
+			ID:        s.ChunkID,
+			Timestamp: frame.Timestamp,
+		}
+	}
+	return riff.Chunk{
+		ID:         s.ChunkID,
+		Data:       frame.Chunk.Video.Media,
+		IsKeyframe: strings.HasSuffix(frame.Chunk.ID, "0"),
+		Timestamp:  frame.Timestamp,
+	}
+}
+
+// audioChunks converts an audio chunk into AVI chunks.
+//
+// If the audio chunk starts later than the audio written so far, then silence is inserted
+// first so that the audio stays lined up with the video.
+func (s *aviExportStream) audioChunks(chunk *rosco.Chunk) ([]riff.Chunk, error) {
+	if chunk.Audio.Discontinuity && !s.RawPCM {
+		// Start decoding from scratch when the audio moves on to a different recording.
+		decoder, err := NewOpusDecoder()
+		if err != nil {
+			return nil, err
+		}
+		s.AudioDecoder = decoder
+	}
+	intBuffer, err := DecodePCM(chunk.Audio.Media, s.RawPCM, s.AudioBitDepth, s.AudioDecoder)
+	if err != nil {
+		return nil, err
+	}
+	rawBytes, err := MakeRawAudio(intBuffer)
+	if err != nil {
+		return nil, err
+	}
+
+	streamChunks := []riff.Chunk{}
+	if s.AudioSampleRate > 0 && s.AudioBlockAlign > 0 {
+		sampleCount := uint64(len(rawBytes) / s.AudioBlockAlign)
+		chunkDuration := sampleCount * 1000000 / uint64(s.AudioSampleRate)
+		expectedTimestamp := s.startTimestamp + s.audioSamples*1000000/uint64(s.AudioSampleRate)
+		// Only fill in gaps that are longer than the chunk itself; anything shorter is jitter.
+		if chunk.Audio.Timestamp > expectedTimestamp+chunkDuration {
+			silentSamples := (chunk.Audio.Timestamp - expectedTimestamp) * uint64(s.AudioSampleRate) / 1000000
+			logrus.Debugf("Audio gap at %d: inserting %d samples of silence", expectedTimestamp, silentSamples)
+			for silentSamples > 0 {
+				// Keep the silence chunks to a second or less.
+				count := silentSamples
+				if count > uint64(s.AudioSampleRate) {
+					count = uint64(s.AudioSampleRate)
+				}
+				data := bytes.Repeat([]byte{s.AudioSilence}, int(count)*s.AudioBlockAlign)
+				streamChunks = append(streamChunks, riff.Chunk{
+					ID:         s.ChunkID,
+					Data:       data,
+					IsKeyframe: true,
+					Timestamp:  s.startTimestamp + s.audioSamples*1000000/uint64(s.AudioSampleRate),
+				})
+				s.audioSamples += count
+				silentSamples -= count
+			}
+		}
+		s.audioSamples += sampleCount
+	}
+
+	streamChunks = append(streamChunks, riff.Chunk{
+		ID:         s.ChunkID,
+		Data:       rawBytes,
+		IsKeyframe: true, // Every audio chunk can be decoded on its own.
+		Timestamp:  chunk.Audio.Timestamp,
+	})
+	return streamChunks, nil
+}
+
+// makeAVIInfo builds the "LIST INFO" entries and the "IDIT" date for an AVI file.
+//
+// These record where the video came from: the NVR file, the camera, the device, and the time.
+func makeAVIInfo(info *rosco.FileInfo, streamIDs []string, firstTimestamp uint64) ([]riff.InfoEntry, time.Time) {
+	cameras := []string{}
+	for _, streamID := range streamIDs {
+		cameras = append(cameras, streamID[:1])
+	}
+	camera := strings.Join(cameras, ", ")
+	cameraLabel := "camera"
+	commentLabel := "Camera"
+	if len(cameras) > 1 {
+		cameraLabel = "cameras"
+		commentLabel = "Cameras"
+	}
+	filename := path.Base(info.Filename)
+
+	appVersion := ""
+	serialNumber := ""
+	if info.Metadata != nil {
+		if entry := info.Metadata.Entry("appVersion"); entry != nil {
+			appVersion = fmt.Sprintf("%v", entry.Value)
+		}
+		// We've seen a few different names for the serial number.
+		for _, entry := range info.Metadata.Entries {
+			if strings.Contains(strings.ToLower(entry.Name), "serial") {
+				serialNumber = fmt.Sprintf("%v", entry.Value)
+				break
+			}
+		}
+	}
+
+	startTime, okay := info.Time(firstTimestamp)
+	if !okay {
+		startTime, okay = info.StartTime()
+	}
+	if !okay {
+		logrus.Warnf("Could not determine the recording time; the AVI will have no creation date.")
+	}
+
+	commentParts := []string{
+		fmt.Sprintf("File: %s", filename),
+		fmt.Sprintf("%s: %s", commentLabel, camera),
+	}
+	if serialNumber != "" {
+		commentParts = append(commentParts, fmt.Sprintf("Serial number: %s", serialNumber))
+	}
+	if appVersion != "" {
+		commentParts = append(commentParts, fmt.Sprintf("App version: %s", appVersion))
+	}
+	if okay {
+		commentParts = append(commentParts, fmt.Sprintf("Start time: %s", startTime.Format(time.RFC3339)))
+	}
+
+	entries := []riff.InfoEntry{
+		{ID: riff.InfoTitle, Value: fmt.Sprintf("%s (%s %s)", filename, cameraLabel, camera)},
+	}
+	if okay {
+		entries = append(entries, riff.InfoEntry{ID: riff.InfoCreationDate, Value: startTime.Format("2006-01-02")})
+	}
+	entries = append(entries,
+		riff.InfoEntry{ID: riff.InfoSoftware, Value: Originator},
+		riff.InfoEntry{ID: riff.InfoComment, Value: strings.Join(commentParts, "; ")},
+	)
+	return entries, startTime
+}
+
+// videoFrameInterval figures out the nominal frame interval (in microseconds) for the video.
+//
+// If the SPS has VUI timing information that roughly agrees with the median interval between
+// the frames, then that is used (as an exact rate and scale); otherwise, the median interval is used.
+func videoFrameInterval(videoChunks []*rosco.Chunk) (interval uint64, rate int32, scale int32) {
+	timestamps := make([]uint64, 0, len(videoChunks))
+	for _, chunk := range videoChunks {
+		timestamps = append(timestamps, chunk.Video.Timestamp)
+	}
+	median := rosco.MedianInterval(timestamps)
+	logrus.Debugf("Median frame interval: %d us", median)
+
+	for _, chunk := range videoChunks {
+		if !strings.HasSuffix(chunk.ID, "0") {
+			continue
+		}
+		nalus, _ := h264parser.SplitNALUs(chunk.Video.Media)
+		for _, nalu := range nalus {
+			if len(nalu) == 0 || nalu[0]&0x1f != 7 {
+				continue
+			}
+			sps, err := h264info.ParseSPS(nalu)
+			if err != nil {
+				logrus.Debugf("Could not parse SPS: %v", err)
+				continue
+			}
+			timeScale, unitsPerFrame, okay := sps.FrameRate()
+			if !okay {
+				continue
+			}
+			vuiInterval := uint64(unitsPerFrame) * 1000000 / uint64(timeScale)
+			logrus.Debugf("VUI frame interval: %d us (%d / %d)", vuiInterval, timeScale, unitsPerFrame)
+			if vuiInterval == 0 || timeScale > math.MaxInt32 || unitsPerFrame > math.MaxInt32 {
+				continue
+			}
+			// Trust the VUI timing if it is within 10% of what we actually see.
+			if median == 0 || (vuiInterval*10 >= median*9 && vuiInterval*10 <= median*11) {
+				return vuiInterval, int32(timeScale), int32(unitsPerFrame)
+			}
+		}
+		break // Only look at the first keyframe with an SPS.
+	}
+
+	if median == 0 {
+		// We have nothing to go on; assume a common dashcam rate.
+		median = 1000000 / 30
+	}
+	return median, 1000000, int32(median)
+}
+
+// makeVideoFrames assigns the video chunks to frame slots, adding drop frames for any empty slots.
+//
+// The slot for a frame is its offset from the first frame, rounded to the nearest interval.
+func makeVideoFrames(videoChunks []*rosco.Chunk, interval uint64) []aviFrame {
+	frames := []aviFrame{}
+	if len(videoChunks) == 0 || interval == 0 {
+		return frames
+	}
+	firstTimestamp := videoChunks[0].Video.Timestamp
+	nextSlot := uint64(0)
+	for _, chunk := range videoChunks {
+		slot := (chunk.Video.Timestamp - firstTimestamp + interval/2) / interval
+		if slot < nextSlot {
+			slot = nextSlot
+		}
+		for ; nextSlot < slot; nextSlot++ {
+			frames = append(frames, aviFrame{
+				Timestamp: firstTimestamp + nextSlot*interval,
+			})
+		}
+		frames = append(frames, aviFrame{
+			Chunk:     chunk,
+			Timestamp: chunk.Video.Timestamp,
+		})
+		nextSlot++
+	}
+	return frames
+}
+
+// aviVideoChunks returns the video chunks for the given stream, in timestamp order.
+//
+// Any frames before the first keyframe are dropped.
+func aviVideoChunks(info *rosco.FileInfo, streamID string) []*rosco.Chunk {
 	streamIDs := []string{}
 	for _, id := range info.StreamIDs() {
 		if len(streamID) == 1 {
@@ -28,26 +382,6 @@ func MakeAVI(info *rosco.FileInfo, streamID string) (*riff.AVIFile, error) {
 		}
 	}
 
-	audioStreamID := streamID
-	if len(audioStreamID) == 1 {
-		for _, id := range info.StreamIDs() {
-			if strings.HasPrefix(id, streamID) {
-				audioPresent := false
-				for _, chunk := range info.ChunksForStreamID(id) {
-					if chunk.Audio != nil {
-						audioPresent = true
-						break
-					}
-
-				}
-				if audioPresent {
-					audioStreamID = id
-					break
-				}
-			}
-		}
-	}
-
 	videoChunks := []*rosco.Chunk{}
 	for _, id := range streamIDs {
 		substreamChunks := info.ChunksForStreamID(id)
@@ -61,28 +395,53 @@ func MakeAVI(info *rosco.FileInfo, streamID string) (*riff.AVIFile, error) {
 		return videoChunks[i].Video.Timestamp < videoChunks[j].Video.Timestamp
 	})
 
-	// Figure out the video information.
-	var videoWidth int32
-	var videoHeight int32
+	// Strip out any frames before the first keyframe.  We can't do anything
+	// without a keyframe.
+	firstKeyframeIndex := 0
 	for chunkIndex, chunk := range videoChunks {
 		// Only use the key frames.
 		if !strings.HasSuffix(chunk.ID, "0") {
 			continue
 		}
-		nalus, _ := h264parser.SplitNALUs(chunk.Video.Media)
-		if len(nalus) == 0 {
+		firstKeyframeIndex = chunkIndex
+		break
+	}
+	return videoChunks[firstKeyframeIndex:]
+}
+
+// videoFormat figures out the video dimensions and bit count from the SPS in the keyframes, and
+// the FourCC from the chunks.
+//
+// Like ffmpeg, the bit count is the number of bits per pixel of the decoded picture (24 for 8-bit video).
+func videoFormat(videoChunks []*rosco.Chunk) (videoWidth int32, videoHeight int32, bitCount int16, fourCC [4]byte) {
+	bitCount = 24
+	fourCC = [4]byte{'H', '2', '6', '4'}
+	for _, chunk := range videoChunks {
+		if len(chunk.Video.Codec) == 4 {
+			copy(fourCC[:], chunk.Video.Codec)
+			break
+		}
+	}
+
+	for chunkIndex, chunk := range videoChunks {
+		// Only use the key frames.
+		if !strings.HasSuffix(chunk.ID, "0") {
 			continue
 		}
+		nalus, _ := h264parser.SplitNALUs(chunk.Video.Media)
 		for naluIndex, nalu := range nalus {
-			spsInfo, err := h264parser.ParseSPS(nalu)
+			if len(nalu) == 0 || nalu[0]&0x1f != 7 {
+				continue
+			}
+			sps, err := h264info.ParseSPS(nalu)
 			if err != nil {
 				logrus.Debugf("Chunk %d: NALU %d: Could not parse SPS: %v", chunkIndex, naluIndex, err)
 				continue
 			}
-			newWidth := int32(spsInfo.Width)
-			newHeight := int32(spsInfo.Height)
-			logrus.Debugf("Chunk %d: NALU %d: profile_idc: %d, width: %d, height: %d", chunkIndex, naluIndex, spsInfo.ProfileIdc, newWidth, newHeight)
-			switch spsInfo.ProfileIdc {
+			newWidth := int32(sps.Width())
+			newHeight := int32(sps.Height())
+			logrus.Debugf("Chunk %d: NALU %d: profile_idc: %d, width: %d, height: %d", chunkIndex, naluIndex, sps.ProfileIDC, newWidth, newHeight)
+			switch sps.ProfileIDC {
 			case 66, 77, 88, 100, 110, 122, 244: // These profiles actually encode real video.
 				if newWidth > videoWidth {
 					videoWidth = newWidth
@@ -92,162 +451,207 @@ func MakeAVI(info *rosco.FileInfo, streamID string) (*riff.AVIFile, error) {
 					videoHeight = newHeight
 					logrus.Debugf("Chunk %d: NALU %d: Setting new video height: %d", chunkIndex, naluIndex, videoHeight)
 				}
+				bitCount = int16(3 * sps.BitDepthLuma)
 			}
 		}
 	}
 	logrus.Debugf("Video dimensions: width: %d, height: %d", videoWidth, videoHeight)
+	logrus.Debugf("Video bit count: %d", bitCount)
+	logrus.Debugf("Video FourCC: %s", fourCC)
+	return
+}
 
-	// Strip out any frames before the first keyframe.  We can't do anything
-	// without a keyframe.
-	firstKeyframeIndex := 0
-	for chunkIndex, chunk := range videoChunks {
-		// Only use the key frames.
-		if !strings.HasSuffix(chunk.ID, "0") {
-			continue
-		}
-		firstKeyframeIndex = chunkIndex
-		break
+// prepareAVI figures out the headers and the chunks for an AVI file for the given streams.
+//
+// Every stream is lined up with the earliest video frame of all of the streams; a video stream
+// that starts later has its "Start" set to the number of frames that it is offset by, and the
+// audio streams are padded with silence at the beginning.
+func prepareAVI(info *rosco.FileInfo, streamIDs []string) (*aviExport, error) {
+	if len(streamIDs) == 0 {
+		return nil, fmt.Errorf("no streams to export")
 	}
-	videoChunks = videoChunks[firstKeyframeIndex:]
 
-	var firstVideoTimestamp uint64
-	var lastVideoTimestamp uint64
-	for _, chunk := range videoChunks {
-		if firstVideoTimestamp == 0 || chunk.Video.Timestamp < firstVideoTimestamp {
-			firstVideoTimestamp = chunk.Video.Timestamp
+	allVideoChunks := make([][]*rosco.Chunk, len(streamIDs))
+	var startTimestamp uint64
+	haveStartTimestamp := false
+	for i, streamID := range streamIDs {
+		err := checkStreamID(info, streamID)
+		if err != nil {
+			return nil, err
 		}
-		if lastVideoTimestamp == 0 || chunk.Video.Timestamp > lastVideoTimestamp {
-			lastVideoTimestamp = chunk.Video.Timestamp
+		allVideoChunks[i] = aviVideoChunks(info, streamID)
+		if len(allVideoChunks[i]) == 0 {
+			return nil, fmt.Errorf("no video frames in stream %s", streamID)
 		}
-	}
-	videoDuration := lastVideoTimestamp - firstVideoTimestamp
-	framesPerSecond := 1000000.0 * float64(len(videoChunks)) / float64(videoDuration)
-
-	logrus.Debugf("First video timestamp: %d", firstVideoTimestamp)
-	logrus.Debugf("Last video timestamp: %d", lastVideoTimestamp)
-	logrus.Debugf("Video duration: %d us", videoDuration)
-	logrus.Debugf("Video frames: %d", len(videoChunks))
-	logrus.Debugf("Frames per second: %v", framesPerSecond)
-
-	videoStream := riff.Stream{
-		Header: riff.AVIStreamHeader{
-			Type:                [4]byte{'v', 'i', 'd', 's'},
-			Handler:             [4]byte{'H', '2', '6', '4'},   // TODO: Pull this from the chunks.
-			Rate:                int32(1000 * framesPerSecond), // Effective fps is Rate / Scale; this allows for fractional fps.
-			Scale:               1000,                          // Effective fps is Rate / Scale; this allows for fractional fps.
-			SuggestedBufferSize: 65536,
-			Width:               int16(videoWidth),
-			Height:              int16(videoHeight),
-		},
-		VideoFormat: riff.AVIStreamVideoFormat{
-			Size:        int32(len(new(riff.AVIStreamVideoFormat).Bytes())),
-			Width:       videoWidth,
-			Height:      videoHeight,
-			Planes:      1,
-			BitCount:    24,                          // TODO: Pull this from the metadata.
-			Compression: [4]byte{'H', '2', '6', '4'}, // TODO: Pull this from the chunks.
-		},
-	}
-	videoStream.VideoFormat.SizeImage = videoStream.VideoFormat.Width * videoStream.VideoFormat.Height * int32(videoStream.VideoFormat.BitCount) / 8
-	for _, chunk := range videoChunks {
-		streamChunk := riff.Chunk{
-			ID:         "00dc",
-			Data:       chunk.Video.Media,
-			IsKeyframe: strings.HasSuffix(chunk.ID, "0"),
-			Timestamp:  chunk.Video.Timestamp,
+		if !haveStartTimestamp || allVideoChunks[i][0].Video.Timestamp < startTimestamp {
+			startTimestamp = allVideoChunks[i][0].Video.Timestamp
+			haveStartTimestamp = true
 		}
-		videoStream.Chunks = append(videoStream.Chunks, streamChunk)
 	}
-	videoStream.Header.Length = int32(len(videoChunks))
+	logrus.Debugf("Start timestamp: %d", startTimestamp)
+
 	file := &riff.AVIFile{
 		Header: riff.AVIHeader{
-			MicroSecPerFrame:    int32(videoDuration) / int32(len(videoChunks)),
 			MaxBytesPerSec:      0,
 			PaddingGranularity:  0,
 			Flags:               riff.AVIFlagIsInterleaved | riff.AVIFlagTrustCKType | riff.AVIFlagHasIndex,
-			TotalFrames:         int32(len(videoStream.Chunks)),
 			InitialFrames:       0,
 			Streams:             0,
 			SuggestedBufferSize: 65536,
-			Width:               videoWidth,
-			Height:              videoHeight,
 			Scale:               0,
 			Rate:                0,
 			Start:               0,
 			Length:              0,
 		},
 	}
-	file.Streams = append(file.Streams, videoStream)
-	file.Header.Streams++
+	export := &aviExport{
+		File: file,
+	}
 
-	//spew.Dump(file.Header)
-	//spew.Dump(videoStream.Header)
+	for i, streamID := range streamIDs {
+		videoChunks := allVideoChunks[i]
+		videoWidth, videoHeight, bitCount, fourCC := videoFormat(videoChunks)
 
-	fmt.Printf("Audio stream ID: %s\n", audioStreamID)
-	{
-		rawPCM := strings.HasSuffix(audioStreamID, "7")
+		frameInterval, frameRate, frameScale := videoFrameInterval(videoChunks)
+		videoFrames := makeVideoFrames(videoChunks, frameInterval)
+		var startFrames uint64
+		if len(videoChunks) > 0 {
+			startFrames = (videoChunks[0].Video.Timestamp - startTimestamp + frameInterval/2) / frameInterval
+		}
 
-		wavAudioFormat := 0x0001 // PCM
-		audioBitDepth := 8
-		if rawPCM {
-			entry := info.Metadata.Entry("_audioBitDepth")
-			if entry != nil {
-				audioBitDepth = int(entry.Value.(int64))
-				logrus.Debugf("Audio bit depth (from the metadata): %d", audioBitDepth)
-			}
+		logrus.Debugf("Stream %s: frame interval: %d us", streamID, frameInterval)
+		logrus.Debugf("Stream %s: video frames: %d", streamID, len(videoChunks))
+		logrus.Debugf("Stream %s: drop frames: %d", streamID, len(videoFrames)-len(videoChunks))
+		logrus.Debugf("Stream %s: start frames: %d", streamID, startFrames)
 
-			entry = info.Metadata.Entry("_wavAudioFormat")
-			if entry != nil {
-				wavAudioFormat = int(entry.Value.(int64))
-				logrus.Debugf("WAV audio format (from the metadata): %d", wavAudioFormat)
-			}
+		videoStream := riff.Stream{
+			Header: riff.AVIStreamHeader{
+				Type:                [4]byte{'v', 'i', 'd', 's'},
+				Handler:             fourCC,
+				Rate:                frameRate,  // Effective fps is Rate / Scale; this allows for fractional fps.
+				Scale:               frameScale, // Effective fps is Rate / Scale; this allows for fractional fps.
+				Start:               int32(startFrames),
+				SuggestedBufferSize: 65536,
+				FrameRight:          int16(videoWidth),
+				FrameBottom:         int16(videoHeight),
+			},
+			VideoFormat: riff.AVIStreamVideoFormat{
+				Size:        int32(len(new(riff.AVIStreamVideoFormat).Bytes())),
+				Width:       videoWidth,
+				Height:      videoHeight,
+				Planes:      1,
+				BitCount:    bitCount,
+				Compression: fourCC,
+			},
 		}
-		logrus.Debugf("Audio bit depth: %d", audioBitDepth)
-		logrus.Debugf("WAV audio format: %d", wavAudioFormat)
+		videoStream.VideoFormat.SizeImage = videoStream.VideoFormat.Width * videoStream.VideoFormat.Height * int32(videoStream.VideoFormat.BitCount) / 8
+		videoStream.Header.Length = int32(len(videoFrames))
 
-		audioStream := riff.Stream{}
+		// The main header describes the first video stream; the frame has to fit all of them.
+		if i == 0 {
+			file.Header.MicroSecPerFrame = int32(frameInterval)
+			file.Header.TotalFrames = int32(len(videoFrames))
+		}
+		if videoWidth > file.Header.Width {
+			file.Header.Width = videoWidth
+		}
+		if videoHeight > file.Header.Height {
+			file.Header.Height = videoHeight
+		}
 
-		chunks := info.ChunksForStreamID(audioStreamID)
-		for chunkIndex, chunk := range chunks {
-			intBuffer, err := MakePCM(chunk.Audio.Media, rawPCM, audioBitDepth)
-			if err != nil {
-				return nil, err
-			}
+		export.Streams = append(export.Streams, &aviExportStream{
+			StreamIndex: len(file.Streams),
+			ChunkID:     fmt.Sprintf("%02ddc", len(file.Streams)),
+			VideoFrames: videoFrames,
+		})
+		file.Streams = append(file.Streams, videoStream)
+		file.Header.Streams++
 
-			if chunkIndex == 0 {
-				audioStream.Header = riff.AVIStreamHeader{
-					Type:                [4]byte{'a', 'u', 'd', 's'},
-					Handler:             [4]byte{' ', ' ', ' ', ' '},
-					Scale:               1,
-					Rate:                int32(intBuffer.Format.SampleRate),
-					SuggestedBufferSize: 65536,
-				}
-				audioStream.AudioFormat = riff.AVIStreamAudioFormat{
-					FormatTag:      int16(wavAudioFormat),
-					Channels:       int16(intBuffer.Format.NumChannels),
-					SamplesPerSec:  int32(intBuffer.Format.SampleRate),
-					AvgBytesPerSec: int32(intBuffer.Format.SampleRate * intBuffer.Format.NumChannels / (intBuffer.SourceBitDepth / 8)),
-					BlockAlign:     int16(intBuffer.SourceBitDepth / 8 * intBuffer.Format.NumChannels),
-					BitsPerSample:  int16(intBuffer.SourceBitDepth * intBuffer.Format.NumChannels),
-				}
-			}
+		err := prepareAVIAudio(export, info, streamID, startTimestamp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	file.Info, file.Date = makeAVIInfo(info, streamIDs, startTimestamp)
 
-			rawBytes, err := MakeRawAudio(intBuffer)
-			if err != nil {
-				return nil, err
-			}
+	return export, nil
+}
 
-			streamChunk := riff.Chunk{
-				ID:        "01wb",
-				Data:      rawBytes,
-				Timestamp: chunk.Audio.Timestamp,
-			}
-			audioStream.Chunks = append(audioStream.Chunks, streamChunk)
+// prepareAVIAudio adds the audio stream (if there is one) for the given stream to the export.
+func prepareAVIAudio(export *aviExport, info *rosco.FileInfo, streamID string, startTimestamp uint64) error {
+	audioStreamID := FindAudioStreamID(info, streamID)
+	logrus.Debugf("Audio stream ID: %s", audioStreamID)
+
+	rawPCM, audioBitDepth, wavAudioFormat := AudioFormat(info, audioStreamID)
+	logrus.Debugf("Audio bit depth: %d", audioBitDepth)
+	logrus.Debugf("WAV audio format: %d", wavAudioFormat)
+
+	file := export.File
+	stream := &aviExportStream{
+		StreamIndex:    len(file.Streams),
+		ChunkID:        fmt.Sprintf("%02dwb", len(file.Streams)),
+		RawPCM:         rawPCM,
+		AudioBitDepth:  audioBitDepth,
+		startTimestamp: startTimestamp,
+	}
+
+	for _, chunk := range info.ChunksForStreamID(audioStreamID) {
+		if chunk.Audio != nil {
+			stream.AudioChunks = append(stream.AudioChunks, chunk)
 		}
+	}
+	if len(stream.AudioChunks) == 0 {
+		return nil
+	}
 
-		file.Streams = append(file.Streams, audioStream)
-		file.Header.Streams++
+	// Opus is decoded into 16-bit PCM at 48 kHz.
+	sampleRate := OpusSampleRate
+	bitDepth := 16
+	channelCount := 1
+	if rawPCM {
+		sampleRate = 8000
+		bitDepth = audioBitDepth
+	} else {
+		decoder, err := NewOpusDecoder()
+		if err != nil {
+			return err
+		}
+		stream.AudioDecoder = decoder
+	}
+	// A block is one sample for every channel.
+	blockAlign := bitDepth / 8 * channelCount
+	stream.AudioSampleRate = sampleRate
+	stream.AudioBlockAlign = blockAlign
+	switch {
+	case wavAudioFormat == 0x0006: // A-law
+		stream.AudioSilence = 0xd5
+	case wavAudioFormat == 0x0007: // mu-law
+		stream.AudioSilence = 0xff
+	case wavAudioFormat == 0x0001 && bitDepth == 8: // 8-bit PCM is unsigned.
+		stream.AudioSilence = 0x80
+	}
+	audioStream := riff.Stream{
+		Header: riff.AVIStreamHeader{
+			Type:                [4]byte{'a', 'u', 'd', 's'},
+			Handler:             [4]byte{' ', ' ', ' ', ' '},
+			Scale:               1,
+			Rate:                int32(sampleRate),
+			SuggestedBufferSize: 65536,
+			SampleSize:          int32(blockAlign), // The length is filled in by the writer.
+		},
+		AudioFormat: riff.AVIStreamAudioFormat{
+			FormatTag:      int16(wavAudioFormat),
+			Channels:       int16(channelCount),
+			SamplesPerSec:  int32(sampleRate),
+			AvgBytesPerSec: int32(sampleRate * blockAlign),
+			BlockAlign:     int16(blockAlign),
+			BitsPerSample:  int16(bitDepth),
+		},
 	}
 
-	return file, nil
+	export.Streams = append(export.Streams, stream)
+	file.Streams = append(file.Streams, audioStream)
+	file.Header.Streams++
+
+	return nil
 }
@@ -0,0 +1,147 @@
+package roscoconv
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/rosco-dashcam-processor/riff"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// Originator is the name that we use to identify ourselves in exported files.
+const Originator = "rosco-dashcam-processor"
+
+// ixmlDocument is the iXML document that we embed in WAV files.
+//
+// See http://www.gallery.co.uk/ixml/ for the details.
+type ixmlDocument struct {
+	XMLName   xml.Name      `xml:"BWFXML"`
+	Version   string        `xml:"IXML_VERSION"`
+	Project   string        `xml:"PROJECT"`
+	Tape      string        `xml:"TAPE,omitempty"`
+	Note      string        `xml:"NOTE"`
+	Speed     ixmlSpeed     `xml:"SPEED"`
+	TrackList ixmlTrackList `xml:"TRACK_LIST"`
+	User      string        `xml:"USER"`
+}
+
+type ixmlSpeed struct {
+	TimestampSampleRate      int    `xml:"TIMESTAMP_SAMPLE_RATE"`
+	SamplesSinceMidnightLow  uint32 `xml:"TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_LO"`
+	SamplesSinceMidnightHigh uint32 `xml:"TIMESTAMP_SAMPLES_SINCE_MIDNIGHT_HI"`
+}
+
+type ixmlTrackList struct {
+	TrackCount int         `xml:"TRACK_COUNT"`
+	Tracks     []ixmlTrack `xml:"TRACK"`
+}
+
+type ixmlTrack struct {
+	ChannelIndex    int    `xml:"CHANNEL_INDEX"`
+	InterleaveIndex int    `xml:"INTERLEAVE_INDEX"`
+	Name            string `xml:"NAME"`
+}
+
+// MakeBWFChunks creates the Broadcast Wave Format chunks ("bext" and "iXML") for the given audio stream.
+//
+// The origination date and time come from the timestamp of the first audio chunk in the stream, in the
+// given time zone; the time reference (samples since midnight) is counted from midnight in that zone too.
+// The description is built from the camera filename and its "appVersion" metadata.
+func MakeBWFChunks(info *rosco.FileInfo, streamID string, sampleRate int, bitDepth int, channelCount int, location *time.Location) ([]riff.Chunk, error) {
+	var firstTimestamp uint64
+	found := false
+	for _, chunk := range info.ChunksForStreamID(streamID) {
+		if chunk.Audio == nil {
+			continue
+		}
+		if !found || chunk.Audio.Timestamp < firstTimestamp {
+			firstTimestamp = chunk.Audio.Timestamp
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no audio chunks in stream %s", streamID)
+	}
+
+	appVersion := ""
+	if info.Metadata != nil {
+		if entry := info.Metadata.Entry("appVersion"); entry != nil {
+			appVersion = fmt.Sprintf("%v", entry.Value)
+		}
+	}
+
+	descriptionParts := []string{
+		fmt.Sprintf("File: %s", path.Base(info.Filename)),
+		fmt.Sprintf("Stream: %s", streamID),
+	}
+	if appVersion != "" {
+		descriptionParts = append(descriptionParts, fmt.Sprintf("App version: %s", appVersion))
+	}
+
+	extension := riff.BroadcastExtensionChunk{}
+	extension.Version = 1
+	riff.SetString(extension.Description[:], strings.Join(descriptionParts, "; "))
+	riff.SetString(extension.Originator[:], Originator)
+
+	var samplesSinceMidnight uint64
+	originationTime, okay := info.Time(firstTimestamp)
+	if okay {
+		originationTime = originationTime.In(location)
+		riff.SetString(extension.OriginationDate[:], originationTime.Format("2006-01-02"))
+		riff.SetString(extension.OriginationTime[:], originationTime.Format("15:04:05"))
+
+		midnight := time.Date(originationTime.Year(), originationTime.Month(), originationTime.Day(), 0, 0, 0, 0, originationTime.Location())
+		samplesSinceMidnight = uint64(originationTime.Sub(midnight).Microseconds()) * uint64(sampleRate) / 1000000
+		extension.TimeReference = samplesSinceMidnight
+	} else {
+		logrus.Warnf("Could not determine the recording time; the BWF time reference will be empty.")
+	}
+
+	channelMode := "mono"
+	if channelCount == 2 {
+		channelMode = "stereo"
+	}
+	extension.CodingHistory = fmt.Sprintf("A=PCM,F=%d,W=%d,M=%s,T=%s\r\n", sampleRate, bitDepth, channelMode, Originator)
+
+	document := ixmlDocument{
+		Version: "2.10",
+		Project: path.Base(info.Filename),
+		Tape:    appVersion,
+		Note:    fmt.Sprintf("Rosco stream %s", streamID),
+		Speed: ixmlSpeed{
+			TimestampSampleRate:      sampleRate,
+			SamplesSinceMidnightLow:  uint32(samplesSinceMidnight),
+			SamplesSinceMidnightHigh: uint32(samplesSinceMidnight >> 32),
+		},
+		User: fmt.Sprintf("STREAM_ID=%s", streamID),
+	}
+	for i := 0; i < channelCount; i++ {
+		document.TrackList.Tracks = append(document.TrackList.Tracks, ixmlTrack{
+			ChannelIndex:    i + 1,
+			InterleaveIndex: i + 1,
+			Name:            fmt.Sprintf("Stream %s", streamID),
+		})
+	}
+	document.TrackList.TrackCount = len(document.TrackList.Tracks)
+
+	ixmlBytes, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not encode the iXML document: %v", err)
+	}
+
+	chunks := []riff.Chunk{
+		{
+			ID:   "bext",
+			Data: extension.Bytes(),
+		},
+		{
+			ID:   "iXML",
+			Data: append([]byte(xml.Header), ixmlBytes...),
+		},
+	}
+	return chunks, nil
+}
@@ -0,0 +1,201 @@
+package roscoconv
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// Concat combines several recordings into one, on a single timeline.
+//
+// The recordings are put in order by their recording time, and the timestamps of each one are
+// moved so that they line up with the wall-clock time of the first.  The recording time comes from
+// the timestamps in the file if there are any, and from the filename otherwise.  Any gaps between
+// the recordings are left as gaps in the timestamps; the exporters turn these into frame holds and
+// silence.  If a recording has no recording time, then it is placed right after the one before it.
+//
+// The first audio chunk of each recording is marked as a discontinuity, so that the audio decoder
+// starts fresh for each file.  The audio format is taken from the first recording, so the
+// recordings should all come from the same kind of camera.  The input recordings are not modified.
+func Concat(infos []*rosco.FileInfo) (*rosco.FileInfo, error) {
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("no recordings to concatenate")
+	}
+
+	type recording struct {
+		info   *rosco.FileInfo
+		origin time.Time
+		timed  bool
+	}
+	recordings := []recording{}
+	for _, info := range infos {
+		origin, okay := info.TimestampOrigin()
+		if !okay {
+			origin, okay = info.StartTime()
+		}
+		if !okay {
+			// Fall back on the time in the filename, which is when the first chunk was recorded.
+			origin, _, okay = rosco.FilenameTime(info.Filename)
+			if firstTimestamp, found := FirstTimestamp(info); okay && found {
+				origin = origin.Add(-time.Duration(firstTimestamp) * time.Microsecond)
+			}
+		}
+		if !okay {
+			logrus.Warnf("Could not determine the recording time of %s; it will follow the previous file.", info.Filename)
+		}
+		recordings = append(recordings, recording{info: info, origin: origin, timed: okay})
+	}
+	// Any recordings without a time go at the end, in the order that they were given.
+	sort.SliceStable(recordings, func(i, j int) bool {
+		if recordings[i].timed != recordings[j].timed {
+			return recordings[i].timed
+		}
+		return recordings[i].timed && recordings[i].origin.Before(recordings[j].origin)
+	})
+
+	var baseOrigin time.Time
+	for _, r := range recordings {
+		if r.timed {
+			baseOrigin = r.origin
+			break
+		}
+	}
+
+	first := recordings[0].info
+	result := &rosco.FileInfo{
+		Format:   first.Format,
+		Filename: first.Filename,
+		Unknown1: first.Unknown1,
+		Metadata: &rosco.Metadata{},
+	}
+	if first.Metadata != nil {
+		for _, entry := range first.Metadata.Entries {
+			switch entry.Name {
+			case rosco.MetadataKeyTimestampOrigin, rosco.MetadataKeyStartTime, "_duration":
+				continue
+			}
+			result.Metadata.Entries = append(result.Metadata.Entries, entry)
+		}
+	}
+	if !baseOrigin.IsZero() {
+		result.Metadata.Entries = append(result.Metadata.Entries,
+			rosco.MetadataEntry{Type: rosco.MetadataTypeInt64, Name: rosco.MetadataKeyTimestampOrigin, Value: baseOrigin.UnixMicro()},
+			rosco.MetadataEntry{Type: rosco.MetadataTypeInt64, Name: rosco.MetadataKeyStartTime, Value: baseOrigin.UnixMicro()},
+		)
+	}
+
+	// The end of the timeline so far (in microseconds); this includes the length of the last frame and audio chunk.
+	var timelineEnd uint64
+	for recordingIndex, r := range recordings {
+		var firstTimestamp, lastTimestamp uint64
+		haveTimestamps := false
+		videoTimestamps := []uint64{}
+		audioTimestamps := []uint64{}
+		for _, chunk := range r.info.Chunks {
+			var timestamp uint64
+			switch {
+			case chunk.Video != nil:
+				timestamp = chunk.Video.Timestamp
+				videoTimestamps = append(videoTimestamps, timestamp)
+			case chunk.Audio != nil:
+				timestamp = chunk.Audio.Timestamp
+				audioTimestamps = append(audioTimestamps, timestamp)
+			default:
+				continue
+			}
+			if !haveTimestamps || timestamp < firstTimestamp {
+				firstTimestamp = timestamp
+			}
+			if !haveTimestamps || timestamp > lastTimestamp {
+				lastTimestamp = timestamp
+			}
+			haveTimestamps = true
+		}
+		for _, name := range []string{"_audioBitDepth", "_wavAudioFormat"} {
+			if metadataValue(r.info.Metadata, name) != metadataValue(first.Metadata, name) {
+				logrus.Warnf("Recording %s has a different %s than %s; its audio may not come out right.", r.info.Filename, name, first.Filename)
+			}
+		}
+		if !haveTimestamps {
+			logrus.Warnf("Recording %s has no audio or video; skipping it.", r.info.Filename)
+			continue
+		}
+		sort.Slice(videoTimestamps, func(i, j int) bool {
+			return videoTimestamps[i] < videoTimestamps[j]
+		})
+		sort.Slice(audioTimestamps, func(i, j int) bool {
+			return audioTimestamps[i] < audioTimestamps[j]
+		})
+
+		// Figure out where the first chunk of this recording goes on the timeline.
+		start := timelineEnd
+		if r.timed && !baseOrigin.IsZero() {
+			wallClockStart := r.origin.Add(time.Duration(firstTimestamp) * time.Microsecond).Sub(baseOrigin)
+			if wallClockStart >= 0 && uint64(wallClockStart.Microseconds()) >= start {
+				start = uint64(wallClockStart.Microseconds())
+			} else if recordingIndex > 0 {
+				logrus.Warnf("Recording %s overlaps the one before it; moving it to %v.", r.info.Filename, time.Duration(start)*time.Microsecond)
+			}
+		}
+		logrus.Debugf("Recording %s: starts at %d us on the timeline", r.info.Filename, start)
+
+		firstAudioChunk := true
+		for _, chunk := range r.info.Chunks {
+			newChunk := &rosco.Chunk{
+				ID:     chunk.ID,
+				Type:   chunk.Type,
+				Offset: chunk.Offset,
+				Image:  chunk.Image,
+			}
+			if chunk.Video != nil {
+				video := *chunk.Video
+				video.Timestamp = video.Timestamp - firstTimestamp + start
+				newChunk.Video = &video
+			}
+			if chunk.Audio != nil {
+				audio := *chunk.Audio
+				audio.Timestamp = audio.Timestamp - firstTimestamp + start
+				audio.Discontinuity = firstAudioChunk
+				firstAudioChunk = false
+				newChunk.Audio = &audio
+			}
+			result.Chunks = append(result.Chunks, newChunk)
+		}
+		// The GPS packets have their own wall-clock time, so they don't need to be moved.
+		result.GPSPackets = append(result.GPSPackets, r.info.GPSPackets...)
+
+		timelineEnd = lastTimestamp - firstTimestamp + start
+		if len(videoTimestamps) > 0 {
+			end := videoTimestamps[len(videoTimestamps)-1] + rosco.MedianInterval(videoTimestamps) - firstTimestamp + start
+			if end > timelineEnd {
+				timelineEnd = end
+			}
+		}
+		if len(audioTimestamps) > 0 {
+			end := audioTimestamps[len(audioTimestamps)-1] + rosco.MedianInterval(audioTimestamps) - firstTimestamp + start
+			if end > timelineEnd {
+				timelineEnd = end
+			}
+		}
+	}
+	if len(result.Chunks) == 0 {
+		return nil, fmt.Errorf("no audio or video in any of the recordings")
+	}
+
+	return result, nil
+}
+
+// metadataValue returns the value of the given metadata entry as a string (or "" if there isn't one).
+func metadataValue(metadata *rosco.Metadata, name string) string {
+	if metadata == nil {
+		return ""
+	}
+	entry := metadata.Entry(name)
+	if entry == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", entry.Value)
+}
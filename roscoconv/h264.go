@@ -0,0 +1,117 @@
+package roscoconv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/nareix/joy4/codec/h264parser"
+	"github.com/tekkamanendless/rosco-dashcam-processor/h264info"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// annexBStartCode is the start code that precedes each NAL unit in an H.264 byte stream.
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+// ToAnnexB converts H.264 data into a byte stream where every NAL unit is preceded by a start code.
+//
+// Length-prefixed data is converted; data that already has start codes is normalized.
+func ToAnnexB(media []byte) []byte {
+	nalus, _ := h264parser.SplitNALUs(media)
+	buffer := new(bytes.Buffer)
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		buffer.Write(annexBStartCode)
+		buffer.Write(nalu)
+	}
+	return buffer.Bytes()
+}
+
+// WriteAnnexB writes the video track as a raw H.264 (Annex-B) elementary stream.
+//
+// Every IDR frame is preceded by an SPS and PPS; if the camera left them out, then the most recent
+// ones from the stream are inserted.
+func WriteAnnexB(writer io.Writer, videoTrack *VideoTrack) error {
+	sps := videoTrack.SPS
+	pps := videoTrack.PPS
+	for _, frame := range videoTrack.Frames {
+		buffer := new(bytes.Buffer)
+		hasSPS := false
+		hasPPS := false
+		nalus, _ := h264parser.SplitNALUs(frame.Media)
+		for _, nalu := range nalus {
+			if len(nalu) == 0 {
+				continue
+			}
+			switch nalu[0] & 0x1f {
+			case 5: // IDR slice
+				if !hasSPS && sps != nil {
+					buffer.Write(annexBStartCode)
+					buffer.Write(sps)
+					hasSPS = true
+				}
+				if !hasPPS && pps != nil {
+					buffer.Write(annexBStartCode)
+					buffer.Write(pps)
+					hasPPS = true
+				}
+			case 7: // SPS
+				sps = nalu
+				hasSPS = true
+			case 8: // PPS
+				pps = nalu
+				hasPPS = true
+			}
+			buffer.Write(annexBStartCode)
+			buffer.Write(nalu)
+		}
+		_, err := writer.Write(buffer.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTimestamps writes the frame timestamps of the video track in the mkvmerge "timestamp format v2".
+//
+// The timestamps are in milliseconds, relative to the first frame.  They are written in presentation
+// order, which is what mkvmerge expects.
+func WriteTimestamps(writer io.Writer, videoTrack *VideoTrack) error {
+	timestamps := make([]uint64, len(videoTrack.Frames))
+	for i, frame := range videoTrack.Frames {
+		timestamps[i] = frame.Timestamp
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	_, err := fmt.Fprintf(writer, "# timestamp format v2\n")
+	if err != nil {
+		return err
+	}
+	for _, timestamp := range timestamps {
+		offset := timestamp - timestamps[0]
+		_, err = fmt.Fprintf(writer, "%d.%03d\n", offset/1000, offset%1000)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AnalyzeVideo summarizes the H.264 video in the given logical stream.
+func AnalyzeVideo(info *rosco.FileInfo, streamID string) (*h264info.StreamInfo, error) {
+	videoTrack, err := MakeVideoTrack(info, streamID)
+	if err != nil {
+		return nil, err
+	}
+	streamInfo := &h264info.StreamInfo{}
+	for _, frame := range videoTrack.Frames {
+		streamInfo.Add(frame.Timestamp, frame.Media)
+	}
+	return streamInfo, nil
+}
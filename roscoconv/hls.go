@@ -0,0 +1,326 @@
+package roscoconv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/mp4"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mpegts"
+)
+
+// HLSSegment is a segment of a transport stream or a fragmented MP4 file for HTTP Live Streaming (HLS).
+type HLSSegment struct {
+	Packets  []mpegts.Packet // For a transport stream.
+	Fragment *mp4.Fragment   // For a fragmented MP4 file.
+	Duration float64         // In seconds.
+	Size     int64           // In bytes; this is set when the segment is written.
+}
+
+// SegmentTS splits a transport stream into segments for HLS.
+//
+// Each segment starts at a keyframe on the PCR (video) stream, so that it can be played on its own.
+// A new segment is started at the first keyframe that is at least `targetDuration` after the start
+// of the current one, so segments can be longer than that if the keyframes are far apart.
+func SegmentTS(file *mpegts.File, targetDuration time.Duration) []*HLSSegment {
+	target := uint64(targetDuration.Seconds() * mpegts.ClockRate)
+
+	segments := []*HLSSegment{}
+	starts := []uint64{} // The start of each segment, in 90 kHz units.
+	var end uint64       // The end of the last packet that isn't video, in 90 kHz units.
+	var lastVideoPTS, videoInterval uint64
+	for _, packet := range file.Packets {
+		isVideo := file.Streams[packet.StreamIndex].IsPCR
+		if isVideo {
+			if lastVideoPTS > 0 && packet.PTS > lastVideoPTS && (videoInterval == 0 || packet.PTS-lastVideoPTS < videoInterval) {
+				videoInterval = packet.PTS - lastVideoPTS
+			}
+			lastVideoPTS = packet.PTS
+		}
+
+		// Allow for a little rounding in the timestamps (half a frame).
+		if len(segments) == 0 || (isVideo && packet.IsKeyframe && packet.PTS+videoInterval/2 >= starts[len(starts)-1]+target) {
+			start := packet.PTS
+			if len(segments) == 0 {
+				// The first segment starts with whatever comes first.
+				for _, p := range file.Packets {
+					if p.PTS < start {
+						start = p.PTS
+					}
+				}
+			}
+			segments = append(segments, &HLSSegment{})
+			starts = append(starts, start)
+		}
+		segment := segments[len(segments)-1]
+		segment.Packets = append(segment.Packets, packet)
+		if !isVideo && packet.PTS > end {
+			end = packet.PTS
+		}
+	}
+	// The last frame lasts as long as the shortest interval between frames.
+	if lastVideoPTS+videoInterval > end {
+		end = lastVideoPTS + videoInterval
+	}
+
+	for i, segment := range segments {
+		segmentEnd := end
+		if i+1 < len(segments) {
+			segmentEnd = starts[i+1]
+		}
+		segment.Duration = float64(segmentEnd-starts[i]) / mpegts.ClockRate
+	}
+	return segments
+}
+
+// SegmentMP4 splits an MP4 file into fragments for HLS.
+//
+// The segments start at keyframes on the (first) video track, in the same way as `SegmentTS`.
+// The samples of the other tracks go into the segment that covers their decode times.
+func SegmentMP4(file *mp4.File, targetDuration time.Duration) ([]*HLSSegment, error) {
+	videoTrackIndex := -1
+	for trackIndex, track := range file.Tracks {
+		if track.Handler == mp4.HandlerVideo {
+			videoTrackIndex = trackIndex
+			break
+		}
+	}
+	if videoTrackIndex < 0 {
+		return nil, fmt.Errorf("there is no video track")
+	}
+	videoTrack := file.Tracks[videoTrackIndex]
+	target := uint64(targetDuration.Seconds() * float64(videoTrack.Timescale))
+
+	// The start of each segment, in the video track's timescale; the first segment starts at the start of the movie.
+	starts := []uint64{0}
+	startSamples := []int{0} // The first video sample of each segment.
+	var videoInterval uint64
+	for _, sample := range videoTrack.Samples {
+		if videoInterval == 0 || (sample.Duration > 0 && uint64(sample.Duration) < videoInterval) {
+			videoInterval = uint64(sample.Duration)
+		}
+	}
+	decodeTime := videoTrack.StartOffset
+	for sampleIndex, sample := range videoTrack.Samples {
+		// Allow for a little rounding in the timestamps (half a frame).
+		if sampleIndex > 0 && sample.IsSync && decodeTime+videoInterval/2 >= starts[len(starts)-1]+target {
+			starts = append(starts, decodeTime)
+			startSamples = append(startSamples, sampleIndex)
+		}
+		decodeTime += uint64(sample.Duration)
+	}
+
+	segments := make([]*HLSSegment, len(starts))
+	for i := range segments {
+		segments[i] = &HLSSegment{
+			Fragment: &mp4.Fragment{
+				SequenceNumber: uint32(i + 1),
+			},
+		}
+	}
+	var end float64 // The end of the last track, in seconds.
+	for trackIndex, track := range file.Tracks {
+		segmentIndex := -1
+		decodeTime := track.StartOffset
+		for sampleIndex, sample := range track.Samples {
+			// Move on to the segment that this sample starts in.
+			for segmentIndex+1 < len(starts) {
+				var next bool
+				if trackIndex == videoTrackIndex {
+					next = sampleIndex >= startSamples[segmentIndex+1]
+				} else {
+					next = decodeTime*uint64(videoTrack.Timescale) >= starts[segmentIndex+1]*uint64(track.Timescale)
+				}
+				if !next {
+					break
+				}
+				segmentIndex++
+				segments[segmentIndex].Fragment.Tracks = append(segments[segmentIndex].Fragment.Tracks, mp4.FragmentTrack{
+					TrackIndex:     trackIndex,
+					BaseDecodeTime: decodeTime,
+				})
+			}
+			fragmentTracks := segments[segmentIndex].Fragment.Tracks
+			fragmentTracks[len(fragmentTracks)-1].Samples = append(fragmentTracks[len(fragmentTracks)-1].Samples, sample)
+			decodeTime += uint64(sample.Duration)
+		}
+		if trackEnd := float64(decodeTime) / float64(track.Timescale); trackEnd > end {
+			end = trackEnd
+		}
+	}
+
+	for i, segment := range segments {
+		segmentEnd := end
+		if i+1 < len(segments) {
+			segmentEnd = float64(starts[i+1]) / float64(videoTrack.Timescale)
+		}
+		segment.Duration = segmentEnd - float64(starts[i])/float64(videoTrack.Timescale)
+	}
+	return segments, nil
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+// Write implements `io.Writer`.
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+// WriteHLSSegment writes a segment as a transport stream.
+//
+// The same `mpegts.Writer` should be used for all of the segments of a stream (with `SetOutput` used
+// to switch between them) so that the continuity counters carry over from one segment to the next.
+// The program tables are written at the start of the segment and before every keyframe on the PCR stream.
+func WriteHLSSegment(writer io.Writer, tsWriter *mpegts.Writer, file *mpegts.File, segment *HLSSegment) error {
+	counter := &countingWriter{writer: writer}
+	tsWriter.SetOutput(counter)
+	err := tsWriter.WriteTables()
+	if err != nil {
+		return err
+	}
+	for packetIndex, packet := range segment.Packets {
+		if packetIndex > 0 && packet.IsKeyframe && file.Streams[packet.StreamIndex].IsPCR {
+			err = tsWriter.WriteTables()
+			if err != nil {
+				return err
+			}
+		}
+		err = tsWriter.WritePacket(packet)
+		if err != nil {
+			return err
+		}
+	}
+	segment.Size = counter.count
+	return nil
+}
+
+// WriteHLSFragment writes a segment as a fragment of an MP4 file.
+func WriteHLSFragment(writer io.Writer, segment *HLSSegment) error {
+	counter := &countingWriter{writer: writer}
+	err := mp4.WriteFragment(counter, segment.Fragment)
+	if err != nil {
+		return err
+	}
+	segment.Size = counter.count
+	return nil
+}
+
+// WriteHLSMediaPlaylist writes a video-on-demand media playlist for the segments.
+//
+// The URIs are the locations of the segments, relative to the playlist.
+// For fragmented MP4 segments, the map URI is the location of the initialization segment; otherwise, it is empty.
+func WriteHLSMediaPlaylist(writer io.Writer, segments []*HLSSegment, uris []string, mapURI string) error {
+	if len(segments) != len(uris) {
+		return fmt.Errorf("there are %d segments but %d URIs", len(segments), len(uris))
+	}
+	targetDuration := 1
+	for _, segment := range segments {
+		if duration := int(math.Round(segment.Duration)); duration > targetDuration {
+			targetDuration = duration
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "#EXTM3U\n")
+	if mapURI != "" {
+		fmt.Fprintf(buffer, "#EXT-X-VERSION:7\n")
+	} else {
+		fmt.Fprintf(buffer, "#EXT-X-VERSION:3\n")
+	}
+	fmt.Fprintf(buffer, "#EXT-X-TARGETDURATION:%d\n", targetDuration)
+	fmt.Fprintf(buffer, "#EXT-X-MEDIA-SEQUENCE:0\n")
+	fmt.Fprintf(buffer, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+	if mapURI != "" {
+		fmt.Fprintf(buffer, "#EXT-X-MAP:URI=\"%s\"\n", mapURI)
+	}
+	for i, segment := range segments {
+		fmt.Fprintf(buffer, "#EXTINF:%.3f,\n", segment.Duration)
+		fmt.Fprintf(buffer, "%s\n", uris[i])
+	}
+	fmt.Fprintf(buffer, "#EXT-X-ENDLIST\n")
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+// HLSVariant is a media playlist in a master playlist.
+type HLSVariant struct {
+	URI       string // The location of the media playlist, relative to the master playlist.
+	Name      string // A name for people to read.
+	Bandwidth int64  // The peak bitrate of the segments, in bits per second.
+	Width     int
+	Height    int
+	Codecs    string // For example, "avc1.42c01e,Opus".
+}
+
+// These are the RFC 6381 names of Opus audio in each kind of HLS segment.
+const (
+	HLSAudioCodecTS   = "opus"
+	HLSAudioCodecFMP4 = "Opus"
+)
+
+// NewHLSVariant describes the segments of a media playlist.
+//
+// The audio codec is one of the "HLSAudioCodec" constants, or empty if there is no audio.
+// The segments must have been written, so that their sizes are known.
+func NewHLSVariant(uri string, name string, videoTrack *VideoTrack, audioCodec string, segments []*HLSSegment) HLSVariant {
+	variant := HLSVariant{
+		URI:    uri,
+		Name:   name,
+		Width:  videoTrack.Width,
+		Height: videoTrack.Height,
+		Codecs: HLSVideoCodec(videoTrack),
+	}
+	if audioCodec != "" {
+		variant.Codecs += "," + audioCodec
+	}
+	for _, segment := range segments {
+		if segment.Duration <= 0 {
+			continue
+		}
+		if bandwidth := int64(math.Ceil(float64(segment.Size*8) / segment.Duration)); bandwidth > variant.Bandwidth {
+			variant.Bandwidth = bandwidth
+		}
+	}
+	return variant
+}
+
+// HLSVideoCodec returns the RFC 6381 codec name of the video (for example, "avc1.42c01e").
+func HLSVideoCodec(videoTrack *VideoTrack) string {
+	if len(videoTrack.SPS) < 4 {
+		return "avc1"
+	}
+	return fmt.Sprintf("avc1.%02x%02x%02x", videoTrack.SPS[1], videoTrack.SPS[2], videoTrack.SPS[3])
+}
+
+// WriteHLSMasterPlaylist writes a master playlist that lists the given media playlists.
+//
+// The variants must all be renditions of the same video, since a player can switch between them at any time.
+func WriteHLSMasterPlaylist(writer io.Writer, variants []HLSVariant) error {
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "#EXTM3U\n")
+	fmt.Fprintf(buffer, "#EXT-X-VERSION:3\n")
+	fmt.Fprintf(buffer, "#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, variant := range variants {
+		if variant.Name != "" {
+			fmt.Fprintf(buffer, "# %s\n", variant.Name)
+		}
+		fmt.Fprintf(buffer, "#EXT-X-STREAM-INF:BANDWIDTH=%d", variant.Bandwidth)
+		if variant.Width > 0 && variant.Height > 0 {
+			fmt.Fprintf(buffer, ",RESOLUTION=%dx%d", variant.Width, variant.Height)
+		}
+		if variant.Codecs != "" {
+			fmt.Fprintf(buffer, ",CODECS=\"%s\"", variant.Codecs)
+		}
+		fmt.Fprintf(buffer, "\n%s\n", variant.URI)
+	}
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
@@ -0,0 +1,206 @@
+package roscoconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nareix/joy4/codec/h264parser"
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mkv"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// opusSeekPreRoll is the recommended seek pre-roll for Opus in Matroska.
+const opusSeekPreRoll = 80 * time.Millisecond
+
+// AudioStreamIDs returns the IDs of all of the substreams of the given logical stream that contain audio.
+func AudioStreamIDs(info *rosco.FileInfo, streamID string) []string {
+	audioStreamIDs := []string{}
+	for _, id := range info.StreamIDs() {
+		if !strings.HasPrefix(id, streamID) {
+			continue
+		}
+		for _, chunk := range info.ChunksForStreamID(id) {
+			if chunk.Audio != nil {
+				audioStreamIDs = append(audioStreamIDs, id)
+				break
+			}
+		}
+	}
+	return audioStreamIDs
+}
+
+// MakeMKV creates an `mkv.File` instance based on this `rosco.FileInfo` one.
+//
+// Every logical stream's video becomes its own track, as does every audio stream.
+// Opus audio is passed through as-is; PCM audio is expanded to 16-bit linear PCM.
+//
+// If `includeMetadata` is true, then each video track also gets a text track with its per-frame metadata.
+func MakeMKV(info *rosco.FileInfo, streamIDs []string, includeMetadata bool) (*mkv.File, error) {
+	videoTracks := []*VideoTrack{}
+	audioTracks := []*AudioTrack{}
+	for _, streamID := range streamIDs {
+		videoTrack, err := MakeVideoTrack(info, streamID)
+		if err != nil {
+			logrus.Warnf("Could not find any video for stream %s: %v", streamID, err)
+		} else {
+			videoTracks = append(videoTracks, videoTrack)
+		}
+
+		for _, audioStreamID := range AudioStreamIDs(info, streamID) {
+			audioTrack, err := MakeAudioTrack(info, audioStreamID)
+			if err != nil {
+				return nil, err
+			}
+			audioTracks = append(audioTracks, audioTrack)
+		}
+	}
+	if len(videoTracks) == 0 && len(audioTracks) == 0 {
+		return nil, fmt.Errorf("no audio or video in streams: %s", strings.Join(streamIDs, ", "))
+	}
+
+	// Line up all of the tracks on the earliest timestamp.
+	var baseTimestamp uint64
+	baseTimestampSet := false
+	for _, videoTrack := range videoTracks {
+		for _, frame := range videoTrack.Frames {
+			if !baseTimestampSet || frame.Timestamp < baseTimestamp {
+				baseTimestamp = frame.Timestamp
+				baseTimestampSet = true
+			}
+		}
+	}
+	for _, audioTrack := range audioTracks {
+		if !baseTimestampSet || audioTrack.Frames[0].Timestamp < baseTimestamp {
+			baseTimestamp = audioTrack.Frames[0].Timestamp
+			baseTimestampSet = true
+		}
+	}
+	relativeTime := func(timestamp uint64) time.Duration {
+		return time.Duration(timestamp-baseTimestamp) * time.Microsecond
+	}
+
+	file := &mkv.File{
+		Title:      path.Base(info.Filename),
+		MuxingApp:  Originator,
+		WritingApp: Originator,
+	}
+	if startTime, okay := info.Time(baseTimestamp); okay {
+		file.DateUTC = startTime.UTC()
+	}
+
+	for videoTrackIndex, videoTrack := range videoTracks {
+		if videoTrack.SPS == nil || videoTrack.PPS == nil {
+			return nil, fmt.Errorf("could not find the SPS and PPS for stream %s", videoTrack.StreamID)
+		}
+		codecData, err := h264parser.NewCodecDataFromSPSAndPPS(videoTrack.SPS, videoTrack.PPS)
+		if err != nil {
+			return nil, fmt.Errorf("could not create the AVC configuration: %v", err)
+		}
+
+		track := &mkv.Track{
+			Type:         mkv.TrackTypeVideo,
+			CodecID:      mkv.CodecH264,
+			CodecPrivate: codecData.AVCDecoderConfRecordBytes(),
+			Name:         fmt.Sprintf("Camera %s", videoTrack.StreamID),
+			Language:     "und",
+			IsDefault:    videoTrackIndex == 0,
+			PixelWidth:   videoTrack.Width,
+			PixelHeight:  videoTrack.Height,
+		}
+		for _, frame := range videoTrack.Frames {
+			track.Blocks = append(track.Blocks, mkv.Block{
+				Timestamp:  relativeTime(frame.Timestamp),
+				IsKeyframe: frame.IsKeyframe,
+				Data:       AnnexBToAVCC(frame.Media),
+			})
+		}
+		file.Tracks = append(file.Tracks, track)
+	}
+
+	for audioTrackIndex, audioTrack := range audioTracks {
+		track := &mkv.Track{
+			Type:      mkv.TrackTypeAudio,
+			Name:      fmt.Sprintf("Audio %s", audioTrack.StreamID),
+			Language:  "und",
+			IsDefault: audioTrackIndex == 0,
+			Channels:  audioTrack.ChannelCount,
+		}
+		switch audioTrack.Codec {
+		case AudioCodecOpus:
+			track.CodecID = mkv.CodecOpus
+			track.CodecPrivate = OpusHead(audioTrack)
+			track.SeekPreRoll = opusSeekPreRoll
+			track.SamplingFrequency = OpusSampleRate
+		case AudioCodecPCM:
+			track.CodecID = mkv.CodecPCM
+			track.SamplingFrequency = float64(audioTrack.SampleRate)
+			track.BitDepth = 16
+		default:
+			return nil, fmt.Errorf("unsupported audio codec: %s", audioTrack.Codec)
+		}
+		for _, frame := range audioTrack.Frames {
+			data := frame.Media
+			if audioTrack.Codec == AudioCodecPCM {
+				samples, err := DecodePCMSamples(frame.Media, audioTrack.BitDepth, audioTrack.FormatTag)
+				if err != nil {
+					return nil, err
+				}
+				buffer := new(bytes.Buffer)
+				binary.Write(buffer, binary.LittleEndian, samples)
+				data = buffer.Bytes()
+			}
+			track.Blocks = append(track.Blocks, mkv.Block{
+				Timestamp:  relativeTime(frame.Timestamp),
+				IsKeyframe: true,
+				Data:       data,
+			})
+		}
+		file.Tracks = append(file.Tracks, track)
+	}
+
+	if includeMetadata {
+		for _, videoTrack := range videoTracks {
+			track := &mkv.Track{
+				Type:     mkv.TrackTypeSubtitle,
+				CodecID:  mkv.CodecTextUTF8,
+				Name:     fmt.Sprintf("Camera %s metadata", videoTrack.StreamID),
+				Language: "und",
+			}
+			for frameIndex, frame := range videoTrack.Frames {
+				text := FormatMetadata(frame.Metadata)
+				if text == "" {
+					continue
+				}
+				duration := time.Second
+				if frameIndex+1 < len(videoTrack.Frames) && videoTrack.Frames[frameIndex+1].Timestamp > frame.Timestamp {
+					duration = relativeTime(videoTrack.Frames[frameIndex+1].Timestamp) - relativeTime(frame.Timestamp)
+				}
+				track.Blocks = append(track.Blocks, mkv.Block{
+					Timestamp:  relativeTime(frame.Timestamp),
+					Duration:   duration,
+					IsKeyframe: true,
+					Data:       []byte(text),
+				})
+			}
+			if len(track.Blocks) > 0 {
+				file.Tracks = append(file.Tracks, track)
+			}
+		}
+	}
+
+	return file, nil
+}
+
+// FormatMetadata returns a human-readable version of the metadata, one "name = value" entry per line.
+func FormatMetadata(metadata *rosco.Metadata) string {
+	lines := []string{}
+	for _, entry := range metadata.Flatten() {
+		lines = append(lines, fmt.Sprintf("%s = %v", entry.Name, entry.Value))
+	}
+	return strings.Join(lines, "\n")
+}
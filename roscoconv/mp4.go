@@ -0,0 +1,181 @@
+package roscoconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/nareix/joy4/codec/h264parser"
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mp4"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// VideoTimescale is the timescale that we use for video tracks (the usual 90 kHz clock).
+const VideoTimescale = 90000
+
+// MakeMP4 creates an `mp4.File` instance based on this `rosco.FileInfo` one.
+//
+// Stream ID is the ID of the stream to export.
+func MakeMP4(info *rosco.FileInfo, streamID string) (*mp4.File, error) {
+	videoTrack, err := MakeVideoTrack(info, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	audioTrack, err := MakeAudioTrack(info, streamID)
+	if err != nil {
+		logrus.Warnf("Could not find any audio for stream %s: %v", streamID, err)
+		audioTrack = nil
+	}
+	if audioTrack != nil {
+		audioTrack, err = EncodeOpus(audioTrack)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file := &mp4.File{}
+	if startTime, okay := info.StartTime(); okay {
+		file.CreationTime = startTime
+	}
+
+	// Line up all of the tracks on the earliest timestamp.
+	baseTimestamp := videoTrack.Frames[0].Timestamp
+	for _, frame := range videoTrack.Frames {
+		if frame.Timestamp < baseTimestamp {
+			baseTimestamp = frame.Timestamp
+		}
+	}
+	if audioTrack != nil && audioTrack.Frames[0].Timestamp < baseTimestamp {
+		baseTimestamp = audioTrack.Frames[0].Timestamp
+	}
+
+	track, err := MakeMP4VideoTrack(videoTrack, baseTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	file.Tracks = append(file.Tracks, track)
+
+	if audioTrack != nil {
+		file.Tracks = append(file.Tracks, MakeMP4AudioTrack(audioTrack, baseTimestamp))
+	}
+
+	return file, nil
+}
+
+// MakeMP4VideoTrack creates an `mp4.Track` from a video track.
+//
+// The frames are in decode order; their timestamps are presentation times.  The decode times are
+// the sorted presentation times, and any difference between the two ends up in the "ctts" box.
+//
+// Base timestamp is the timestamp (in microseconds) that corresponds to the start of the movie.
+func MakeMP4VideoTrack(videoTrack *VideoTrack, baseTimestamp uint64) (*mp4.Track, error) {
+	if videoTrack.SPS == nil || videoTrack.PPS == nil {
+		return nil, fmt.Errorf("could not find the SPS and PPS for stream %s", videoTrack.StreamID)
+	}
+	codecData, err := h264parser.NewCodecDataFromSPSAndPPS(videoTrack.SPS, videoTrack.PPS)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the AVC configuration: %v", err)
+	}
+
+	presentationTimes := make([]uint64, len(videoTrack.Frames))
+	for i, frame := range videoTrack.Frames {
+		presentationTimes[i] = (frame.Timestamp - baseTimestamp) * VideoTimescale / 1000000
+	}
+	decodeTimes := make([]uint64, len(presentationTimes))
+	copy(decodeTimes, presentationTimes)
+	sort.Slice(decodeTimes, func(i, j int) bool {
+		return decodeTimes[i] < decodeTimes[j]
+	})
+
+	track := &mp4.Track{
+		Handler:     mp4.HandlerVideo,
+		Name:        fmt.Sprintf("Camera %s", videoTrack.StreamID),
+		Timescale:   VideoTimescale,
+		Width:       videoTrack.Width,
+		Height:      videoTrack.Height,
+		StartOffset: decodeTimes[0],
+		SampleEntry: mp4.AVCSampleEntry(videoTrack.Width, videoTrack.Height, codecData.AVCDecoderConfRecordBytes()),
+	}
+
+	defaultDuration := uint32(VideoTimescale / 30)
+	for i, frame := range videoTrack.Frames {
+		duration := defaultDuration
+		if i+1 < len(decodeTimes) {
+			duration = uint32(decodeTimes[i+1] - decodeTimes[i])
+			defaultDuration = duration
+		}
+		track.Samples = append(track.Samples, mp4.Sample{
+			Data:              AnnexBToAVCC(frame.Media),
+			Duration:          duration,
+			CompositionOffset: int32(int64(presentationTimes[i]) - int64(decodeTimes[i])),
+			IsSync:            frame.IsKeyframe,
+		})
+	}
+
+	return track, nil
+}
+
+// MakeMP4AudioTrack creates an `mp4.Track` from an Opus audio track.
+//
+// Base timestamp is the timestamp (in microseconds) that corresponds to the start of the movie.
+func MakeMP4AudioTrack(audioTrack *AudioTrack, baseTimestamp uint64) *mp4.Track {
+	track := &mp4.Track{
+		Handler:     mp4.HandlerAudio,
+		Name:        fmt.Sprintf("Audio %s", audioTrack.StreamID),
+		Timescale:   OpusSampleRate,
+		StartOffset: (audioTrack.Frames[0].Timestamp - baseTimestamp) * OpusSampleRate / 1000000,
+		SampleEntry: mp4.OpusSampleEntry(audioTrack.ChannelCount, 0, audioTrack.SampleRate),
+	}
+	// This is where the audio would be if every packet followed right on from the last one.
+	position := audioTrack.Frames[0].Timestamp * OpusSampleRate / 1000000
+	for _, frame := range audioTrack.Frames {
+		samples, err := OpusPacketSamples(frame.Media)
+		if err != nil {
+			logrus.Debugf("Could not determine the Opus packet duration: %v", err)
+			samples = opusFrameSizeMs * OpusSampleRate / 1000
+		}
+		// If there is a gap of more than a packet before this one, then fill it with silent packets
+		// so that the rest of the audio stays in sync.
+		framePosition := frame.Timestamp * OpusSampleRate / 1000000
+		if len(track.Samples) > 0 && framePosition > position+uint64(samples) {
+			silenceSamples := uint64(opusFrameSizeMs * OpusSampleRate / 1000)
+			for framePosition >= position+silenceSamples {
+				track.Samples = append(track.Samples, mp4.Sample{
+					Data:     OpusSilence(),
+					Duration: uint32(silenceSamples),
+					IsSync:   true,
+				})
+				position += silenceSamples
+			}
+		}
+		position += uint64(samples)
+		track.Samples = append(track.Samples, mp4.Sample{
+			Data:     frame.Media,
+			Duration: uint32(samples),
+			IsSync:   true,
+		})
+	}
+	return track
+}
+
+// AnnexBToAVCC converts H.264 data with start codes into data with 4-byte length prefixes.
+//
+// If the data is already length-prefixed, it is returned as-is.
+func AnnexBToAVCC(media []byte) []byte {
+	nalus, naluType := h264parser.SplitNALUs(media)
+	if naluType == h264parser.NALU_AVCC {
+		return media
+	}
+	buffer := new(bytes.Buffer)
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+		binary.Write(buffer, binary.BigEndian, uint32(len(nalu)))
+		buffer.Write(nalu)
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,188 @@
+package roscoconv
+
+import (
+	"fmt"
+
+	"github.com/hraban/opus"
+)
+
+// OpusSampleRate is the sample rate that Opus always uses for timing.
+const OpusSampleRate = 48000
+
+// opusFrameSizeMs is the size of each Opus frame that we encode.
+const opusFrameSizeMs = 20
+
+// OpusPacketSamples returns the number of samples (at 48 kHz) in an Opus packet.
+//
+// This is based on the TOC byte; see RFC 6716, section 3.1.
+func OpusPacketSamples(packet []byte) (int, error) {
+	if len(packet) == 0 {
+		return 0, fmt.Errorf("empty Opus packet")
+	}
+	toc := packet[0]
+	config := int(toc >> 3)
+
+	// The frame size, in units of 1/10 of a millisecond.
+	var frameSize int
+	switch {
+	case config < 12: // SILK
+		frameSize = []int{100, 200, 400, 600}[config%4]
+	case config < 16: // Hybrid
+		frameSize = []int{100, 200}[config%2]
+	default: // CELT
+		frameSize = []int{25, 50, 100, 200}[config%4]
+	}
+
+	var frameCount int
+	switch toc & 0x03 {
+	case 0:
+		frameCount = 1
+	case 1, 2:
+		frameCount = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0, fmt.Errorf("truncated Opus packet")
+		}
+		frameCount = int(packet[1] & 0x3f)
+	}
+
+	return frameCount * frameSize * OpusSampleRate / 10000, nil
+}
+
+// OpusSilence returns an Opus packet that contains 20 ms of silence.
+//
+// This is a single CELT fullband 20 ms frame with no data, which decoders treat as silence.
+func OpusSilence() []byte {
+	return []byte{31<<3 | 0}
+}
+
+// OpusHead returns the "OpusHead" identification header for a track; see RFC 7845, section 5.1.
+//
+// This is used as the codec private data for Matroska, and its fields are reused for the MP4 "dOps" box.
+func OpusHead(track *AudioTrack) []byte {
+	head := []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 1, byte(track.ChannelCount), 0, 0}
+	inputSampleRate := uint32(track.SampleRate)
+	head = append(head, byte(inputSampleRate), byte(inputSampleRate>>8), byte(inputSampleRate>>16), byte(inputSampleRate>>24))
+	head = append(head, 0, 0) // Output gain.
+	head = append(head, 0)    // Channel mapping family.
+	return head
+}
+
+// DecodePCMSamples converts raw PCM audio data into signed 16-bit samples.
+//
+// 8-bit mu-law data is expanded, and other 8-bit data is unsigned (as in WAV files);
+// 16-bit data is assumed to be little-endian linear PCM.
+func DecodePCMSamples(data []byte, bitDepth int, formatTag int) ([]int16, error) {
+	switch bitDepth {
+	case 8:
+		samples := make([]int16, len(data))
+		for i, value := range data {
+			if formatTag == 0x0007 {
+				samples[i] = muLawDecode(value)
+			} else {
+				samples[i] = (int16(value) - 128) << 8
+			}
+		}
+		return samples, nil
+	case 16:
+		samples := make([]int16, len(data)/2)
+		for i := range samples {
+			samples[i] = int16(uint16(data[2*i]) | uint16(data[2*i+1])<<8)
+		}
+		return samples, nil
+	default:
+		return nil, fmt.Errorf("Unsupported bit depth: %d", bitDepth)
+	}
+}
+
+// muLawDecode expands a G.711 mu-law byte into a signed 16-bit sample.
+func muLawDecode(value byte) int16 {
+	value = ^value
+	sign := value & 0x80
+	exponent := (value >> 4) & 0x07
+	mantissa := value & 0x0f
+	sample := ((int16(mantissa) << 3) + 0x84) << exponent
+	sample -= 0x84
+	if sign != 0 {
+		return -sample
+	}
+	return sample
+}
+
+// EncodeOpus converts a PCM audio track into an Opus audio track.
+//
+// Many containers (and browsers) do not support the raw PCM that the cameras record,
+// so this lets us provide audio everywhere.
+func EncodeOpus(track *AudioTrack) (*AudioTrack, error) {
+	if track.Codec == AudioCodecOpus {
+		return track, nil
+	}
+	if track.Codec != AudioCodecPCM {
+		return nil, fmt.Errorf("unsupported audio codec: %s", track.Codec)
+	}
+
+	encoder, err := opus.NewEncoder(track.SampleRate, track.ChannelCount, opus.AppVoIP)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the Opus encoder: %v", err)
+	}
+
+	newTrack := &AudioTrack{
+		StreamID:     track.StreamID,
+		Codec:        AudioCodecOpus,
+		SampleRate:   track.SampleRate,
+		ChannelCount: track.ChannelCount,
+		BitDepth:     16,
+	}
+
+	frameSamples := track.ChannelCount * opusFrameSizeMs * track.SampleRate / 1000
+	pending := []int16{}
+	var pendingTimestamp uint64
+	output := make([]byte, 4000)
+	for _, frame := range track.Frames {
+		samples, err := DecodePCMSamples(frame.Media, track.BitDepth, track.FormatTag)
+		if err != nil {
+			return nil, err
+		}
+		if len(pending) == 0 {
+			pendingTimestamp = frame.Timestamp
+		}
+		// If there is a gap of more than a frame before this one, then fill it with silence.
+		expectedTimestamp := pendingTimestamp + uint64(len(pending))*1000000/uint64(track.SampleRate*track.ChannelCount)
+		if frame.Timestamp > expectedTimestamp+uint64(opusFrameSizeMs*1000) {
+			silentSamples := (frame.Timestamp - expectedTimestamp) * uint64(track.SampleRate) / 1000000 * uint64(track.ChannelCount)
+			pending = append(pending, make([]int16, silentSamples)...)
+		}
+		pending = append(pending, samples...)
+
+		for len(pending) >= frameSamples {
+			count, err := encoder.Encode(pending[0:frameSamples], output)
+			if err != nil {
+				return nil, fmt.Errorf("could not encode Opus frame: %v", err)
+			}
+			packet := make([]byte, count)
+			copy(packet, output[0:count])
+			newTrack.Frames = append(newTrack.Frames, AudioFrame{
+				Timestamp: pendingTimestamp,
+				Media:     packet,
+			})
+			pending = pending[frameSamples:]
+			pendingTimestamp += opusFrameSizeMs * 1000
+		}
+	}
+	if len(pending) > 0 {
+		// Pad out the last frame with silence.
+		pending = append(pending, make([]int16, frameSamples-len(pending))...)
+		count, err := encoder.Encode(pending, output)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode Opus frame: %v", err)
+		}
+		packet := make([]byte, count)
+		copy(packet, output[0:count])
+		newTrack.Frames = append(newTrack.Frames, AudioFrame{
+			Timestamp: pendingTimestamp,
+			Media:     packet,
+		})
+	}
+
+	return newTrack, nil
+}
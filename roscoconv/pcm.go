@@ -13,11 +13,32 @@ import (
 // For some reason, Opus likes to have all of its packets decoded by the same instance.
 var globalDecoder *opus.Decoder
 
+// NewOpusDecoder creates an Opus decoder for a single audio stream.
+//
+// Each stream needs its own decoder, since the decoder state carries over from one packet to the next.
+func NewOpusDecoder() (*opus.Decoder, error) {
+	return opus.NewDecoder(OpusSampleRate, 1)
+}
+
 // MakePCM creates an `audio.IntBuffer` instance based on the raw data.
 //
 // If `rawPCM` is true, then the data will be interpreted as raw PCM data.
-// Otherwise, it will be interpreted as Opus data.
+// Otherwise, it will be interpreted as Opus data, using the shared decoder.
 func MakePCM(data []byte, rawPCM bool, bitDepth int) (*audio.IntBuffer, error) {
+	if !rawPCM && globalDecoder == nil {
+		decoder, err := NewOpusDecoder()
+		if err != nil {
+			return nil, err
+		}
+		globalDecoder = decoder
+	}
+	return DecodePCM(data, rawPCM, bitDepth, globalDecoder)
+}
+
+// DecodePCM creates an `audio.IntBuffer` instance based on the raw data.
+//
+// This is the same as `MakePCM`, except that Opus data is decoded with the given decoder.
+func DecodePCM(data []byte, rawPCM bool, bitDepth int, decoder *opus.Decoder) (*audio.IntBuffer, error) {
 	var intBuffer *audio.IntBuffer
 
 	if rawPCM {
@@ -72,18 +93,6 @@ func MakePCM(data []byte, rawPCM bool, bitDepth int) (*audio.IntBuffer, error) {
 			SourceBitDepth: 16,
 		}
 
-		var decoder *opus.Decoder
-		if globalDecoder == nil {
-			var err error
-			decoder, err = opus.NewDecoder(sampleRate, channelCount)
-			if err != nil {
-				return nil, err
-			}
-			globalDecoder = decoder
-		} else {
-			decoder = globalDecoder
-		}
-
 		frameSizeMs := 60 // if you don't know, go with 60 ms.
 		frameSize := channelCount * frameSizeMs * sampleRate / 1000
 		pcm := make([]int16, int(frameSize))
@@ -92,8 +101,9 @@ func MakePCM(data []byte, rawPCM bool, bitDepth int) (*audio.IntBuffer, error) {
 			return nil, err
 		}
 
-		intBuffer.Data = make([]int, 0, pcmSize)
-		for _, value := range pcm {
+		// Only keep the samples that were decoded.
+		intBuffer.Data = make([]int, 0, pcmSize*channelCount)
+		for _, value := range pcm[0 : pcmSize*channelCount] {
 			intBuffer.Data = append(intBuffer.Data, int(value))
 		}
 	}
@@ -0,0 +1,191 @@
+package roscoconv
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// SubtitleCue is a single subtitle.
+type SubtitleCue struct {
+	Start time.Duration // From the start of the video.
+	End   time.Duration // From the start of the video.
+	Text  string
+}
+
+// MakeSubtitles creates one subtitle per second of the video, showing the wall-clock time, the speed,
+// and the GPS position.
+//
+// The subtitles line up with the video exported from the given streams.  AVI files start at the first
+// video frame, so `videoOnly` should be true for them; the other formats start at the first video frame
+// or audio chunk, whichever comes first.
+func MakeSubtitles(info *rosco.FileInfo, streamIDs []string, videoOnly bool) ([]SubtitleCue, error) {
+	var startTimestamp, endTimestamp uint64
+	haveTimestamps := false
+	for _, streamID := range streamIDs {
+		videoTrack, err := MakeVideoTrack(info, streamID)
+		if err != nil {
+			return nil, err
+		}
+		interval := rosco.MedianInterval(frameTimestamps(videoTrack))
+		for _, frame := range videoTrack.Frames {
+			if !haveTimestamps || frame.Timestamp < startTimestamp {
+				startTimestamp = frame.Timestamp
+			}
+			if !haveTimestamps || frame.Timestamp+interval > endTimestamp {
+				endTimestamp = frame.Timestamp + interval
+			}
+			haveTimestamps = true
+		}
+		if videoOnly {
+			continue
+		}
+		if audioTrack, err := MakeAudioTrack(info, streamID); err == nil && audioTrack.Frames[0].Timestamp < startTimestamp {
+			startTimestamp = audioTrack.Frames[0].Timestamp
+		}
+	}
+	if !haveTimestamps {
+		return nil, fmt.Errorf("no video to make subtitles for")
+	}
+
+	camera := ""
+	if len(streamIDs) > 0 && len(streamIDs[0]) > 0 {
+		camera = streamIDs[0][0:1]
+	}
+	telemetry := primaryTelemetry(info.Telemetry(), camera)
+
+	cues := []SubtitleCue{}
+	telemetryIndex := -1
+	for cueTimestamp := startTimestamp; cueTimestamp < endTimestamp; cueTimestamp += 1000000 {
+		// Use the latest telemetry at or before the start of the cue.
+		for telemetryIndex+1 < len(telemetry) && telemetry[telemetryIndex+1].Timestamp <= cueTimestamp {
+			telemetryIndex++
+		}
+		var current *rosco.Telemetry
+		if telemetryIndex >= 0 {
+			current = &telemetry[telemetryIndex]
+		} else if len(telemetry) > 0 && telemetry[0].Timestamp < cueTimestamp+1000000 {
+			current = &telemetry[0]
+		}
+
+		lines := []string{}
+		wallClock, okay := info.Time(cueTimestamp)
+		if !okay && current != nil && !current.Time.IsZero() {
+			wallClock = current.Time.Add(time.Duration(int64(cueTimestamp)-int64(current.Timestamp)) * time.Microsecond)
+			okay = true
+		}
+		if okay {
+			lines = append(lines, wallClock.Round(time.Second).Format("2006-01-02 15:04:05"))
+		}
+		if current != nil {
+			parts := []string{}
+			if current.HasSpeed {
+				parts = append(parts, fmt.Sprintf("%.0f mph", current.Speed))
+			}
+			if current.HasPosition {
+				parts = append(parts, fmt.Sprintf("%.5f, %.5f", current.Latitude, current.Longitude))
+			}
+			if len(parts) > 0 {
+				lines = append(lines, strings.Join(parts, "  "))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		cueEnd := cueTimestamp + 1000000
+		if cueEnd > endTimestamp {
+			cueEnd = endTimestamp
+		}
+		cues = append(cues, SubtitleCue{
+			Start: time.Duration(cueTimestamp-startTimestamp) * time.Microsecond,
+			End:   time.Duration(cueEnd-startTimestamp) * time.Microsecond,
+			Text:  strings.Join(lines, "\n"),
+		})
+	}
+	return cues, nil
+}
+
+// frameTimestamps returns the timestamps of the frames in a video track.
+func frameTimestamps(videoTrack *VideoTrack) []uint64 {
+	timestamps := make([]uint64, len(videoTrack.Frames))
+	for i, frame := range videoTrack.Frames {
+		timestamps[i] = frame.Timestamp
+	}
+	return timestamps
+}
+
+// primaryTelemetry picks the telemetry from a single source, so that nothing is repeated.
+//
+// The GPS packets are used if there are any; otherwise, the frames from the given camera are used
+// (or the frames from the first stream with any telemetry, if that camera has none).
+func primaryTelemetry(telemetry []rosco.Telemetry, camera string) []rosco.Telemetry {
+	haveGPS := false
+	cameras := map[string]bool{}
+	firstCamera := ""
+	for _, t := range telemetry {
+		if t.StreamID == rosco.TelemetryStreamGPS {
+			haveGPS = true
+			continue
+		}
+		cameras[t.StreamID[0:1]] = true
+		if firstCamera == "" {
+			firstCamera = t.StreamID[0:1]
+		}
+	}
+
+	result := []rosco.Telemetry{}
+	for _, t := range telemetry {
+		switch {
+		case haveGPS:
+			if t.StreamID != rosco.TelemetryStreamGPS {
+				continue
+			}
+		case cameras[camera]:
+			if !strings.HasPrefix(t.StreamID, camera) {
+				continue
+			}
+		default:
+			if !strings.HasPrefix(t.StreamID, firstCamera) {
+				continue
+			}
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// WriteSRT writes the subtitles in the SubRip (SRT) format.
+func WriteSRT(writer io.Writer, cues []SubtitleCue) error {
+	for i, cue := range cues {
+		_, err := fmt.Fprintf(writer, "%d\n%s --> %s\n%s\n\n", i+1, subtitleTime(cue.Start, ","), subtitleTime(cue.End, ","), cue.Text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteWebVTT writes the subtitles in the WebVTT format.
+func WriteWebVTT(writer io.Writer, cues []SubtitleCue) error {
+	_, err := fmt.Fprintf(writer, "WEBVTT\n\n")
+	if err != nil {
+		return err
+	}
+	for _, cue := range cues {
+		_, err := fmt.Fprintf(writer, "%s --> %s\n%s\n\n", subtitleTime(cue.Start, "."), subtitleTime(cue.End, "."), cue.Text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subtitleTime formats a time as "hh:mm:ss,mmm" (SRT) or "hh:mm:ss.mmm" (WebVTT).
+func subtitleTime(value time.Duration, separator string) string {
+	milliseconds := value.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", milliseconds/3600000, milliseconds/60000%60, milliseconds/1000%60, separator, milliseconds%1000)
+}
@@ -0,0 +1,160 @@
+package roscoconv
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// The columns that every telemetry row has, ahead of the metadata columns.
+const (
+	TelemetryColumnStreamID  = "stream_id"
+	TelemetryColumnTimestamp = "timestamp_us"
+	TelemetryColumnTime      = "time"
+)
+
+// TelemetryTable is the telemetry from a recording, flattened into a table.
+type TelemetryTable struct {
+	Columns []string // The metadata columns, in the order that they were first seen.
+	Rows    []TelemetryRow
+}
+
+// TelemetryRow is a single video frame or GPS packet in a telemetry table.
+type TelemetryRow struct {
+	StreamID  string                 // The stream of the video frame, or `rosco.TelemetryStreamGPS`.
+	Timestamp uint64                 // In microseconds, relative to the start of the recording.
+	Time      time.Time              // The wall-clock time (zero if unknown).
+	Values    map[string]interface{} // The flattened metadata, by column name.
+}
+
+// MakeTelemetryTable flattens all of the telemetry in a recording into a table, with one row per video
+// frame or GPS packet, in timestamp order.
+//
+// Sub-metadata entries are given dotted names (for example, "gps.lat").  Video frames without any
+// metadata are left out.
+func MakeTelemetryTable(info *rosco.FileInfo) *TelemetryTable {
+	table := &TelemetryTable{}
+	seen := map[string]bool{}
+	// The chunk timestamps don't always start at zero (for example, DVXC4 files use the device's clock).
+	firstTimestamp, _ := FirstTimestamp(info)
+	for _, t := range info.Telemetry() {
+		var timestamp uint64
+		if t.Timestamp > firstTimestamp {
+			timestamp = t.Timestamp - firstTimestamp
+		}
+		row := TelemetryRow{
+			StreamID:  t.StreamID,
+			Timestamp: timestamp,
+			Time:      t.Time,
+			Values:    map[string]interface{}{},
+		}
+		for _, entry := range t.Metadata.Flatten() {
+			if !seen[entry.Name] {
+				seen[entry.Name] = true
+				table.Columns = append(table.Columns, entry.Name)
+			}
+			row.Values[entry.Name] = entry.Value
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// telemetryTime formats the wall-clock time of a row; this is empty if the time is unknown.
+func telemetryTime(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// WriteTelemetryCSV writes the telemetry table as a CSV file with a header row.
+//
+// Missing values are left empty.
+func WriteTelemetryCSV(writer io.Writer, table *TelemetryTable) error {
+	csvWriter := csv.NewWriter(writer)
+	header := append([]string{TelemetryColumnStreamID, TelemetryColumnTimestamp, TelemetryColumnTime}, table.Columns...)
+	err := csvWriter.Write(header)
+	if err != nil {
+		return err
+	}
+	for _, row := range table.Rows {
+		record := []string{row.StreamID, strconv.FormatUint(row.Timestamp, 10), telemetryTime(row.Time)}
+		for _, column := range table.Columns {
+			value, okay := row.Values[column]
+			if !okay {
+				record = append(record, "")
+				continue
+			}
+			if v, okay := value.(float64); okay {
+				record = append(record, strconv.FormatFloat(v, 'f', -1, 64))
+				continue
+			}
+			record = append(record, fmt.Sprintf("%v", value))
+		}
+		err = csvWriter.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteTelemetryNDJSON writes the telemetry table as newline-delimited JSON, with one object per row.
+//
+// The keys are in the same order as the CSV columns, and missing values are left out.
+func WriteTelemetryNDJSON(writer io.Writer, table *TelemetryTable) error {
+	for _, row := range table.Rows {
+		var timeValue interface{}
+		if !row.Time.IsZero() {
+			timeValue = telemetryTime(row.Time)
+		}
+		names := []string{TelemetryColumnStreamID, TelemetryColumnTimestamp, TelemetryColumnTime}
+		values := []interface{}{row.StreamID, row.Timestamp, timeValue}
+		for _, column := range table.Columns {
+			if value, okay := row.Values[column]; okay {
+				names = append(names, column)
+				values = append(values, value)
+			}
+		}
+
+		buffer := new(bytes.Buffer)
+		buffer.WriteString("{")
+		for i, name := range names {
+			value := values[i]
+			// JSON has no way to write these.
+			if v, okay := value.(float64); okay && (math.IsNaN(v) || math.IsInf(v, 0)) {
+				value = nil
+			}
+			nameBytes, err := json.Marshal(name)
+			if err != nil {
+				return err
+			}
+			valueBytes, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("could not encode %s: %v", name, err)
+			}
+			if i > 0 {
+				buffer.WriteString(",")
+			}
+			buffer.Write(nameBytes)
+			buffer.WriteString(":")
+			buffer.Write(valueBytes)
+		}
+		buffer.WriteString("}\n")
+
+		_, err := writer.Write(buffer.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package roscoconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// metersPerSecondPerMPH converts miles per hour into meters per second.
+const metersPerSecondPerMPH = 0.44704
+
+// Track is a GPS track, with one segment per recording.
+type Track struct {
+	Name     string
+	Segments []TrackSegment
+}
+
+// TrackSegment is the part of a GPS track from a single recording.
+type TrackSegment struct {
+	Name   string // The name of the recording.
+	Points []TrackPoint
+}
+
+// TrackPoint is a single GPS position.
+type TrackPoint struct {
+	Time      time.Time // Zero if unknown.
+	Latitude  float64   // In degrees; negative is south.
+	Longitude float64   // In degrees; negative is west.
+	Speed     float64   // In miles per hour.
+	HasSpeed  bool
+}
+
+// MakeTrack creates a GPS track from the telemetry in the given recordings.
+//
+// The segments are put in order by time.  Since the telemetry on the video frames repeats the same
+// position many times, a point is only kept if the position changed or a second has gone by.
+func MakeTrack(name string, infos []*rosco.FileInfo) *Track {
+	track := &Track{
+		Name: name,
+	}
+	for _, info := range infos {
+		segment := TrackSegment{
+			Name: path.Base(info.Filename),
+		}
+		var last *TrackPoint
+		for _, t := range primaryTelemetry(info.Telemetry(), "") {
+			if !t.HasPosition {
+				continue
+			}
+			point := TrackPoint{
+				Time:      t.Time,
+				Latitude:  t.Latitude,
+				Longitude: t.Longitude,
+				Speed:     t.Speed,
+				HasSpeed:  t.HasSpeed,
+			}
+			if last != nil && last.Latitude == point.Latitude && last.Longitude == point.Longitude && point.Time.Sub(last.Time) < time.Second {
+				continue
+			}
+			segment.Points = append(segment.Points, point)
+			last = &segment.Points[len(segment.Points)-1]
+		}
+		if len(segment.Points) > 0 {
+			track.Segments = append(track.Segments, segment)
+		}
+	}
+	sort.SliceStable(track.Segments, func(i, j int) bool {
+		return track.Segments[i].Points[0].Time.Before(track.Segments[j].Points[0].Time)
+	})
+	return track
+}
+
+// PointCount returns the number of points in the track.
+func (t *Track) PointCount() int {
+	count := 0
+	for _, segment := range t.Segments {
+		count += len(segment.Points)
+	}
+	return count
+}
+
+// xmlText escapes a string for use in XML.
+func xmlText(value string) string {
+	buffer := new(bytes.Buffer)
+	xml.EscapeText(buffer, []byte(value))
+	return buffer.String()
+}
+
+// WriteGPX writes the track as a GPX 1.1 file.
+//
+// The speed is written with the Garmin "TrackPointExtension", in meters per second.
+func WriteGPX(writer io.Writer, track *Track) error {
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(buffer, "<gpx version=\"1.1\" creator=\"rosco-dashcam-processor\" xmlns=\"http://www.topografix.com/GPX/1/1\" xmlns:gpxtpx=\"http://www.garmin.com/xmlschemas/TrackPointExtension/v2\">\n")
+	fmt.Fprintf(buffer, "  <trk>\n")
+	fmt.Fprintf(buffer, "    <name>%s</name>\n", xmlText(track.Name))
+	for _, segment := range track.Segments {
+		fmt.Fprintf(buffer, "    <trkseg>\n")
+		for _, point := range segment.Points {
+			fmt.Fprintf(buffer, "      <trkpt lat=\"%.7f\" lon=\"%.7f\">", point.Latitude, point.Longitude)
+			if !point.Time.IsZero() {
+				fmt.Fprintf(buffer, "<time>%s</time>", point.Time.UTC().Format("2006-01-02T15:04:05.000Z"))
+			}
+			if point.HasSpeed {
+				fmt.Fprintf(buffer, "<extensions><gpxtpx:TrackPointExtension><gpxtpx:speed>%.2f</gpxtpx:speed></gpxtpx:TrackPointExtension></extensions>", point.Speed*metersPerSecondPerMPH)
+			}
+			fmt.Fprintf(buffer, "</trkpt>\n")
+		}
+		fmt.Fprintf(buffer, "    </trkseg>\n")
+	}
+	fmt.Fprintf(buffer, "  </trk>\n")
+	fmt.Fprintf(buffer, "</gpx>\n")
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+// WriteKML writes the track as a KML file.
+//
+// Each segment is a "gx:Track" placemark, so that the times (and the speed, in miles per hour) are kept.
+func WriteKML(writer io.Writer, track *Track) error {
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(buffer, "<kml xmlns=\"http://www.opengis.net/kml/2.2\" xmlns:gx=\"http://www.google.com/kml/ext/2.2\">\n")
+	fmt.Fprintf(buffer, "  <Document>\n")
+	fmt.Fprintf(buffer, "    <name>%s</name>\n", xmlText(track.Name))
+	fmt.Fprintf(buffer, "    <Schema id=\"telemetry\">\n")
+	fmt.Fprintf(buffer, "      <gx:SimpleArrayField name=\"speed\" type=\"float\"><displayName>Speed (mph)</displayName></gx:SimpleArrayField>\n")
+	fmt.Fprintf(buffer, "    </Schema>\n")
+	for _, segment := range track.Segments {
+		fmt.Fprintf(buffer, "    <Placemark>\n")
+		fmt.Fprintf(buffer, "      <name>%s</name>\n", xmlText(segment.Name))
+		fmt.Fprintf(buffer, "      <gx:Track>\n")
+		for _, point := range segment.Points {
+			if point.Time.IsZero() {
+				fmt.Fprintf(buffer, "        <when/>\n")
+				continue
+			}
+			fmt.Fprintf(buffer, "        <when>%s</when>\n", point.Time.UTC().Format("2006-01-02T15:04:05.000Z"))
+		}
+		for _, point := range segment.Points {
+			fmt.Fprintf(buffer, "        <gx:coord>%.7f %.7f 0</gx:coord>\n", point.Longitude, point.Latitude)
+		}
+		fmt.Fprintf(buffer, "        <ExtendedData>\n")
+		fmt.Fprintf(buffer, "          <SchemaData schemaUrl=\"#telemetry\">\n")
+		fmt.Fprintf(buffer, "            <gx:SimpleArrayData name=\"speed\">\n")
+		for _, point := range segment.Points {
+			if point.HasSpeed {
+				fmt.Fprintf(buffer, "              <gx:value>%g</gx:value>\n", point.Speed)
+			} else {
+				fmt.Fprintf(buffer, "              <gx:value/>\n")
+			}
+		}
+		fmt.Fprintf(buffer, "            </gx:SimpleArrayData>\n")
+		fmt.Fprintf(buffer, "          </SchemaData>\n")
+		fmt.Fprintf(buffer, "        </ExtendedData>\n")
+		fmt.Fprintf(buffer, "      </gx:Track>\n")
+		fmt.Fprintf(buffer, "    </Placemark>\n")
+	}
+	fmt.Fprintf(buffer, "  </Document>\n")
+	fmt.Fprintf(buffer, "</kml>\n")
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+// geoJSONFeatureCollection is a GeoJSON "FeatureCollection".
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Name     string           `json:"name,omitempty"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// geoJSONFeature is a GeoJSON "Feature".
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// geoJSONGeometry is a GeoJSON geometry.
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"`
+}
+
+// WriteGeoJSON writes the track as a GeoJSON file.
+//
+// Each segment is a "LineString" feature; the times and speeds (in miles per hour) of its points are
+// in the "coordTimes" and "speeds" properties, which line up with the coordinates.
+func WriteGeoJSON(writer io.Writer, track *Track) error {
+	collection := geoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Name:     track.Name,
+		Features: []geoJSONFeature{},
+	}
+	for _, segment := range track.Segments {
+		coordinates := [][]float64{}
+		times := []interface{}{}
+		speeds := []interface{}{}
+		for _, point := range segment.Points {
+			coordinates = append(coordinates, []float64{point.Longitude, point.Latitude})
+			if point.Time.IsZero() {
+				times = append(times, nil)
+			} else {
+				times = append(times, point.Time.UTC().Format("2006-01-02T15:04:05.000Z"))
+			}
+			if point.HasSpeed {
+				speeds = append(speeds, point.Speed)
+			} else {
+				speeds = append(speeds, nil)
+			}
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type: "Feature",
+			Geometry: geoJSONGeometry{
+				Type:        "LineString",
+				Coordinates: coordinates,
+			},
+			Properties: map[string]interface{}{
+				"name":       segment.Name,
+				"coordTimes": times,
+				"speeds":     speeds,
+			},
+		})
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(collection)
+}
@@ -0,0 +1,259 @@
+package roscoconv
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nareix/joy4/codec/h264parser"
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// These are the audio codecs that a Rosco audio stream can contain.
+const (
+	AudioCodecPCM  = "pcm"
+	AudioCodecOpus = "opus"
+)
+
+// VideoTrack is a single logical video stream (all of its substreams), ready to be muxed.
+type VideoTrack struct {
+	StreamID string // The logical stream ID (for example, "0").
+	Codec    string // The codec from the chunks (for example, "H264").
+	Width    int
+	Height   int
+	SPS      []byte // The first usable SPS NAL unit (without a start code).
+	PPS      []byte // The first usable PPS NAL unit (without a start code).
+	Frames   []VideoFrame
+}
+
+// VideoFrame is a single frame of video.
+type VideoFrame struct {
+	Timestamp  uint64 // In microseconds.
+	IsKeyframe bool
+	Media      []byte // The raw media from the chunk.
+	Metadata   *rosco.Metadata
+}
+
+// AudioTrack is a single audio stream, ready to be muxed.
+type AudioTrack struct {
+	StreamID     string // The full stream ID (for example, "07").
+	Codec        string // One of the "AudioCodec" constants.
+	SampleRate   int
+	ChannelCount int
+	BitDepth     int // Only used for PCM.
+	FormatTag    int // The WAV format tag; only used for PCM.
+	Frames       []AudioFrame
+}
+
+// AudioFrame is a single packet of audio.
+type AudioFrame struct {
+	Timestamp uint64 // In microseconds.
+	Media     []byte
+}
+
+// LogicalStreamIDs returns the list of logical stream IDs (the first character of each stream ID).
+func LogicalStreamIDs(info *rosco.FileInfo) []string {
+	logicalStreamIDs := []string{}
+	logicalStreamMap := map[string]bool{}
+	for _, streamID := range info.StreamIDs() {
+		if len(streamID) != 2 {
+			continue
+		}
+		if logicalStreamMap[string(streamID[0])] {
+			continue
+		}
+		logicalStreamMap[string(streamID[0])] = true
+		logicalStreamIDs = append(logicalStreamIDs, string(streamID[0]))
+	}
+	return logicalStreamIDs
+}
+
+// FindAudioStreamID returns the ID of the audio stream for the given stream ID.
+//
+// If the stream ID is a logical stream ID (a single character), then this returns the first
+// substream that contains audio.  Otherwise, the stream ID is returned as-is.
+func FindAudioStreamID(info *rosco.FileInfo, streamID string) string {
+	if len(streamID) != 1 {
+		return streamID
+	}
+	for _, id := range info.StreamIDs() {
+		if !strings.HasPrefix(id, streamID) {
+			continue
+		}
+		for _, chunk := range info.ChunksForStreamID(id) {
+			if chunk.Audio != nil {
+				return id
+			}
+		}
+	}
+	return streamID
+}
+
+// AudioFormat returns the format of the audio in the given stream.
+//
+// If the stream ID ends in "7", then the data is raw PCM; otherwise, it is Opus.
+// Raw PCM is assumed to be 8-bit mu-law unless the metadata says otherwise; mu-law is always 8-bit,
+// so raw PCM with any other bit depth is assumed to be linear PCM.
+// Every export uses this, so that the audio is labelled the same way in every container.
+func AudioFormat(info *rosco.FileInfo, audioStreamID string) (rawPCM bool, bitDepth int, wavAudioFormat int) {
+	rawPCM = strings.HasSuffix(audioStreamID, "7")
+
+	wavAudioFormat = 0x0001 // PCM
+	bitDepth = 8
+	if rawPCM {
+		entry := info.Metadata.Entry("_audioBitDepth")
+		if entry != nil {
+			bitDepth = int(entry.Value.(int64))
+			logrus.Debugf("Audio bit depth (from the metadata): %d", bitDepth)
+		}
+
+		if bitDepth == 8 {
+			wavAudioFormat = 0x0007 // mu-law
+		}
+
+		entry = info.Metadata.Entry("_wavAudioFormat")
+		if entry != nil {
+			wavAudioFormat = int(entry.Value.(int64))
+			logrus.Debugf("WAV audio format (from the metadata): %d", wavAudioFormat)
+		}
+	}
+	return
+}
+
+// checkStreamID makes sure that the stream ID is either a logical stream ID (a single character)
+// or a full stream ID (two characters), and that the file has a stream with that ID.
+func checkStreamID(info *rosco.FileInfo, streamID string) error {
+	if len(streamID) != 1 && len(streamID) != 2 {
+		return fmt.Errorf("invalid stream ID %q: it must be a camera (such as \"0\") or a stream (such as \"00\")", streamID)
+	}
+	for _, id := range info.StreamIDs() {
+		if strings.HasPrefix(id, streamID) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no such stream: %s", streamID)
+}
+
+// MakeVideoTrack creates a `VideoTrack` for the given logical stream ID.
+//
+// The frames are kept in file (decode) order, and any frames before the first keyframe are dropped.
+func MakeVideoTrack(info *rosco.FileInfo, streamID string) (*VideoTrack, error) {
+	err := checkStreamID(info, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	track := &VideoTrack{
+		StreamID: streamID,
+	}
+
+	for _, chunk := range info.Chunks {
+		if chunk.Video == nil {
+			continue
+		}
+		if len(streamID) == 1 && !strings.HasPrefix(chunk.ID, streamID) {
+			continue
+		}
+		if len(streamID) == 2 && chunk.ID != streamID {
+			continue
+		}
+		isKeyframe := strings.HasSuffix(chunk.ID, "0")
+		// Strip out any frames before the first keyframe.  We can't do anything
+		// without a keyframe.
+		if len(track.Frames) == 0 && !isKeyframe {
+			continue
+		}
+		if track.Codec == "" {
+			track.Codec = chunk.Video.Codec
+		}
+		track.Frames = append(track.Frames, VideoFrame{
+			Timestamp:  chunk.Video.Timestamp,
+			IsKeyframe: isKeyframe,
+			Media:      chunk.Video.Media,
+			Metadata:   chunk.Video.Metadata,
+		})
+	}
+	if len(track.Frames) == 0 {
+		return nil, fmt.Errorf("no video frames in stream %s", streamID)
+	}
+	if track.Codec == "" {
+		track.Codec = "H264"
+	}
+
+	for frameIndex, frame := range track.Frames {
+		if !frame.IsKeyframe {
+			continue
+		}
+		nalus, _ := h264parser.SplitNALUs(frame.Media)
+		for _, nalu := range nalus {
+			if len(nalu) == 0 {
+				continue
+			}
+			switch nalu[0] & 0x1f {
+			case 7: // SPS
+				spsInfo, err := h264parser.ParseSPS(nalu)
+				if err != nil {
+					logrus.Debugf("Frame %d: Could not parse SPS: %v", frameIndex, err)
+					continue
+				}
+				if track.SPS == nil {
+					track.SPS = nalu
+				}
+				if int(spsInfo.Width) > track.Width {
+					track.Width = int(spsInfo.Width)
+				}
+				if int(spsInfo.Height) > track.Height {
+					track.Height = int(spsInfo.Height)
+				}
+			case 8: // PPS
+				if track.PPS == nil {
+					track.PPS = nalu
+				}
+			}
+		}
+		if track.SPS != nil && track.PPS != nil {
+			break
+		}
+	}
+	logrus.Debugf("Video track %s: %d frames, %dx%d", streamID, len(track.Frames), track.Width, track.Height)
+
+	return track, nil
+}
+
+// MakeAudioTrack creates an `AudioTrack` for the given stream ID.
+//
+// If the stream ID is a logical stream ID, then the first substream with audio is used.
+func MakeAudioTrack(info *rosco.FileInfo, streamID string) (*AudioTrack, error) {
+	audioStreamID := FindAudioStreamID(info, streamID)
+	rawPCM, bitDepth, wavAudioFormat := AudioFormat(info, audioStreamID)
+
+	track := &AudioTrack{
+		StreamID:     audioStreamID,
+		ChannelCount: 1,
+	}
+	if rawPCM {
+		track.Codec = AudioCodecPCM
+		track.SampleRate = 8000
+		track.BitDepth = bitDepth
+		track.FormatTag = wavAudioFormat
+	} else {
+		track.Codec = AudioCodecOpus
+		track.SampleRate = 48000
+		track.BitDepth = 16
+	}
+
+	for _, chunk := range info.ChunksForStreamID(audioStreamID) {
+		if chunk.Audio == nil {
+			continue
+		}
+		track.Frames = append(track.Frames, AudioFrame{
+			Timestamp: chunk.Audio.Timestamp,
+			Media:     chunk.Audio.Media,
+		})
+	}
+	if len(track.Frames) == 0 {
+		return nil, fmt.Errorf("no audio frames in stream %s", audioStreamID)
+	}
+
+	return track, nil
+}
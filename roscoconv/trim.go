@@ -0,0 +1,217 @@
+package roscoconv
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// NoEnd can be given as the end of a range to keep everything after the start.
+const NoEnd = math.MaxUint64
+
+// Trim returns a copy of the recording with only the chunks between the start and end timestamps
+// (in microseconds, in the same units as the chunk timestamps; the end is exclusive).
+//
+// Each camera's video starts at the keyframe at or before the start, since nothing before it can be
+// decoded.  Each audio stream is cut to start at the same time as its camera's video (or at the start,
+// if the camera has no video), and to end at the end.  Raw PCM audio is cut to the sample; Opus packets
+// cannot be split, so any packet that overlaps the range is kept whole.
+//
+// The chunk timestamps are not changed, so that they still line up with the wall-clock time.
+// The input recording is not modified.
+func Trim(info *rosco.FileInfo, start uint64, end uint64) (*rosco.FileInfo, error) {
+	if end <= start {
+		return nil, fmt.Errorf("the end (%v) is not after the start (%v)", time.Duration(end)*time.Microsecond, time.Duration(start)*time.Microsecond)
+	}
+
+	// Find the keyframe that each camera's video has to start from.  If there isn't one at or before
+	// the start, then the video starts from the first one.  A camera with no frames in the range is
+	// left out entirely.
+	keyframeTimestamps := map[string][]uint64{}
+	inRange := map[string]bool{}
+	for _, chunk := range info.Chunks {
+		if chunk.Video == nil || len(chunk.ID) != 2 {
+			continue
+		}
+		camera := chunk.ID[0:1]
+		if chunk.Video.Timestamp >= start && chunk.Video.Timestamp < end {
+			inRange[camera] = true
+		}
+		if strings.HasSuffix(chunk.ID, "0") {
+			keyframeTimestamps[camera] = append(keyframeTimestamps[camera], chunk.Video.Timestamp)
+		}
+	}
+	videoStarts := map[string]uint64{}
+	for camera, timestamps := range keyframeTimestamps {
+		if !inRange[camera] {
+			continue
+		}
+		sort.Slice(timestamps, func(i, j int) bool {
+			return timestamps[i] < timestamps[j]
+		})
+		videoStarts[camera] = timestamps[0]
+		for _, timestamp := range timestamps {
+			if timestamp <= start {
+				videoStarts[camera] = timestamp
+			}
+		}
+	}
+
+	result := &rosco.FileInfo{
+		Format:   info.Format,
+		Filename: info.Filename,
+		Unknown1: info.Unknown1,
+		Metadata: &rosco.Metadata{},
+	}
+	for _, chunk := range info.Chunks {
+		switch {
+		case chunk.Video != nil:
+			videoStart, okay := videoStarts[chunk.ID[0:1]]
+			if !okay || chunk.Video.Timestamp < videoStart || chunk.Video.Timestamp >= end {
+				continue
+			}
+			result.Chunks = append(result.Chunks, chunk)
+		case chunk.Audio != nil:
+			audioStart := start
+			if videoStart, okay := videoStarts[chunk.ID[0:1]]; okay {
+				audioStart = videoStart
+			}
+			newChunk, err := trimAudioChunk(info, chunk, audioStart, end)
+			if err != nil {
+				return nil, err
+			}
+			if newChunk != nil {
+				result.Chunks = append(result.Chunks, newChunk)
+			}
+		default:
+			result.Chunks = append(result.Chunks, chunk)
+		}
+	}
+
+	origin, haveOrigin := info.TimestampOrigin()
+	for _, packet := range info.GPSPackets {
+		if haveOrigin {
+			timestamp := packet.Timestamp.Sub(origin)
+			if timestamp < time.Duration(start)*time.Microsecond || (end != NoEnd && timestamp >= time.Duration(end)*time.Microsecond) {
+				continue
+			}
+		}
+		result.GPSPackets = append(result.GPSPackets, packet)
+	}
+
+	firstTimestamp, found := FirstTimestamp(result)
+	if !found {
+		return nil, fmt.Errorf("there is no audio or video %s", rangeString(start, end))
+	}
+
+	// The start time moves with the first chunk; the duration no longer applies.
+	startTime, haveStartTime := info.StartTime()
+	if haveStartTime {
+		if originalFirstTimestamp, okay := FirstTimestamp(info); okay {
+			startTime = startTime.Add(time.Duration(firstTimestamp-originalFirstTimestamp) * time.Microsecond)
+		}
+	}
+	if info.Metadata != nil {
+		for _, entry := range info.Metadata.Entries {
+			switch entry.Name {
+			case rosco.MetadataKeyStartTime, "_duration":
+				continue
+			}
+			result.Metadata.Entries = append(result.Metadata.Entries, entry)
+		}
+	}
+	if haveStartTime {
+		result.Metadata.Entries = append(result.Metadata.Entries, rosco.MetadataEntry{Type: rosco.MetadataTypeInt64, Name: rosco.MetadataKeyStartTime, Value: startTime.UnixMicro()})
+	}
+
+	return result, nil
+}
+
+// rangeString describes a range of timestamps, for messages.
+func rangeString(start uint64, end uint64) string {
+	if end == NoEnd {
+		return fmt.Sprintf("from %v on", time.Duration(start)*time.Microsecond)
+	}
+	return fmt.Sprintf("from %v to %v", time.Duration(start)*time.Microsecond, time.Duration(end)*time.Microsecond)
+}
+
+// FirstTimestamp returns the earliest audio or video timestamp in the recording.
+func FirstTimestamp(info *rosco.FileInfo) (uint64, bool) {
+	var firstTimestamp uint64
+	found := false
+	for _, chunk := range info.Chunks {
+		var timestamp uint64
+		switch {
+		case chunk.Video != nil:
+			timestamp = chunk.Video.Timestamp
+		case chunk.Audio != nil:
+			timestamp = chunk.Audio.Timestamp
+		default:
+			continue
+		}
+		if !found || timestamp < firstTimestamp {
+			firstTimestamp = timestamp
+			found = true
+		}
+	}
+	return firstTimestamp, found
+}
+
+// trimAudioChunk cuts an audio chunk down to the given range.
+//
+// This returns nil if none of the chunk is in the range.
+func trimAudioChunk(info *rosco.FileInfo, chunk *rosco.Chunk, start uint64, end uint64) (*rosco.Chunk, error) {
+	rawPCM, bitDepth, _ := AudioFormat(info, chunk.ID)
+	if chunk.Audio.Timestamp >= end {
+		return nil, nil
+	}
+
+	if !rawPCM {
+		samples, err := OpusPacketSamples(chunk.Audio.Media)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the Opus packet at %d: %v", chunk.Audio.Timestamp, err)
+		}
+		if chunk.Audio.Timestamp+uint64(samples)*1000000/48000 <= start {
+			return nil, nil
+		}
+		return chunk, nil
+	}
+
+	const sampleRate = 8000
+	bytesPerSample := bitDepth / 8
+	if bytesPerSample < 1 {
+		return nil, fmt.Errorf("unsupported bit depth: %d", bitDepth)
+	}
+	sampleCount := uint64(len(chunk.Audio.Media) / bytesPerSample)
+	timestamp := chunk.Audio.Timestamp
+
+	// Work out the samples to keep; a sample is kept if it starts in the range.
+	firstSample := uint64(0)
+	if start > timestamp {
+		firstSample = ((start-timestamp)*sampleRate + 999999) / 1000000
+	}
+	lastSample := sampleCount
+	if end-timestamp < sampleCount*1000000/sampleRate {
+		lastSample = ((end-timestamp)*sampleRate + 999999) / 1000000
+	}
+	if firstSample >= lastSample {
+		return nil, nil
+	}
+	if firstSample == 0 && lastSample == sampleCount {
+		return chunk, nil
+	}
+
+	audio := *chunk.Audio
+	audio.Timestamp = timestamp + firstSample*1000000/sampleRate
+	audio.Media = chunk.Audio.Media[firstSample*uint64(bytesPerSample) : lastSample*uint64(bytesPerSample)]
+	if len(chunk.Audio.ExtraMedia) == len(chunk.Audio.Media) {
+		audio.ExtraMedia = chunk.Audio.ExtraMedia[firstSample*uint64(bytesPerSample) : lastSample*uint64(bytesPerSample)]
+	}
+	newChunk := *chunk
+	newChunk.Audio = &audio
+	return &newChunk, nil
+}
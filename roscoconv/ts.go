@@ -0,0 +1,145 @@
+package roscoconv
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/nareix/joy4/codec/h264parser"
+	"github.com/sirupsen/logrus"
+	"github.com/tekkamanendless/rosco-dashcam-processor/mpegts"
+	"github.com/tekkamanendless/rosco-dashcam-processor/rosco"
+)
+
+// accessUnitDelimiter is an H.264 access unit delimiter NAL unit (any slice type).
+var accessUnitDelimiter = []byte{0x09, 0xf0}
+
+// MakeTS creates an `mpegts.File` instance based on this `rosco.FileInfo` one.
+//
+// Stream ID is the ID of the stream to export.  The audio (if any) is encoded as Opus.
+func MakeTS(info *rosco.FileInfo, streamID string) (*mpegts.File, error) {
+	videoTrack, err := MakeVideoTrack(info, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	audioTrack, err := MakeAudioTrack(info, streamID)
+	if err != nil {
+		logrus.Warnf("Could not find any audio for stream %s: %v", streamID, err)
+		audioTrack = nil
+	}
+	if audioTrack != nil {
+		audioTrack, err = EncodeOpus(audioTrack)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Line up all of the tracks on the earliest timestamp.
+	baseTimestamp := videoTrack.Frames[0].Timestamp
+	for _, frame := range videoTrack.Frames {
+		if frame.Timestamp < baseTimestamp {
+			baseTimestamp = frame.Timestamp
+		}
+	}
+	if audioTrack != nil && audioTrack.Frames[0].Timestamp < baseTimestamp {
+		baseTimestamp = audioTrack.Frames[0].Timestamp
+	}
+	clock := func(timestamp uint64) uint64 {
+		return (timestamp-baseTimestamp)*mpegts.ClockRate/1000000 + mpegts.InitialTimestamp
+	}
+
+	file := &mpegts.File{}
+
+	file.Streams = append(file.Streams, mpegts.Stream{
+		PID:        mpegts.PIDFirstElementaryStream,
+		StreamType: mpegts.StreamTypeH264,
+		StreamID:   mpegts.StreamIDVideo,
+		IsPCR:      true,
+	})
+	videoPackets := MakeTSVideoPackets(videoTrack, 0, clock)
+
+	audioPackets := []mpegts.Packet{}
+	if audioTrack != nil {
+		file.Streams = append(file.Streams, mpegts.Stream{
+			PID:         mpegts.PIDFirstElementaryStream + 1,
+			StreamType:  mpegts.StreamTypePrivate,
+			StreamID:    mpegts.StreamIDPrivate,
+			Descriptors: mpegts.OpusDescriptors(audioTrack.ChannelCount),
+		})
+		for _, frame := range audioTrack.Frames {
+			timestamp := clock(frame.Timestamp)
+			audioPackets = append(audioPackets, mpegts.Packet{
+				StreamIndex: 1,
+				PTS:         timestamp,
+				DTS:         timestamp,
+				IsKeyframe:  true,
+				Data:        mpegts.OpusAccessUnit(frame.Media),
+			})
+		}
+	}
+
+	// Interleave the packets by their decode time, keeping each stream in its original order.
+	for len(videoPackets) > 0 || len(audioPackets) > 0 {
+		if len(audioPackets) == 0 || (len(videoPackets) > 0 && videoPackets[0].DTS <= audioPackets[0].DTS) {
+			file.Packets = append(file.Packets, videoPackets[0])
+			videoPackets = videoPackets[1:]
+		} else {
+			file.Packets = append(file.Packets, audioPackets[0])
+			audioPackets = audioPackets[1:]
+		}
+	}
+
+	return file, nil
+}
+
+// MakeTSVideoPackets creates the transport stream packets for a video track.
+//
+// The frames are in decode order; their timestamps are presentation times.  The decode times are
+// the sorted presentation times, shifted back if necessary so that no frame is decoded after it
+// is presented.
+//
+// Clock converts a timestamp (in microseconds) into a 90 kHz clock value.
+func MakeTSVideoPackets(videoTrack *VideoTrack, streamIndex int, clock func(uint64) uint64) []mpegts.Packet {
+	presentationTimes := make([]uint64, len(videoTrack.Frames))
+	for i, frame := range videoTrack.Frames {
+		presentationTimes[i] = clock(frame.Timestamp)
+	}
+	decodeTimes := make([]uint64, len(presentationTimes))
+	copy(decodeTimes, presentationTimes)
+	sort.Slice(decodeTimes, func(i, j int) bool {
+		return decodeTimes[i] < decodeTimes[j]
+	})
+	var shift uint64
+	for i := range decodeTimes {
+		if decodeTimes[i] > presentationTimes[i] && decodeTimes[i]-presentationTimes[i] > shift {
+			shift = decodeTimes[i] - presentationTimes[i]
+		}
+	}
+
+	packets := []mpegts.Packet{}
+	for i, frame := range videoTrack.Frames {
+		decodeTime := decodeTimes[i]
+		if decodeTime >= shift {
+			decodeTime -= shift
+		} else {
+			decodeTime = 0
+		}
+
+		data := new(bytes.Buffer)
+		nalus, _ := h264parser.SplitNALUs(frame.Media)
+		if len(nalus) == 0 || len(nalus[0]) == 0 || nalus[0][0]&0x1f != h264parser.NALU_AUD {
+			data.Write(annexBStartCode)
+			data.Write(accessUnitDelimiter)
+		}
+		data.Write(ToAnnexB(frame.Media))
+
+		packets = append(packets, mpegts.Packet{
+			StreamIndex: streamIndex,
+			PTS:         presentationTimes[i],
+			DTS:         decodeTime,
+			IsKeyframe:  frame.IsKeyframe,
+			Data:        data.Bytes(),
+		})
+	}
+	return packets
+}